@@ -7,4 +7,7 @@ echo $(date)': building tracker ...'
 go build -o bin/tracker tracker/src/main.go
 
 echo $(date)': building sampler ...'
-go build -o bin/sampler sampler/src/main.go
\ No newline at end of file
+go build -o bin/sampler sampler/src/main.go
+
+echo $(date)': building scraperctl ...'
+go build -o bin/scraperctl scraperctl/src/main.go
\ No newline at end of file
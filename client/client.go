@@ -0,0 +1,303 @@
+// Package client calls the API of the service monitor, it follows the OpenAPI
+// document served at /openapi.json (monitor/src/monitor/openapi.json).
+//
+//	c := client.New("http://localhost:8090", client.WithUserKey(key))
+//	status, err := c.Check(ctx, []string{"jd.com"}, nil)
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const apiPrefix = "/v1"
+
+// Status is the status of a target, schema Status.
+type Status struct {
+	Availability           bool          `json:"availability"`
+	AccessTime             time.Duration `json:"access_time"`
+	Maintenance            bool          `json:"maintenance,omitempty"`
+	UnreachableDueToParent bool          `json:"unreachable_due_to_parent,omitempty"`
+	Suspect                bool          `json:"suspect,omitempty"`
+}
+
+// Target is a target with its status, schema Target.
+type Target struct {
+	Address   string   `json:"address"`
+	Tags      []string `json:"tags,omitempty"`
+	Composite string   `json:"composite,omitempty"`
+	Members   []string `json:"members,omitempty"`
+	Status
+}
+
+// Uptime is the uptime of a target since the monitor started, schema Uptime.
+type Uptime struct {
+	Up                     int64   `json:"up"`
+	Down                   int64   `json:"down"`
+	Maintenance            int64   `json:"maintenance"`
+	UnreachableDueToParent int64   `json:"unreachable_due_to_parent"`
+	Ratio                  float64 `json:"ratio"`
+}
+
+// Incident is a target gone down outside of a maintenance window, schema
+// Incident.
+type Incident struct {
+	Target     string `json:"target"`
+	OpenedAt   int64  `json:"opened_at"`
+	ResolvedAt int64  `json:"resolved_at,omitempty"`
+}
+
+// GraphNode is a target having dependencies or dependents, schema GraphNode.
+type GraphNode struct {
+	Target                 string   `json:"target"`
+	DependsOn              []string `json:"depends_on,omitempty"`
+	Dependents             []string `json:"dependents,omitempty"`
+	Availability           bool     `json:"availability"`
+	UnreachableDueToParent bool     `json:"unreachable_due_to_parent,omitempty"`
+}
+
+// MaintenanceWindow is a one-off window from Start to End, or a recurring
+// one of Duration seconds starting at the times of Cron, schema
+// MaintenanceWindow.
+type MaintenanceWindow struct {
+	ID       string   `json:"id,omitempty"`
+	Targets  []string `json:"targets,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Start    int64    `json:"start,omitempty"`
+	End      int64    `json:"end,omitempty"`
+	Cron     string   `json:"cron,omitempty"`
+	Duration int64    `json:"duration,omitempty"`
+	Comment  string   `json:"comment,omitempty"`
+}
+
+// Error is an error replied by the monitor, schema Error.
+type Error struct {
+	StatusCode int    `json:"-"`
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	RequestID  string `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("%s (%d %s, request_id: %s)", e.Message, e.StatusCode, e.Code, e.RequestID)
+	}
+	return fmt.Sprintf("%s (%d %s)", e.Message, e.StatusCode, e.Code)
+}
+
+// Client calls the monitor, its credentials are set by the options.
+type Client struct {
+	base_url    string
+	http_client *http.Client
+	user_key    string
+	admin_token string
+}
+
+type Option func(*Client)
+
+// WithUserKey authenticates the user's requests with the key issued by
+// /admin_user_create.
+func WithUserKey(key string) Option {
+	return func(c *Client) {
+		c.user_key = key
+	}
+}
+
+// WithAdminToken authenticates the admin's requests with the token.
+func WithAdminToken(token string) Option {
+	return func(c *Client) {
+		c.admin_token = token
+	}
+}
+
+// WithHTTPClient sets the HTTP client, ex: with a timeout or TLS options.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.http_client = hc
+	}
+}
+
+// New returns the client of the monitor at the address, ex:
+// http://localhost:8090.
+func New(base_url string, opts ...Option) *Client {
+	c := &Client{
+		base_url:    strings.TrimSuffix(base_url, "/"),
+		http_client: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// do calls the operation at the path with the body as JSON if not nil and
+// decodes its reply into x if not nil.
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, body, x interface{}) error {
+	u := c.base_url + apiPrefix + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	var rd io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rd = bytes.NewReader(data)
+	}
+	r, err := http.NewRequestWithContext(ctx, method, u, rd)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if c.user_key != "" {
+		r.Header.Set("user_key", c.user_key)
+	}
+	if c.admin_token != "" {
+		r.Header.Set("admin_token", c.admin_token)
+	}
+	r.Header.Set("Accept", "application/json")
+
+	resp, err := c.http_client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return parseError(resp.StatusCode, data)
+	}
+	if x == nil {
+		return nil
+	}
+	return json.Unmarshal(data, x)
+}
+
+func parseError(status_code int, data []byte) error {
+	var v struct {
+		Error Error `json:"error"`
+	}
+	if json.Unmarshal(data, &v) != nil || v.Error.Code == "" {
+		v.Error = Error{Code: "error", Message: strings.TrimSpace(string(data))}
+		if v.Error.Message == "" {
+			v.Error.Message = http.StatusText(status_code)
+		}
+	}
+	v.Error.StatusCode = status_code
+	return &v.Error
+}
+
+// Check returns the status of the targets by address, the targets having all
+// the tags if no target is given, operation check.
+func (c *Client) Check(ctx context.Context, targets, tags []string) (map[string]Status, error) {
+	q := url.Values{"target": targets, "tag": tags}
+	v := map[string]Status{}
+	err := c.do(ctx, http.MethodGet, "/check", q, nil, &v)
+	return v, err
+}
+
+// Force schedules the update of the status of the target, operation force.
+func (c *Client) Force(ctx context.Context, target string) error {
+	return c.do(ctx, http.MethodGet, "/force", url.Values{"target": {target}}, nil, nil)
+}
+
+// Min returns the fastest available target having all the tags, nil if none,
+// operation min.
+func (c *Client) Min(ctx context.Context, tags ...string) (*Target, error) {
+	var v *Target
+	err := c.do(ctx, http.MethodGet, "/min", url.Values{"tag": tags}, nil, &v)
+	return v, err
+}
+
+// Max returns the slowest available target having all the tags, nil if none,
+// operation max.
+func (c *Client) Max(ctx context.Context, tags ...string) (*Target, error) {
+	var v *Target
+	err := c.do(ctx, http.MethodGet, "/max", url.Values{"tag": tags}, nil, &v)
+	return v, err
+}
+
+type count struct {
+	Count int64 `json:"count"`
+}
+
+func timeRange(from, to time.Time) url.Values {
+	q := url.Values{"from": {strconv.FormatInt(from.Unix(), 10)}}
+	if !to.IsZero() {
+		q.Set("to", strconv.FormatInt(to.Unix(), 10))
+	}
+	return q
+}
+
+// QueryOne counts the requests of the user in the range of time, a zero end
+// is the current time, operation adminQueryOne.
+func (c *Client) QueryOne(ctx context.Context, user string, from, to time.Time) (int64, error) {
+	q := timeRange(from, to)
+	q.Set("user", user)
+	var v count
+	err := c.do(ctx, http.MethodGet, "/admin_query_one", q, nil, &v)
+	return v.Count, err
+}
+
+// QueryAll counts the requests of all users in the range of time, a zero end
+// is the current time, operation adminQueryAll.
+func (c *Client) QueryAll(ctx context.Context, from, to time.Time) (int64, error) {
+	var v count
+	err := c.do(ctx, http.MethodGet, "/admin_query_all", timeRange(from, to), nil, &v)
+	return v.Count, err
+}
+
+// Uptime returns the uptime of the targets by address, operation uptime.
+func (c *Client) Uptime(ctx context.Context, targets []string) (map[string]Uptime, error) {
+	v := map[string]Uptime{}
+	err := c.do(ctx, http.MethodGet, "/uptime", url.Values{"target": targets}, nil, &v)
+	return v, err
+}
+
+// Incidents returns the recent incidents, operation adminIncidents.
+func (c *Client) Incidents(ctx context.Context) ([]Incident, error) {
+	var v []Incident
+	err := c.do(ctx, http.MethodGet, "/admin_incidents", nil, nil, &v)
+	return v, err
+}
+
+// Dependencies returns the dependency graph of the targets, operation
+// adminDependencies.
+func (c *Client) Dependencies(ctx context.Context) ([]GraphNode, error) {
+	var v []GraphNode
+	err := c.do(ctx, http.MethodGet, "/admin_dependencies", nil, nil, &v)
+	return v, err
+}
+
+// Maintenance returns the maintenance windows, operation listMaintenance.
+func (c *Client) Maintenance(ctx context.Context) ([]MaintenanceWindow, error) {
+	var v []MaintenanceWindow
+	err := c.do(ctx, http.MethodGet, "/admin_maintenance", nil, nil, &v)
+	return v, err
+}
+
+// AddMaintenance creates the window and returns it with its id, operation
+// addMaintenance.
+func (c *Client) AddMaintenance(ctx context.Context, mw MaintenanceWindow) (MaintenanceWindow, error) {
+	var v MaintenanceWindow
+	err := c.do(ctx, http.MethodPost, "/admin_maintenance", nil, mw, &v)
+	return v, err
+}
+
+// RemoveMaintenance removes the window, operation removeMaintenance.
+func (c *Client) RemoveMaintenance(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/admin_maintenance", url.Values{"id": {id}}, nil, nil)
+}
@@ -0,0 +1,403 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"scraper/client"
+	"scraper/libs"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadOpenAPI decodes the OpenAPI document of the monitor
+func loadOpenAPI(t *testing.T) map[string]interface{} {
+	data, err := os.ReadFile("../monitor/src/monitor/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]interface{}
+	err = json.Unmarshal(data, &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// lookup returns the value at the keys of nested objects, or nil
+func lookup(x interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		m, ok := x.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		x = m[k]
+	}
+	return x
+}
+
+// resolve follows the references of the document, ex: #/components/schemas/Status
+func resolve(doc map[string]interface{}, x interface{}) interface{} {
+	for {
+		ref, ok := lookup(x, "$ref").(string)
+		if !ok {
+			return x
+		}
+		x = lookup(doc, strings.Split(strings.TrimPrefix(ref, "#/"), "/")...)
+	}
+}
+
+// checkReply validates a reply against the OpenAPI document, its status is
+// documented for the operation and its body matches the schema.
+func checkReply(doc map[string]interface{}, method, path string, code int, body []byte) error {
+	op := lookup(doc, "paths", path, strings.ToLower(method))
+	if op == nil {
+		return fmt.Errorf("%s %s is not documented", method, path)
+	}
+	reply := resolve(doc, lookup(op, "responses", strconv.Itoa(code)))
+	if reply == nil {
+		return fmt.Errorf("status %d of %s %s is not documented", code, method, path)
+	}
+	schema := lookup(reply, "content", "application/json", "schema")
+	if schema == nil {
+		return nil
+	}
+	var v interface{}
+	err := json.Unmarshal(body, &v)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	return checkSchema(doc, schema, v, method+" "+path)
+}
+
+// checkSchema validates a decoded JSON value against a schema, the properties
+// not in the schema are errors.
+func checkSchema(doc map[string]interface{}, schema, v interface{}, at string) error {
+	s, _ := resolve(doc, schema).(map[string]interface{})
+	if v == nil {
+		if s["nullable"] == true {
+			return nil
+		}
+		return fmt.Errorf("%s: unexpected null", at)
+	}
+	if parts, ok := s["allOf"].([]interface{}); ok {
+		// the parts are merged, a property of a part is known to the others
+		merged := map[string]interface{}{"type": "object"}
+		properties := map[string]interface{}{}
+		var required []interface{}
+		for _, p := range parts {
+			p = resolve(doc, p)
+			for k, x := range lookup(p, "properties").(map[string]interface{}) {
+				properties[k] = x
+			}
+			r, _ := lookup(p, "required").([]interface{})
+			required = append(required, r...)
+		}
+		merged["properties"], merged["required"] = properties, required
+		s = merged
+	}
+	if e, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, x := range e {
+			found = found || x == v
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not in %v", at, v, e)
+		}
+	}
+
+	switch s["type"] {
+	case "object":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an object, got %v", at, v)
+		}
+		required, _ := s["required"].([]interface{})
+		for _, k := range required {
+			if _, ok := m[k.(string)]; !ok {
+				return fmt.Errorf("%s: missing %s", at, k)
+			}
+		}
+		for k, x := range m {
+			p := lookup(s, "properties", k)
+			if p == nil {
+				p = s["additionalProperties"]
+			}
+			if p == nil {
+				return fmt.Errorf("%s: unknown property %s", at, k)
+			}
+			if err := checkSchema(doc, p, x, at+"."+k); err != nil {
+				return err
+			}
+		}
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected an array, got %v", at, v)
+		}
+		for i, x := range a {
+			if err := checkSchema(doc, s["items"], x, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("%s: expected a string, got %v", at, v)
+		}
+	case "integer":
+		if x, ok := v.(float64); !ok || x != math.Trunc(x) {
+			return fmt.Errorf("%s: expected an integer, got %v", at, v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: expected a number, got %v", at, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: expected a boolean, got %v", at, v)
+		}
+	}
+	return nil
+}
+
+// newMonitor returns a fake monitor checking the credentials and recording
+// the paths called. Its replies are validated against the OpenAPI document
+// of the monitor, the fake cannot drift from the real API.
+func newMonitor(t *testing.T, called map[string]bool) *httptest.Server {
+	doc := loadOpenAPI(t)
+	mux := http.NewServeMux()
+	user := func(fn http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("user_key") != "alice-key" {
+				libs.ServerError(w, errors.New("invalid user key"), http.StatusUnauthorized)
+				return
+			}
+			fn(w, r)
+		}
+	}
+	admin := func(fn http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("admin_token") != "ops" {
+				libs.ServerError(w, errors.New("incorrect admin token"), http.StatusUnauthorized)
+				return
+			}
+			fn(w, r)
+		}
+	}
+
+	mux.HandleFunc("/v1/check", user(func(w http.ResponseWriter, r *http.Request) {
+		v := map[string]client.Status{}
+		for _, s := range r.URL.Query()["target"] {
+			v[s] = client.Status{Availability: true, AccessTime: 12 * time.Millisecond}
+		}
+		libs.JSONReply(w, v)
+	}))
+	mux.HandleFunc("/v1/force", user(func(w http.ResponseWriter, r *http.Request) {
+		libs.JSONReply(w, map[string]string{"target": r.URL.Query().Get("target")})
+	}))
+	mux.HandleFunc("/v1/min", user(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Query()["tag"]) > 0 {
+			libs.JSONReply(w, nil)
+			return
+		}
+		libs.JSONReply(w, client.Target{Address: "live.com", Status: client.Status{Availability: true, AccessTime: time.Millisecond}})
+	}))
+	mux.HandleFunc("/v1/max", user(func(w http.ResponseWriter, r *http.Request) {
+		libs.JSONReply(w, client.Target{Address: "jd.com", Tags: []string{"region=cn"}, Status: client.Status{Availability: true, AccessTime: time.Second}})
+	}))
+	mux.HandleFunc("/v1/admin_query_one", admin(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("user") != "alice" || q.Get("from") != "1700000000" || q.Get("to") != "1700003600" {
+			libs.BadRequest(w, errors.New("unexpected query "+r.URL.RawQuery))
+			return
+		}
+		libs.JSONReply(w, map[string]int64{"count": 42})
+	}))
+	mux.HandleFunc("/v1/admin_query_all", admin(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("to") {
+			libs.BadRequest(w, errors.New("unexpected end"))
+			return
+		}
+		libs.JSONReply(w, map[string]int64{"count": 1000})
+	}))
+	mux.HandleFunc("/v1/uptime", user(func(w http.ResponseWriter, r *http.Request) {
+		libs.JSONReply(w, map[string]client.Uptime{"jd.com": {Up: 3, Down: 1, Ratio: 0.75}})
+	}))
+	mux.HandleFunc("/v1/admin_incidents", admin(func(w http.ResponseWriter, r *http.Request) {
+		libs.JSONReply(w, []client.Incident{{Target: "jd.com", OpenedAt: 1700000000}})
+	}))
+	mux.HandleFunc("/v1/admin_dependencies", admin(func(w http.ResponseWriter, r *http.Request) {
+		libs.JSONReply(w, []client.GraphNode{{Target: "proxy.local:3128", Dependents: []string{"jd.com"}}})
+	}))
+	windows := []client.MaintenanceWindow{}
+	mux.HandleFunc("/v1/admin_maintenance", admin(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			libs.JSONReply(w, windows)
+		case http.MethodPost:
+			var mw client.MaintenanceWindow
+			if err := libs.JSONParse(r, &mw); err != nil {
+				libs.BadRequest(w, err)
+				return
+			}
+			mw.ID = "w1"
+			windows = append(windows, mw)
+			libs.JSONReply(w, mw)
+		case http.MethodDelete:
+			if r.URL.Query().Get("id") != "w1" || len(windows) == 0 {
+				libs.ServerError(w, libs.ErrNotFound, http.StatusNotFound)
+				return
+			}
+			windows = windows[:0]
+			libs.JSONReply(w, client.MaintenanceWindow{ID: "w1"})
+		}
+	}))
+
+	ts := httptest.NewServer(libs.LogHandler(libs.APIVersionHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := r.URL.Path[len(libs.APIPrefix):]
+		called[r.Method+" "+path] = true
+		rec := httptest.NewRecorder()
+		for k, v := range w.Header() {
+			rec.Header()[k] = v
+		}
+		mux.ServeHTTP(rec, r)
+		if err := checkReply(doc, r.Method, path, rec.Code, rec.Body.Bytes()); err != nil {
+			t.Error(err)
+		}
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}))))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestUser(t *testing.T) {
+	ctx := context.Background()
+	ts := newMonitor(t, map[string]bool{})
+	c := client.New(ts.URL, client.WithUserKey("alice-key"))
+
+	status, err := c.Check(ctx, []string{"jd.com", "live.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]client.Status{
+		"jd.com":   {Availability: true, AccessTime: 12 * time.Millisecond},
+		"live.com": {Availability: true, AccessTime: 12 * time.Millisecond},
+	}
+	if !reflect.DeepEqual(status, expected) {
+		t.Errorf("unexpected status %v", status)
+	}
+
+	err = c.Force(ctx, "jd.com")
+	if err != nil {
+		t.Error(err)
+	}
+
+	p, err := c.Min(ctx)
+	if err != nil || p == nil || p.Address != "live.com" {
+		t.Errorf("unexpected min %v, error %v", p, err)
+	}
+	p, err = c.Min(ctx, "team=none")
+	if err != nil || p != nil {
+		t.Errorf("unexpected min %v, error %v", p, err)
+	}
+	p, err = c.Max(ctx)
+	if err != nil || p == nil || p.Address != "jd.com" || p.AccessTime != time.Second || p.Tags[0] != "region=cn" {
+		t.Errorf("unexpected max %v, error %v", p, err)
+	}
+
+	// a wrong key is replied the error envelope
+	_, err = client.New(ts.URL, client.WithUserKey("wrong")).Check(ctx, []string{"jd.com"}, nil)
+	var e *client.Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusUnauthorized || e.Code != "unauthorized" || e.Message != "invalid user key" || e.RequestID == "" {
+		t.Errorf("unexpected error %#v", err)
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+	ts := newMonitor(t, map[string]bool{})
+	c := client.New(ts.URL, client.WithAdminToken("ops"))
+
+	n, err := c.QueryOne(ctx, "alice", time.Unix(1700000000, 0), time.Unix(1700003600, 0))
+	if err != nil || n != 42 {
+		t.Errorf("unexpected count %d, error %v", n, err)
+	}
+	n, err = c.QueryAll(ctx, time.Unix(1700000000, 0), time.Time{})
+	if err != nil || n != 1000 {
+		t.Errorf("unexpected count %d, error %v", n, err)
+	}
+
+	_, err = client.New(ts.URL).QueryAll(ctx, time.Unix(1700000000, 0), time.Time{})
+	var e *client.Error
+	if !errors.As(err, &e) || e.StatusCode != http.StatusUnauthorized || e.Code != "unauthorized" {
+		t.Errorf("unexpected error %#v", err)
+	}
+
+	incidents, err := c.Incidents(ctx)
+	if err != nil || len(incidents) != 1 || incidents[0].Target != "jd.com" {
+		t.Errorf("unexpected incidents %v, error %v", incidents, err)
+	}
+	graph, err := c.Dependencies(ctx)
+	if err != nil || len(graph) != 1 || graph[0].Dependents[0] != "jd.com" {
+		t.Errorf("unexpected graph %v, error %v", graph, err)
+	}
+
+	mw, err := c.AddMaintenance(ctx, client.MaintenanceWindow{Targets: []string{"jd.com"}, Cron: "0 2 * * *", Duration: 600})
+	if err != nil || mw.ID != "w1" || mw.Cron != "0 2 * * *" {
+		t.Errorf("unexpected window %v, error %v", mw, err)
+	}
+	windows, err := c.Maintenance(ctx)
+	if err != nil || len(windows) != 1 || windows[0].Targets[0] != "jd.com" {
+		t.Errorf("unexpected windows %v, error %v", windows, err)
+	}
+	if err = c.RemoveMaintenance(ctx, "w1"); err != nil {
+		t.Error(err)
+	}
+	if err = c.RemoveMaintenance(ctx, "w1"); !errors.As(err, &e) || e.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected error %#v", err)
+	}
+}
+
+// TestOpenAPI checks the operations called by the client are in the OpenAPI
+// document of the monitor.
+func TestOpenAPI(t *testing.T) {
+	doc := loadOpenAPI(t)
+	called := map[string]bool{}
+	ctx := context.Background()
+	ts := newMonitor(t, called)
+	c := client.New(ts.URL, client.WithUserKey("alice-key"), client.WithAdminToken("ops"))
+	c.Check(ctx, []string{"jd.com"}, nil)
+	c.Force(ctx, "jd.com")
+	c.Min(ctx)
+	c.Max(ctx)
+	c.QueryOne(ctx, "alice", time.Unix(1700000000, 0), time.Unix(1700003600, 0))
+	c.QueryAll(ctx, time.Unix(1700000000, 0), time.Time{})
+	c.Uptime(ctx, []string{"jd.com"})
+	c.Incidents(ctx)
+	c.Dependencies(ctx)
+	c.Maintenance(ctx)
+	c.AddMaintenance(ctx, client.MaintenanceWindow{Targets: []string{"jd.com"}, Start: 1700000000, End: 1700003600})
+	c.RemoveMaintenance(ctx, "w1")
+
+	if len(called) != 12 {
+		t.Errorf("unexpected calls %v", called)
+	}
+	for call := range called {
+		method, path, _ := strings.Cut(call, " ")
+		if lookup(doc, "paths", path, strings.ToLower(method)) == nil {
+			t.Errorf("%s is not in the OpenAPI document", call)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package libs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	APIVersion = "v1"
+	APIPrefix  = "/" + APIVersion
+
+	// APIVersionHeader is set to the responses of the versioned API, their
+	// errors are replied in the JSON envelope.
+	APIVersionHeader = "API-Version"
+)
+
+var ErrNotFound = errors.New(http.StatusText(http.StatusNotFound))
+
+// APIError is an error replied by the versioned API, the code is stable and
+// derived from the status code, ex: "not_found".
+type APIError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func (e APIError) Error() string {
+	return e.Message
+}
+
+// ErrorEnvelope is the JSON body of an error of the versioned API, ex:
+// {"error": {"code": "unauthorized", "message": "invalid user key", "request_id": "899ca8e31c49e875"}}
+type ErrorEnvelope struct {
+	Error APIError `json:"error"`
+}
+
+// ErrorCode returns the code of the errors replied with the status code.
+func ErrorCode(status_code int) string {
+	s := http.StatusText(status_code)
+	if s == "" {
+		return "error"
+	}
+	s = strings.ToLower(s)
+	s = strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(s)
+	return s
+}
+
+// NewErrorEnvelope returns the envelope of the error replied with the status
+// code, with the id of the request set by LogHandler.
+func NewErrorEnvelope(w http.ResponseWriter, err error, status_code int) ErrorEnvelope {
+	return ErrorEnvelope{APIError{
+		Code:      ErrorCode(status_code),
+		Message:   err.Error(),
+		RequestID: w.Header().Get(RequestIDHeader),
+	}}
+}
+
+// Versioned reports whether the response belongs to the versioned API, ex:
+// to reply JSON instead of a legacy body.
+func Versioned(w http.ResponseWriter) bool {
+	return w.Header().Get(APIVersionHeader) != ""
+}
+
+// APIVersionHandler marks the responses of the requests under APIPrefix, it
+// comes before the handlers replying errors.
+func APIVersionHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == APIPrefix || strings.HasPrefix(r.URL.Path, APIPrefix+"/") {
+			w.Header().Set(APIVersionHeader, APIVersion)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// NotFound replies 404, ex: to the unknown paths of the versioned API.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	ServerError(w, ErrNotFound, http.StatusNotFound)
+}
+
+// ParseError returns the error of a reply of the versioned API, or of an
+// unversioned one as text.
+func ParseError(status_code int, body []byte) error {
+	var v ErrorEnvelope
+	if json.Unmarshal(body, &v) == nil && v.Error.Code != "" {
+		return v.Error
+	}
+	e := APIError{Code: ErrorCode(status_code), Message: strings.TrimSpace(string(body))}
+	if e.Message == "" {
+		e.Message = http.StatusText(status_code)
+	}
+	return e
+}
+
+// statusOf returns the status code of an error code of the versioned API, 0
+// if unknown
+func statusOf(code string) int {
+	for i := 400; i < 600; i++ {
+		if http.StatusText(i) != "" && ErrorCode(i) == code {
+			return i
+		}
+	}
+	return 0
+}
+
+var grpcStatus = map[codes.Code]int{
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.FailedPrecondition: http.StatusBadRequest,
+	codes.NotFound:           http.StatusNotFound,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+}
+
+// UpstreamStatus returns the status code replying the error of a call to
+// another service, over HTTP, gRPC or in process. The errors of the request,
+// ex: an invalid range, keep their code, the timeouts are 504, the failures
+// of the service or of the transport 502 and the other errors 500.
+func UpstreamStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+
+	code := 0
+	var e APIError
+	var ne net.Error
+	if errors.As(err, &e) {
+		code = statusOf(e.Code)
+	} else if s, ok := status.FromError(err); ok {
+		code = grpcStatus[s.Code()]
+	} else if !errors.As(err, &ne) && !errors.Is(err, ErrCircuitOpen) {
+		return http.StatusInternalServerError
+	}
+
+	switch code {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusUnprocessableEntity,
+		http.StatusTooManyRequests, http.StatusGatewayTimeout:
+		return code
+	}
+	return http.StatusBadGateway
+}
@@ -0,0 +1,93 @@
+package libs_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"scraper/libs"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorEnvelope(t *testing.T) {
+	ErrDenied := errors.New("denied")
+	h := libs.LogHandler(libs.APIVersionHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		libs.ServerError(w, ErrDenied, http.StatusForbidden)
+	})))
+
+	r := httptest.NewRequest(http.MethodGet, "/v1/check", nil)
+	r.Header.Set(libs.RequestIDHeader, "abc")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %v", w.Code, w.Header())
+	}
+	var v libs.ErrorEnvelope
+	err := json.Unmarshal(w.Body.Bytes(), &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := libs.APIError{Code: "forbidden", Message: "denied", RequestID: "abc"}
+	if v.Error != expected {
+		t.Errorf("unexpected error %+v", v.Error)
+	}
+	if err := libs.ParseError(w.Code, w.Body.Bytes()); err.(libs.APIError) != expected {
+		t.Errorf("unexpected parsed error %+v", err)
+	}
+
+	// the unversioned API replies text
+	r = httptest.NewRequest(http.MethodGet, "/check", nil)
+	r.Header.Set(libs.RequestIDHeader, "abc")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if !strings.HasPrefix(w.Body.String(), "denied (request_id: abc)") {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+	if err := libs.ParseError(w.Code, w.Body.Bytes()); err.Error() != "denied (request_id: abc)" {
+		t.Errorf("unexpected parsed error %q", err)
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	for status, code := range map[int]string{
+		http.StatusBadRequest:            "bad_request",
+		http.StatusMethodNotAllowed:      "method_not_allowed",
+		http.StatusRequestEntityTooLarge: "request_entity_too_large",
+		http.StatusBadGateway:            "bad_gateway",
+		599:                              "error",
+	} {
+		if s := libs.ErrorCode(status); s != code {
+			t.Errorf("unexpected code %q of %d", s, status)
+		}
+	}
+}
+
+func TestUpstreamStatus(t *testing.T) {
+	for name, c := range map[string]struct {
+		err    error
+		status int
+	}{
+		"invalid range":      {libs.ParseError(http.StatusBadRequest, []byte("invalid range")), http.StatusBadRequest},
+		"tracker failure":    {libs.ParseError(http.StatusInternalServerError, nil), http.StatusBadGateway},
+		"unauthorized":       {libs.ParseError(http.StatusUnauthorized, nil), http.StatusBadGateway},
+		"grpc argument":      {status.Error(codes.InvalidArgument, "invalid range"), http.StatusBadRequest},
+		"grpc unavailable":   {status.Error(codes.Unavailable, "down"), http.StatusBadGateway},
+		"grpc deadline":      {status.Error(codes.DeadlineExceeded, "slow"), http.StatusGatewayTimeout},
+		"connection refused": {&url.Error{Op: "Get", URL: "http://localhost:1", Err: &net.OpError{Op: "dial", Err: errors.New("refused")}}, http.StatusBadGateway},
+		"breaker":            {libs.ErrCircuitOpen, http.StatusBadGateway},
+		"timeout":            {fmt.Errorf("query: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		"in process":         {errors.New("database closed"), http.StatusInternalServerError},
+	} {
+		if s := libs.UpstreamStatus(c.err); s != c.status {
+			t.Errorf("%s: unexpected status %d", name, s)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package libs
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+var (
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+)
+
+// BreakerState is the state of a circuit breaker reported in health outputs.
+type BreakerState struct {
+	State    string `json:"state"`
+	Failures int    `json:"failures"`            // the consecutive failures
+	OpenedAt int64  `json:"opened_at,omitempty"` // unix-epoch second
+}
+
+// Breaker is a circuit breaker: it opens after consecutive failures and
+// rejects the calls for the cooldown, then lets a single call probe the
+// service, the breaker closes if it succeeds and opens again otherwise.
+type Breaker struct {
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	opened_at time.Time
+	probe_at  time.Time // the start of the probing call when half-open
+	mtx       sync.Mutex
+}
+
+// NewBreaker creates a breaker opening after the number of consecutive
+// failures, 0 never opens.
+func NewBreaker(failures int, cooldown time.Duration) *Breaker {
+	return &Breaker{threshold: failures, cooldown: cooldown, state: BreakerClosed}
+}
+
+// Allow returns ErrCircuitOpen if the call must not be made.
+func (b *Breaker) Allow() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.opened_at) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probe_at = time.Now()
+		return nil
+	case BreakerHalfOpen:
+		// a probe is in flight, another one is allowed if it never reported
+		if time.Since(b.probe_at) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.probe_at = time.Now()
+	}
+	return nil
+}
+
+func (b *Breaker) Success() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.state = BreakerClosed
+	b.failures = 0
+}
+
+func (b *Breaker) Failure() {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.state = BreakerOpen
+		b.opened_at = time.Now()
+	}
+}
+
+func (b *Breaker) State() BreakerState {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	x := BreakerState{State: b.state, Failures: b.failures}
+	if b.state != BreakerClosed {
+		x.OpenedAt = b.opened_at.Unix()
+	}
+	return x
+}
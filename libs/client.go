@@ -0,0 +1,259 @@
+package libs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ResilienceOptions configures the timeouts, retries and circuit breaker of
+// a service client.
+type ResilienceOptions struct {
+	Timeout         time.Duration // per attempt, including reading the body
+	Retries         int           // the attempts after the first one
+	RetryDelay      time.Duration // the base of the exponential backoff
+	MaxRetryDelay   time.Duration
+	BreakerFailures int // the consecutive failures opening the breaker, 0 to disable
+	BreakerCooldown time.Duration
+}
+
+var DefaultResilience = ResilienceOptions{
+	Timeout:         10 * time.Second,
+	Retries:         2,
+	RetryDelay:      200 * time.Millisecond,
+	MaxRetryDelay:   5 * time.Second,
+	BreakerFailures: 5,
+	BreakerCooldown: 30 * time.Second,
+}
+
+// ServiceClient calls an internal service, every request carries the
+// service's API key in the header "api-key" checked by middleware.APIKey.
+// Failed calls are retried with backoff and a circuit breaker stops calling
+// a service which keeps failing.
+type ServiceClient struct {
+	Client  *http.Client
+	APIKey  string
+	Retry   ResilienceOptions
+	Breaker *Breaker
+	tls     *tls.Config // the TLS configuration of the gRPC connections, nil without TLS
+}
+
+func NewServiceClient(api_key string, opts *TLSOptions) (*ServiceClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	var cfg *tls.Config
+	if opts != nil && opts.Enabled() {
+		var err error
+		cfg, err = opts.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = cfg.Clone()
+	}
+	sc := &ServiceClient{
+		Client: &http.Client{Transport: transport},
+		APIKey: api_key,
+		tls:    cfg,
+	}
+	sc.SetResilience(DefaultResilience)
+	return sc, nil
+}
+
+// SetResilience sets the timeouts and retries, the breaker is reset.
+func (sc *ServiceClient) SetResilience(opts ResilienceOptions) {
+	sc.Retry = opts
+	sc.Client.Timeout = opts.Timeout
+	sc.Breaker = NewBreaker(opts.BreakerFailures, opts.BreakerCooldown)
+}
+
+// Authorize sets the API key to the request.
+func (sc *ServiceClient) Authorize(r *http.Request) {
+	if sc.APIKey != "" {
+		r.Header.Set("api-key", sc.APIKey)
+	}
+}
+
+// failed reports whether the call counts as a failure of the service.
+func failed(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryable reports whether the request can be sent again after the error:
+// idempotent requests are, the other ones only if they were never sent.
+func retryable(r *http.Request, err error) bool {
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		return false
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	var op *net.OpError
+	return err != nil && errors.As(err, &op) && op.Op == "dial"
+}
+
+// backoff returns the delay before the attempt, exponential with jitter.
+func (sc *ServiceClient) backoff(attempt int) time.Duration {
+	d := sc.Retry.RetryDelay << (attempt - 1)
+	if d <= 0 || (sc.Retry.MaxRetryDelay > 0 && d > sc.Retry.MaxRetryDelay) {
+		d = sc.Retry.MaxRetryDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Do sends the request, retrying it on failures while the breaker allows. The
+// call is traced as a client span propagated to the service.
+func (sc *ServiceClient) Do(r *http.Request) (resp *http.Response, err error) {
+	attempt := 0
+	ctx, span := StartSpan(r.Context(), r.Method+" "+r.URL.Path, SpanClient)
+	if span != nil {
+		r = r.WithContext(ctx)
+		span.SetAttr("http.method", r.Method)
+		span.SetAttr("http.url", r.URL.Redacted())
+		defer func() {
+			span.SetAttr("http.attempts", attempt+1)
+			if resp != nil {
+				span.SetAttr("http.status_code", resp.StatusCode)
+				if resp.StatusCode >= 500 {
+					span.SetError(fmt.Errorf("status %d", resp.StatusCode))
+				}
+			}
+			span.SetError(err)
+			span.End()
+		}()
+	}
+	Inject(ctx, r.Header)
+	if id := RequestID(ctx); id != "" {
+		r.Header.Set(RequestIDHeader, id)
+	}
+	sc.Authorize(r)
+
+	for ; ; attempt++ {
+		if err := sc.Breaker.Allow(); err != nil {
+			return nil, err
+		}
+
+		req := r
+		if attempt > 0 && r.GetBody != nil {
+			body, err := r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = r.Clone(r.Context())
+			req.Body = body
+		}
+
+		resp, err = sc.Client.Do(req)
+		if !failed(resp, err) {
+			sc.Breaker.Success()
+			return resp, err
+		}
+		if r.Context().Err() != nil {
+			return resp, err // cancelled by the caller, not a failure of the service
+		}
+		sc.Breaker.Failure()
+		if attempt >= sc.Retry.Retries || !retryable(r, err) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		case <-time.After(sc.backoff(attempt + 1)):
+		}
+	}
+}
+
+func (sc *ServiceClient) Get(ctx context.Context, url string) (*http.Response, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sc.Do(r)
+}
+
+func (sc *ServiceClient) Post(ctx context.Context, url, content_type string, body io.Reader) (*http.Response, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", content_type)
+	return sc.Do(r)
+}
+
+// breakerTransport sends the requests once through the breaker of the client,
+// bounded by its timeout.
+type breakerTransport struct {
+	sc *ServiceClient
+}
+
+// cancelBody cancels the context of the request once its response is read
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (bt breakerTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := bt.sc.Breaker.Allow(); err != nil {
+		return nil, err
+	}
+	caller := r.Context()
+	ctx, span := StartSpan(caller, r.Method+" "+r.URL.Path, SpanClient)
+	defer span.End()
+	cancel := context.CancelFunc(func() {})
+	if bt.sc.Retry.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, bt.sc.Retry.Timeout) // like the client's timeout, including reading the body
+	}
+	r = r.Clone(ctx) // a round tripper must not modify the request
+	Inject(ctx, r.Header)
+	if id := RequestID(ctx); id != "" {
+		r.Header.Set(RequestIDHeader, id)
+	}
+
+	transport := bt.sc.Client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	resp, err := transport.RoundTrip(r)
+	span.SetError(err)
+	if resp != nil {
+		span.SetAttr("http.status_code", resp.StatusCode)
+		resp.Body = cancelBody{resp.Body, cancel}
+	} else {
+		cancel()
+	}
+	switch {
+	case !failed(resp, err):
+		bt.sc.Breaker.Success()
+	case caller.Err() == nil: // not cancelled by the caller, a timeout is a failure
+		bt.sc.Breaker.Failure()
+	}
+	return resp, err
+}
+
+// Transport returns the round tripper of the client, ex: for a reverse proxy.
+// The requests go through the breaker but are not retried.
+func (sc *ServiceClient) Transport() http.RoundTripper {
+	return breakerTransport{sc}
+}
@@ -0,0 +1,141 @@
+package libs_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"scraper/libs"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClientRetry(t *testing.T) {
+	var calls atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	client, err := libs.NewServiceClient("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := libs.DefaultResilience
+	opts.RetryDelay = time.Millisecond
+	client.SetResilience(opts)
+
+	r, err := client.Get(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK || calls.Load() != 3 {
+		t.Errorf("expected success after 3 calls, got status %d after %d calls", r.StatusCode, calls.Load())
+	}
+
+	// a failed POST reaching the service is not sent again
+	calls.Store(0)
+	r, err = client.Post(context.Background(), ts.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusServiceUnavailable || calls.Load() != 1 {
+		t.Errorf("expected a single call, got status %d after %d calls", r.StatusCode, calls.Load())
+	}
+}
+
+func TestClientTimeoutAndBreaker(t *testing.T) {
+	var hang atomic.Bool
+	hang.Store(true)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hang.Load() {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	client, err := libs.NewServiceClient("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetResilience(libs.ResilienceOptions{
+		Timeout:         50 * time.Millisecond,
+		Retries:         1,
+		RetryDelay:      time.Millisecond,
+		BreakerFailures: 2,
+		BreakerCooldown: 100 * time.Millisecond,
+	})
+
+	if _, err = client.Get(context.Background(), ts.URL); err == nil {
+		t.Fatal("expected timeout")
+	}
+	if s := client.Breaker.State(); s.State != libs.BreakerOpen || s.Failures != 2 {
+		t.Errorf("expected open breaker after 2 timeouts, got %+v", s)
+	}
+	if _, err = client.Get(context.Background(), ts.URL); !errors.Is(err, libs.ErrCircuitOpen) {
+		t.Errorf("expected open circuit, got %v", err)
+	}
+
+	// after the cooldown a probe closes the breaker
+	hang.Store(false)
+	time.Sleep(150 * time.Millisecond)
+	r, err := client.Get(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if s := client.Breaker.State(); s.State != libs.BreakerClosed || s.Failures != 0 {
+		t.Errorf("expected closed breaker, got %+v", s)
+	}
+}
+
+func TestTransportTimeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	// a client without transport uses the default one
+	client := &libs.ServiceClient{Client: &http.Client{}, Breaker: libs.NewBreaker(0, 0)}
+	client.Retry.Timeout = 50 * time.Millisecond
+	hc := &http.Client{Transport: client.Transport()}
+
+	r, err := hc.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil || string(data) != "ok" {
+		t.Errorf("unexpected body %q, %v", data, err)
+	}
+
+	start := time.Now()
+	if _, err = hc.Get(ts.URL + "/slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("unexpected duration %v", d)
+	}
+}
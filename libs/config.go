@@ -0,0 +1,413 @@
+package libs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alexflint/go-arg"
+)
+
+var (
+	ErrConfigFormat  = errors.New("unknown configuration format, expected .yaml, .yml or .toml")
+	ErrConfigSyntax  = errors.New("invalid configuration syntax")
+	ErrConfigKey     = errors.New("unknown configuration key")
+	ErrInvalidConfig = errors.New("invalid configuration")
+)
+
+// Redacted replaces the secrets in the effective configuration.
+const Redacted = "[redacted]"
+
+// ConfigArgs are the arguments of the configuration, embedded in the
+// arguments of a service loaded with LoadConfig.
+type ConfigArgs struct {
+	ConfigFile  string `arg:"--config" help:"the YAML or TOML configuration file, overridden by the environment and the flags"`
+	PrintConfig bool   `arg:"--print-config" help:"print the effective configuration with the secrets redacted and exit"`
+}
+
+func (c *ConfigArgs) configArgs() *ConfigArgs {
+	return c
+}
+
+// ConfigValidator is implemented by the arguments checked once loaded.
+type ConfigValidator interface {
+	Validate() error
+}
+
+// configOption is a top-level argument of a service
+type configOption struct {
+	long     string
+	short    string
+	list     bool
+	boolean  bool
+	separate bool
+	secret   bool // tagged secret:"true", redacted in the effective configuration
+	index    []int
+}
+
+// configOptions returns the options of the arguments, the positionals and the
+// subcommands are left out
+func configOptions(t reflect.Type) []configOption {
+	var opts []configOption
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("arg")
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for _, opt := range configOptions(field.Type) {
+				opt.index = append([]int{i}, opt.index...)
+				opts = append(opts, opt)
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		opt := configOption{
+			long:    strings.ToLower(field.Name),
+			list:    field.Type.Kind() == reflect.Slice,
+			boolean: field.Type.Kind() == reflect.Bool,
+			secret:  field.Tag.Get("secret") == "true",
+			index:   []int{i},
+		}
+		skip := false
+		for _, key := range strings.Split(tag, ",") {
+			switch {
+			case key == "positional" || strings.HasPrefix(key, "subcommand"):
+				skip = true
+			case key == "separate":
+				opt.separate = true
+			case strings.HasPrefix(key, "--"):
+				opt.long = key[2:]
+			case strings.HasPrefix(key, "-"):
+				opt.short = key[1:]
+			}
+		}
+		if !skip {
+			opts = append(opts, opt)
+		}
+	}
+	return opts
+}
+
+// configEnv returns the environment variable of the argument of the service,
+// ex: SCRAPER_MONITOR_PORT.
+func configEnv(service, long string) string {
+	return "SCRAPER_" + strings.ToUpper(configKey(service)+"_"+configKey(long))
+}
+
+// presentOptions returns the options given on the command line
+func presentOptions(opts []configOption, args []string) map[string]bool {
+	present := make(map[string]bool)
+	for _, s := range args {
+		if s == "--" {
+			break
+		}
+		if !strings.HasPrefix(s, "-") {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(s, "-"), "=")
+		for _, opt := range opts {
+			if name == opt.long || opt.short != "" && name == opt.short {
+				present[opt.long] = true
+			}
+		}
+	}
+	return present
+}
+
+// configFileArg returns the configuration file given on the command line
+func configFileArg(args []string) string {
+	for i, s := range args {
+		if s == "--" {
+			break
+		}
+		if v, ok := strings.CutPrefix(s, "--config="); ok {
+			return v
+		}
+		if s == "--config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// configArgs returns the flags setting the values of the file and of the
+// environment, the options given on the command line are left out
+func configArgs(service string, opts []configOption, f configFile, args []string, lookupEnv func(string) (string, bool)) ([]string, error) {
+	known := make(map[string]bool)
+	for _, opt := range opts {
+		known[configKey(opt.long)] = true
+	}
+	for key := range f[configKey(service)] {
+		if !known[key] || key == "config" || key == "print_config" {
+			return nil, fmt.Errorf("%w: %s in section %s", ErrConfigKey, key, service)
+		}
+	}
+
+	present := presentOptions(opts, args)
+	var flags []string
+	for _, opt := range opts {
+		if present[opt.long] || opt.long == "print-config" {
+			continue
+		}
+
+		// the section of the service overrides the top-level values, the
+		// top-level keys unknown to the service are ignored
+		key := configKey(opt.long)
+		v, ok := f[configKey(service)][key]
+		if !ok {
+			v, ok = f[""][key]
+		}
+		if opt.long == "config" {
+			ok = false
+		}
+		if s, found := lookupEnv(configEnv(service, opt.long)); found {
+			v, ok = configValue{list: opt.list, items: strings.Split(s, ",")}, true
+			if !opt.list || s == "" {
+				v.items = []string{s}
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		name := "--" + opt.long
+		switch {
+		case !opt.list && len(v.items) != 1:
+			return nil, fmt.Errorf("%w: %s expects a single value", ErrInvalidConfig, key)
+		case opt.boolean && v.items[0] == "":
+		case opt.boolean:
+			b, err := parseBool(v.items[0])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s expects a boolean, got %q", ErrInvalidConfig, key, v.items[0])
+			}
+			flags = append(flags, name+"="+strconv.FormatBool(b))
+		case !opt.list && v.items[0] == "":
+			flags = append(flags, name, "")
+		case !opt.list:
+			flags = append(flags, name+"="+v.items[0])
+		case opt.separate:
+			for _, x := range v.items {
+				if x != "" {
+					flags = append(flags, name+"="+x)
+				}
+			}
+		case len(v.items) > 0:
+			flags = append(append(flags, name), v.items...)
+		}
+	}
+	return flags, nil
+}
+
+// LoadConfig parses the arguments of the service into dest, a pointer to
+// go-arg arguments embedding ConfigArgs. The values are taken, the first
+// found, from:
+//   - the command line,
+//   - the environment variables named after the service and the long flag,
+//     ex: SCRAPER_MONITOR_PORT, the lists are separated by commas,
+//   - the configuration file of --config or SCRAPER_<SERVICE>_CONFIG: the
+//     section of the service, then the top-level keys shared by the services,
+//   - the defaults of the arguments.
+//
+// The arguments implementing ConfigValidator are validated. The parser is nil
+// when the arguments are not supported by go-arg.
+func LoadConfig(service string, dest interface{}, args []string, lookupEnv func(string) (string, bool)) (*arg.Parser, error) {
+	p, err := arg.NewParser(arg.Config{}, dest)
+	if err != nil {
+		return nil, err
+	}
+
+	filename := configFileArg(args)
+	if filename == "" {
+		filename, _ = lookupEnv(configEnv(service, "config"))
+	}
+	f := configFile{}
+	if filename != "" {
+		f, err = readConfigFile(filename)
+		if err != nil {
+			return p, err
+		}
+	}
+
+	opts := configOptions(reflect.TypeOf(dest).Elem())
+	flags, err := configArgs(service, opts, f, args, lookupEnv)
+	if err != nil {
+		return p, err
+	}
+	err = p.Parse(append(flags, args...))
+	if err != nil {
+		return p, err
+	}
+
+	if v, ok := dest.(ConfigValidator); ok {
+		err = v.Validate()
+	}
+	return p, err
+}
+
+// MustLoadConfig loads the arguments of the service from the command line,
+// the environment and the configuration file like LoadConfig, it exits on
+// errors, on -h and after printing the configuration on --print-config.
+func MustLoadConfig(service string, dest interface{}) *arg.Parser {
+	p, err := LoadConfig(service, dest, os.Args[1:], os.LookupEnv)
+	switch {
+	case p == nil:
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	case errors.Is(err, arg.ErrHelp):
+		p.WriteHelpForSubcommand(os.Stdout, p.SubcommandNames()...)
+		os.Exit(0)
+	case errors.Is(err, arg.ErrVersion):
+		os.Exit(0)
+	case err != nil:
+		p.FailSubcommand(err.Error(), p.SubcommandNames()...)
+	}
+
+	if c, ok := dest.(interface{ configArgs() *ConfigArgs }); ok && c.configArgs().PrintConfig {
+		err = WriteConfig(os.Stdout, service, dest)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	return p
+}
+
+// redactURL redacts the credentials and the query of a URL, ex: the password
+// of an InfluxDB export, the other values are returned as is.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.User == nil && u.RawQuery == "" {
+		return s
+	}
+
+	query := u.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, url.QueryEscape(k)+"="+Redacted)
+	}
+	sort.Strings(keys)
+
+	userinfo := ""
+	if u.User != nil {
+		userinfo = Redacted + "@"
+	}
+	u.User, u.RawQuery, u.ForceQuery = nil, "", false
+	scheme, rest, _ := strings.Cut(u.String(), "://")
+	s = scheme + "://" + userinfo + rest
+	if len(keys) > 0 {
+		s += "?" + strings.Join(keys, "&")
+	}
+	return s
+}
+
+func redactValue(x reflect.Value, secret bool) interface{} {
+	switch {
+	case secret && !x.IsZero():
+		return Redacted
+	case x.Kind() == reflect.String:
+		return redactURL(x.String())
+	}
+	return x.Interface()
+}
+
+// EffectiveConfig returns the top-level arguments by their long flag, the
+// secrets are redacted along with the credentials and the query of the URLs.
+func EffectiveConfig(dest interface{}) map[string]interface{} {
+	v := reflect.ValueOf(dest).Elem()
+	m := make(map[string]interface{})
+	for _, opt := range configOptions(v.Type()) {
+		if opt.long == "print-config" {
+			continue
+		}
+
+		x := v.FieldByIndex(opt.index)
+		if !opt.list {
+			m[opt.long] = redactValue(x, opt.secret)
+			continue
+		}
+		items := make([]interface{}, x.Len())
+		for i := range items {
+			items[i] = redactValue(x.Index(i), opt.secret)
+		}
+		m[opt.long] = items
+	}
+	return m
+}
+
+func tomlValue(x interface{}) string {
+	switch v := x.(type) {
+	case string:
+		return strconv.Quote(v)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i := range v {
+			items[i] = tomlValue(v[i])
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(x)
+}
+
+// WriteConfig writes the effective configuration as the TOML section of the
+// service, the secrets are redacted. The configuration file is commented out
+// so that the output can be loaded.
+func WriteConfig(w io.Writer, service string, dest interface{}) error {
+	m := EffectiveConfig(dest)
+	var b strings.Builder
+	if filename, _ := m["config"].(string); filename != "" {
+		fmt.Fprintf(&b, "# loaded from %s\n", filename)
+	}
+	delete(m, "config")
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(&b, "[%s]\n", service)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s = %s\n", k, tomlValue(m[k]))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// CheckRange returns ErrInvalidConfig when the argument is out of [min, max].
+func CheckRange(name string, v, min, max int64) error {
+	if v < min || v > max {
+		return fmt.Errorf("%w: %s must be in [%d, %d], got %d", ErrInvalidConfig, name, min, max, v)
+	}
+	return nil
+}
+
+// CheckMin returns ErrInvalidConfig when the argument is below the minimum.
+func CheckMin(name string, v, min int64) error {
+	if v < min {
+		return fmt.Errorf("%w: %s must be at least %d, got %d", ErrInvalidConfig, name, min, v)
+	}
+	return nil
+}
+
+// CheckOneOf returns ErrInvalidConfig when the argument is not one of the
+// values.
+func CheckOneOf(name, v string, values ...string) error {
+	for _, x := range values {
+		if v == x {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s must be one of %s, got %q", ErrInvalidConfig, name, strings.Join(values, ", "), v)
+}
@@ -0,0 +1,208 @@
+package libs_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"scraper/libs"
+	"testing"
+)
+
+type testArgs struct {
+	Port     int      `arg:"-p,--port" default:"8090" help:"the port"`
+	Sampler  string   `arg:"-s,--sampler" help:"the sampler"`
+	Key      string   `arg:"--key" default:"" secret:"true" help:"the key"`
+	Origins  []string `arg:"--cors_origin,separate" help:"the origins"`
+	LogLevel string   `arg:"--log_level" default:"info" help:"the level"`
+	Debug    bool     `arg:"--debug" help:"debug"`
+	libs.ConfigArgs
+}
+
+func (a *testArgs) Validate() error {
+	return libs.CheckRange("port", int64(a.Port), 1, 65535)
+}
+
+func writeFile(t *testing.T, name, data string) string {
+	filename := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(filename, []byte(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func env(m map[string]string) func(string) (string, bool) {
+	return func(k string) (string, bool) {
+		v, ok := m[k]
+		return v, ok
+	}
+}
+
+const yamlConfig = `
+# shared by the services
+log_level: warn
+sampler: http://localhost:8092 # not a section
+monitor:
+  port: 9000
+  key: "s3cret"
+  cors_origin:
+  - https://a.example.com
+  - 'https://b.example.com'
+tracker:
+  port: 9001
+`
+
+const tomlConfig = `
+log_level = "warn"
+sampler = "http://localhost:8092"
+
+[monitor]
+port = 9000 # the API
+key = 's3cret'
+cors_origin = [
+  "https://a.example.com",
+  "https://b.example.com",
+]
+
+[tracker]
+port = 9001
+`
+
+func TestLoadConfig(t *testing.T) {
+	for name, data := range map[string]string{"config.yaml": yamlConfig, "config.toml": tomlConfig} {
+		filename := writeFile(t, name, data)
+
+		var a testArgs
+		_, err := libs.LoadConfig("monitor", &a, []string{"--config", filename}, env(nil))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		expected := testArgs{
+			Port:       9000,
+			Sampler:    "http://localhost:8092",
+			Key:        "s3cret",
+			Origins:    []string{"https://a.example.com", "https://b.example.com"},
+			LogLevel:   "warn",
+			ConfigArgs: libs.ConfigArgs{ConfigFile: filename},
+		}
+		if !reflect.DeepEqual(a, expected) {
+			t.Errorf("%s: unexpected arguments %+v", name, a)
+		}
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	filename := writeFile(t, "config.yml", yamlConfig)
+	environ := env(map[string]string{
+		"SCRAPER_MONITOR_CONFIG":      filename,
+		"SCRAPER_MONITOR_PORT":        "9100",
+		"SCRAPER_MONITOR_CORS_ORIGIN": "https://c.example.com,https://d.example.com",
+		"SCRAPER_MONITOR_DEBUG":       "true",
+	})
+
+	// the flags override the environment, which overrides the file
+	var a testArgs
+	_, err := libs.LoadConfig("monitor", &a, []string{"-p", "9200", "--log_level=error"}, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Port != 9200 || a.LogLevel != "error" || a.Key != "s3cret" || !a.Debug || a.ConfigFile != filename {
+		t.Errorf("unexpected arguments %+v", a)
+	}
+	if !reflect.DeepEqual(a.Origins, []string{"https://c.example.com", "https://d.example.com"}) {
+		t.Errorf("unexpected origins %v", a.Origins)
+	}
+
+	a = testArgs{}
+	_, err = libs.LoadConfig("monitor", &a, []string{"--cors_origin", "*"}, environ)
+	if err != nil || a.Port != 9100 || !reflect.DeepEqual(a.Origins, []string{"*"}) {
+		t.Errorf("unexpected arguments %+v, %v", a, err)
+	}
+
+	// without file nor environment, the defaults
+	a = testArgs{}
+	_, err = libs.LoadConfig("monitor", &a, nil, env(nil))
+	if err != nil || a.Port != 8090 || a.LogLevel != "info" || a.Sampler != "" {
+		t.Errorf("unexpected arguments %+v, %v", a, err)
+	}
+}
+
+func TestLoadConfigSyntax(t *testing.T) {
+	for name, data := range map[string]string{
+		"config.yaml": "monitor: {port: 9000, debug: yes, cors_origin: [a, b]}\nlog_level: >-\n  warn\n",
+		"config.yml":  "monitor:\n  port: 9000\n  debug: on\n  cors_origin:\n    - a\n    - b\n  log_level: |-\n    warn\n",
+		"config.toml": "[monitor]\n\tport = 9_000\n\tdebug = true\n\tcors_origin = ['a', \"b\"]\n\tlog_level = \"\"\"warn\"\"\"\n",
+	} {
+		var a testArgs
+		_, err := libs.LoadConfig("monitor", &a, []string{"--config", writeFile(t, name, data)}, env(nil))
+		if err != nil || a.Port != 9000 || !a.Debug || a.LogLevel != "warn" || !reflect.DeepEqual(a.Origins, []string{"a", "b"}) {
+			t.Errorf("%s: unexpected arguments %+v, %v", name, a, err)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for data, expected := range map[string]error{
+		"monitor:\n  prot: 9000\n":            libs.ErrConfigKey,
+		"monitor:\n  config: other.yaml\n":    libs.ErrConfigKey,
+		"monitor:\n  port: [1, 2]\n":          libs.ErrInvalidConfig,
+		"monitor:\n  port: 0\n":               libs.ErrInvalidConfig,
+		"monitor:\n  port: [9000\n":           libs.ErrConfigSyntax,
+		"monitor:\n  tls:\n    cert: x\n":     libs.ErrConfigSyntax,
+		"monitor:\n  debug: maybe\n":          libs.ErrInvalidConfig,
+		"monitor:\n  port: 9000\n   key: x\n": libs.ErrConfigSyntax,
+		"monitor:\n  port: 9000\nmonitor:\n":  libs.ErrConfigSyntax,
+	} {
+		var a testArgs
+		_, err := libs.LoadConfig("monitor", &a, []string{"--config", writeFile(t, "config.yaml", data)}, env(nil))
+		if !errors.Is(err, expected) {
+			t.Errorf("expected %v of %q, got %v", expected, data, err)
+		}
+	}
+
+	var a testArgs
+	_, err := libs.LoadConfig("monitor", &a, []string{"--config", writeFile(t, "config.ini", "port=1")}, env(nil))
+	if !errors.Is(err, libs.ErrConfigFormat) {
+		t.Errorf("expected ErrConfigFormat, got %v", err)
+	}
+	// the keys of the other services are not checked
+	_, err = libs.LoadConfig("monitor", &a, []string{"--config", writeFile(t, "config.toml", "grpc_port = 1\n[sampler]\nfile = 'x'\n")}, env(nil))
+	if err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	a := testArgs{Port: 9000, Key: "s3cret", Origins: []string{"*"}, LogLevel: "info", ConfigArgs: libs.ConfigArgs{ConfigFile: "config.yaml"}}
+	m := libs.EffectiveConfig(&a)
+	if m["key"] != libs.Redacted || m["port"] != 9000 || m["sampler"] != "" || m["config"] != "config.yaml" {
+		t.Errorf("unexpected config %v", m)
+	}
+	if _, ok := m["print-config"]; ok {
+		t.Errorf("unexpected print-config in %v", m)
+	}
+
+	var b bytes.Buffer
+	err := libs.WriteConfig(&b, "monitor", &a)
+	expected := `# loaded from config.yaml
+[monitor]
+cors_origin = ["*"]
+debug = false
+key = "[redacted]"
+log_level = "info"
+port = 9000
+sampler = ""
+`
+	if err != nil || b.String() != expected {
+		t.Errorf("unexpected dump %q, %v", b.String(), err)
+	}
+
+	// the dump is a valid configuration file
+	var x testArgs
+	_, err = libs.LoadConfig("monitor", &x, []string{"--config", writeFile(t, "dump.toml", b.String())}, env(nil))
+	if err != nil || x.Port != 9000 || x.Key != libs.Redacted {
+		t.Errorf("unexpected arguments from dump %+v, %v", x, err)
+	}
+}
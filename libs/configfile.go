@@ -0,0 +1,139 @@
+package libs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// configValue is a value of the configuration file, a scalar or a list
+type configValue struct {
+	list  bool
+	items []string
+}
+
+// configFile maps the sections of a configuration file to their values, the
+// top-level values are in the section ""
+type configFile map[string]map[string]configValue
+
+// configKey normalizes the name of an argument, ex: print-config and
+// print_config are the same
+func configKey(s string) string {
+	return strings.ReplaceAll(strings.ToLower(s), "-", "_")
+}
+
+// readConfigFile decodes a YAML or TOML file after its extension and flattens
+// it into the values of the arguments.
+func readConfigFile(filename string) (configFile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".toml":
+		err = toml.Unmarshal(data, &m)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &m)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrConfigFormat, filename)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrConfigSyntax, filename, err)
+	}
+
+	f, err := flattenConfig(m)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return f, nil
+}
+
+// flattenConfig maps the top-level keys holding a table to the sections, the
+// values are scalars or lists of scalars
+func flattenConfig(m map[string]interface{}) (configFile, error) {
+	f := configFile{"": {}}
+	for k, x := range m {
+		table, ok := x.(map[string]interface{})
+		if !ok {
+			v, err := configValueOf(x)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %v", ErrConfigSyntax, k, err)
+			}
+			f[""][configKey(k)] = v
+			continue
+		}
+
+		section := configKey(k)
+		if _, ok := f[section]; ok {
+			return nil, fmt.Errorf("%w: duplicate section %s", ErrConfigSyntax, k)
+		}
+		f[section] = make(map[string]configValue)
+		for key, y := range table {
+			v, err := configValueOf(y)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s.%s: %v", ErrConfigSyntax, k, key, err)
+			}
+			f[section][configKey(key)] = v
+		}
+	}
+	return f, nil
+}
+
+func configValueOf(x interface{}) (configValue, error) {
+	v, ok := x.([]interface{})
+	if !ok {
+		s, err := scalarString(x)
+		return configValue{items: []string{s}}, err
+	}
+
+	items := make([]string, len(v))
+	for i := range v {
+		s, err := scalarString(v[i])
+		if err != nil {
+			return configValue{}, err
+		}
+		items[i] = s
+	}
+	return configValue{list: true, items: items}, nil
+}
+
+// scalarString returns the text of a decoded scalar as given to a flag
+func scalarString(x interface{}) (string, error) {
+	switch v := x.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int, int64, uint64:
+		return fmt.Sprint(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	case fmt.Stringer:
+		return v.String(), nil
+	}
+	return "", fmt.Errorf("unsupported value %v, expected a scalar or a list of scalars", x)
+}
+
+// parseBool accepts the booleans of YAML 1.1 along with the ones of
+// strconv.ParseBool, ex: yes and off
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "y", "yes", "on":
+		return true, nil
+	case "n", "no", "off":
+		return false, nil
+	}
+	return strconv.ParseBool(s)
+}
@@ -0,0 +1,303 @@
+package libs
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCServer is the gRPC server of a service for the internal traffic. The
+// callers are authenticated by the API key in the metadata "api-key", the
+// calls are traced, logged with their request id and recovered from panics.
+type GRPCServer struct {
+	*grpc.Server
+	port    int
+	api_key [sha256.Size]byte
+	no_key  bool
+	done    chan struct{}
+}
+
+// NewGRPCServer creates the server listening at the port, an empty API key
+// lets all callers in and nil TLS options serve without TLS.
+func NewGRPCServer(port int, api_key string, opts *TLSOptions) (*GRPCServer, error) {
+	s := &GRPCServer{
+		port:    port,
+		api_key: sha256.Sum256([]byte(api_key)),
+		no_key:  api_key == "",
+		done:    make(chan struct{}),
+	}
+	options := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.unary),
+		grpc.ChainStreamInterceptor(s.stream),
+	}
+	if opts != nil && opts.CertFile != "" {
+		cfg, err := opts.ServerConfig()
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.Creds(credentials.NewTLS(cfg)))
+	}
+	s.Server = grpc.NewServer(options...)
+	return s, nil
+}
+
+// Start serves in background, it panics if the server can't listen.
+func (s *GRPCServer) Start() {
+	address := fmt.Sprintf(":%d", s.port)
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		panic(err)
+	}
+	slog.Info("start listen gRPC", "address", address)
+	go func() {
+		defer close(s.done)
+		err := s.Serve(l)
+		if err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			panic(err)
+		}
+	}()
+}
+
+// Shutdown stops accepting calls and waits up to the timeout for the
+// in-flight ones, the streams are closed after it.
+func (s *GRPCServer) Shutdown(timeout time.Duration) {
+	slog.Info("shutdown gRPC server", "port", s.port)
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		s.Stop()
+	}
+	<-s.done
+}
+
+// incoming returns the context of a call carrying the span of the caller and
+// the request id, or an error if the API key is wrong.
+func (s *GRPCServer) incoming(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	get := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	if !s.no_key {
+		x := sha256.Sum256([]byte(get("api-key")))
+		if subtle.ConstantTimeCompare(s.api_key[:], x[:]) != 1 {
+			return ctx, status.Error(codes.Unauthenticated, ErrIncorrectAPIKey.Error())
+		}
+	}
+
+	h := http.Header{}
+	h.Set("traceparent", get("traceparent"))
+	ctx = Extract(ctx, h)
+	id := get(strings.ToLower(RequestIDHeader))
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	return WithRequestID(ctx, id), nil
+}
+
+// serve runs the handler of the call with its span, logs it and turns a panic
+// into the code Internal.
+func serve(ctx context.Context, method string, fn func(ctx context.Context) error) (err error) {
+	ctx, span := StartSpan(ctx, method, SpanServer)
+	span.SetAttr("request_id", RequestID(ctx))
+	t := time.Now()
+	defer func() {
+		if v := recover(); v != nil {
+			slog.ErrorContext(ctx, "panic", "error", v, "stack", string(debug.Stack()))
+			err = status.Error(codes.Internal, "internal error")
+		}
+		code := status.Code(err)
+		span.SetAttr("rpc.grpc.status_code", int(code))
+		span.SetError(err)
+		span.End()
+		slog.InfoContext(ctx, "rpc",
+			"method", method,
+			"code", code.String(),
+			"duration_ms", float64(time.Since(t).Microseconds())/1000,
+		)
+	}()
+	return fn(ctx)
+}
+
+func (s *GRPCServer) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	ctx, err = s.incoming(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "rpc denied", "method", info.FullMethod)
+		return nil, err
+	}
+	err = serve(ctx, info.FullMethod, func(ctx context.Context) error {
+		resp, err = handler(ctx, req)
+		return err
+	})
+	return resp, err
+}
+
+// serverStream replaces the context of a stream.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (ss serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (s *GRPCServer) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.incoming(ss.Context())
+	if err != nil {
+		slog.WarnContext(ctx, "rpc denied", "method", info.FullMethod)
+		return err
+	}
+	return serve(ctx, info.FullMethod, func(ctx context.Context) error {
+		return handler(srv, serverStream{ss, ctx})
+	})
+}
+
+// GRPCError returns the gRPC status of an error of a service, the errors of
+// the caller keep their code.
+func GRPCError(err error, code codes.Code) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	return status.Error(code, err.Error())
+}
+
+// outgoing returns the context of a call carrying the API key, the span and
+// the request id.
+func (sc *ServiceClient) outgoing(ctx context.Context) context.Context {
+	var kv []string
+	if sc.APIKey != "" {
+		kv = append(kv, "api-key", sc.APIKey)
+	}
+	if x, ok := spanContext(ctx); ok {
+		kv = append(kv, "traceparent", x.Traceparent())
+	}
+	if id := RequestID(ctx); id != "" {
+		kv = append(kv, strings.ToLower(RequestIDHeader), id)
+	}
+	return metadata.AppendToOutgoingContext(ctx, kv...)
+}
+
+// grpcFailed reports whether the call counts as a failure of the service.
+func grpcFailed(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.DeadlineExceeded, codes.Unknown:
+		return true
+	}
+	return false
+}
+
+// idempotentOption marks a gRPC call safe to send again
+type idempotentOption struct {
+	grpc.EmptyCallOption
+}
+
+// Idempotent is the option of the gRPC calls which can be sent again, ex: the
+// reads, they are retried while the service is unavailable. The other calls
+// are sent once like the HTTP POST requests, ex: adding counters.
+var Idempotent grpc.CallOption = idempotentOption{}
+
+func idempotent(opts []grpc.CallOption) bool {
+	for _, opt := range opts {
+		if _, ok := opt.(idempotentOption); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// unary sends the call, retrying the idempotent ones while the service is
+// unavailable, ex: the connection failed.
+func (sc *ServiceClient) unary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) (err error) {
+	ctx, span := StartSpan(ctx, method, SpanClient)
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+	ctx = sc.outgoing(ctx)
+
+	for attempt := 0; ; attempt++ {
+		if err := sc.Breaker.Allow(); err != nil {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+
+		call_ctx, cancel := ctx, context.CancelFunc(func() {})
+		if sc.Retry.Timeout > 0 {
+			call_ctx, cancel = context.WithTimeout(ctx, sc.Retry.Timeout)
+		}
+		err = invoker(call_ctx, method, req, reply, cc, opts...)
+		cancel()
+		if !grpcFailed(err) {
+			sc.Breaker.Success()
+			return err
+		}
+		if ctx.Err() != nil {
+			return err // cancelled by the caller, not a failure of the service
+		}
+		sc.Breaker.Failure()
+		if attempt >= sc.Retry.Retries || status.Code(err) != codes.Unavailable || !idempotent(opts) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(sc.backoff(attempt + 1)):
+		}
+	}
+}
+
+// stream opens the stream through the breaker, it is not retried.
+func (sc *ServiceClient) stream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if err := sc.Breaker.Allow(); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	cs, err := streamer(sc.outgoing(ctx), desc, cc, method, opts...)
+	if grpcFailed(err) {
+		sc.Breaker.Failure()
+	} else {
+		sc.Breaker.Success()
+	}
+	return cs, err
+}
+
+// DialGRPC returns the connection to the gRPC address of the service, ex:
+// localhost:9092. The calls carry the API key and use the TLS configuration,
+// the retries and the breaker of the client.
+func (sc *ServiceClient) DialGRPC(address string) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if sc.tls != nil {
+		creds = credentials.NewTLS(sc.tls.Clone())
+	}
+	return grpc.NewClient(address,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithChainUnaryInterceptor(sc.unary),
+		grpc.WithChainStreamInterceptor(sc.stream),
+	)
+}
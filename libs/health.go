@@ -0,0 +1,74 @@
+package libs
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// CheckResult is the result of a readiness check.
+type CheckResult struct {
+	Status string      `json:"status"`
+	Error  string      `json:"error,omitempty"`
+	Detail interface{} `json:"detail,omitempty"`
+}
+
+// HealthReport is the JSON body of /healthz and /readyz.
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// ReadyCheck reports whether a dependency of a service is ready, the detail
+// is added to the report for dashboards.
+type ReadyCheck func(ctx context.Context) (detail interface{}, err error)
+
+// Healthz is the liveness handler: the process is up and serving.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	JSONReply(w, HealthReport{Status: StatusOK})
+}
+
+// MakeReadyz returns the readiness handler running the checks concurrently,
+// it replies 503 if any of them fails.
+func MakeReadyz(timeout time.Duration, checks map[string]ReadyCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		report := HealthReport{Status: StatusOK, Checks: make(map[string]CheckResult)}
+		var mtx sync.Mutex
+		var wg sync.WaitGroup
+		for name, fn := range checks {
+			wg.Add(1)
+			go func(name string, fn ReadyCheck) {
+				defer wg.Done()
+				detail, err := fn(ctx)
+				x := CheckResult{Status: StatusOK, Detail: detail}
+				if err != nil {
+					x.Status = StatusError
+					x.Error = err.Error()
+				}
+
+				mtx.Lock()
+				defer mtx.Unlock()
+				report.Checks[name] = x
+				if err != nil {
+					report.Status = StatusError
+				}
+			}(name, fn)
+		}
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != StatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		JSONReply(w, report)
+	}
+}
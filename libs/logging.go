@@ -0,0 +1,121 @@
+package libs
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidLogLevel = errors.New("invalid log level")
+)
+
+// RequestIDHeader carries the id of a request across the services.
+const RequestIDHeader = "X-Request-ID"
+
+// InitLogger sets the default logger writing JSON lines to stderr from the
+// level: debug, info, warn or error. The lines logged with a context carry
+// its request id and trace id. The standard logger writes to it too.
+func InitLogger(level string) error {
+	logger, err := NewLogger(os.Stderr, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+func NewLogger(w io.Writer, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, ErrInvalidLogLevel
+	}
+	h := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: lvl})
+	return slog.New(contextHandler{h}), nil
+}
+
+// contextHandler adds the ids of the context to the records.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc, ok := spanContext(ctx); ok {
+		r.AddAttrs(slog.String("trace_id", hex.EncodeToString(sc.TraceID[:])))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type requestIDKey struct{}
+
+// RequestID returns the id of the request being handled, empty if none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// WithRequestID returns the context carrying the request id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	newID(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestID limits the ids accepted from the callers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	return strings.Trim(id, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_.") == ""
+}
+
+// LogHandler gives every request an id, the one of the caller if given, set
+// in the context and the response header, and logs the request once handled.
+func LogHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		ctx := WithRequestID(r.Context(), id)
+		w.Header().Set(RequestIDHeader, id)
+		SpanFromContext(ctx).SetAttr("request_id", id)
+
+		t := time.Now()
+		sr := NewStatusRecorder(w)
+		next.ServeHTTP(sr, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || r.URL.Path == "/metrics" {
+			level = slog.LevelDebug // polled by the orchestrator and Prometheus
+		}
+		slog.Log(ctx, level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sr.Status,
+			"duration_ms", float64(time.Since(t).Microseconds())/1000,
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
@@ -0,0 +1,95 @@
+package libs_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"scraper/libs"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := libs.NewLogger(&buf, "info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+
+	// the backend sees the id of the request of the frontend
+	var backend_id string
+	backend := httptest.NewServer(libs.LogHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backend_id = r.Header.Get(libs.RequestIDHeader)
+		libs.BadRequest(w, errors.New("no such target"))
+	})))
+	defer backend.Close()
+
+	client, err := libs.NewServiceClient("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frontend := httptest.NewServer(libs.LogHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slog.InfoContext(r.Context(), "check", "target", "jd.com")
+		resp, err := client.Get(r.Context(), backend.URL)
+		if err != nil {
+			libs.InternalServerError(w, err)
+			return
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		w.Write(data)
+	})))
+	defer frontend.Close()
+
+	r, _ := http.NewRequest(http.MethodGet, frontend.URL, nil)
+	r.Header.Set(libs.RequestIDHeader, "abc-123")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.Header.Get(libs.RequestIDHeader) != "abc-123" || backend_id != "abc-123" {
+		t.Errorf("request id not propagated: reply %q, backend %q", resp.Header.Get(libs.RequestIDHeader), backend_id)
+	}
+	if !strings.Contains(string(data), "no such target (request_id: abc-123)") {
+		t.Errorf("unexpected error reply %q", data)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 log lines, got:\n%s", buf.String())
+	}
+	for _, line := range lines {
+		var x map[string]interface{}
+		if err = json.Unmarshal([]byte(line), &x); err != nil {
+			t.Fatalf("invalid JSON log line %q", line)
+		}
+		if x["request_id"] != "abc-123" {
+			t.Errorf("missing request id in %s", line)
+		}
+	}
+
+	// an invalid id is replaced
+	r.Header.Set(libs.RequestIDHeader, "bad id!")
+	resp, err = http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if id := resp.Header.Get(libs.RequestIDHeader); id == "" || id == "bad id!" {
+		t.Errorf("unexpected request id %q", id)
+	}
+
+	if _, err = libs.NewLogger(&buf, "verbose"); !errors.Is(err, libs.ErrInvalidLogLevel) {
+		t.Errorf("expected invalid log level, got %v", err)
+	}
+}
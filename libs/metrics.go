@@ -0,0 +1,292 @@
+package libs
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds in second of the latency histograms.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultRegistry holds the metrics of the services, it is served by
+// MetricsHandler.
+var DefaultRegistry = NewRegistry()
+
+// Registry is a set of metric families written in the Prometheus text
+// exposition format.
+type Registry struct {
+	families map[string]*family
+	mtx      sync.RWMutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+type series struct {
+	values  []string
+	value   float64
+	buckets []uint64 // histogram counts per bucket, not cumulative
+	sum     float64
+	count   uint64
+}
+
+type family struct {
+	name    string
+	help    string
+	kind    string // counter, gauge or histogram
+	labels  []string
+	buckets []float64
+	fn      func() float64 // value of a gauge computed when collected
+	series  map[string]*series
+	mtx     sync.Mutex
+}
+
+func (r *Registry) register(f *family) *family {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.families[f.name]; ok {
+		panic(fmt.Sprintf("metric %s registered twice", f.name))
+	}
+	f.series = make(map[string]*series)
+	r.families[f.name] = f
+	return f
+}
+
+// get returns the series of the label values, they must match the labels of
+// the family.
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s expects labels %v, got %v", f.name, f.labels, values))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == "histogram" {
+			s.buckets = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) delete(values []string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	delete(f.series, strings.Join(values, "\xff"))
+}
+
+// Counter is a value which only goes up, ex: the number of requests.
+type Counter struct{ f *family }
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(&family{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+func (c *Counter) Add(v float64, values ...string) {
+	c.f.mtx.Lock()
+	defer c.f.mtx.Unlock()
+	c.f.get(values).value += v
+}
+
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Gauge is a value which goes up and down, ex: the availability of a target.
+type Gauge struct{ f *family }
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(&family{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+func (g *Gauge) Set(v float64, values ...string) {
+	g.f.mtx.Lock()
+	defer g.f.mtx.Unlock()
+	g.f.get(values).value = v
+}
+
+// Delete removes the series of the label values, ex: a removed target.
+func (g *Gauge) Delete(values ...string) {
+	g.f.delete(values)
+}
+
+// NewGaugeFunc registers a gauge without labels computed at each scrape.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&family{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+// Histogram counts the observed values in buckets, ex: the latencies.
+type Histogram struct{ f *family }
+
+// NewHistogram registers a histogram, nil buckets use DefaultBuckets.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{r.register(&family{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})}
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.f.mtx.Lock()
+	defer h.f.mtx.Unlock()
+	s := h.f.get(values)
+	i := sort.SearchFloat64s(h.f.buckets, v)
+	if i < len(s.buckets) {
+		s.buckets[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// ObserveDuration observes the time elapsed since t in second.
+func (h *Histogram) ObserveDuration(t time.Time, values ...string) {
+	h.Observe(time.Since(t).Seconds(), values...)
+}
+
+// Delete removes the series of the label values.
+func (h *Histogram) Delete(values ...string) {
+	h.f.delete(values)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// formatLabels returns the labels in braces, an extra label is appended if
+// given, ex: the bound "le" of a histogram bucket.
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `%s="%s"`, names[i], labelEscaper.Replace(values[i]))
+	}
+	if len(extra) == 2 {
+		if len(names) > 0 {
+			sb.WriteByte(',')
+		}
+		fmt.Fprintf(&sb, `%s="%s"`, extra[0], labelEscaper.Replace(extra[1]))
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func (f *family) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, helpEscaper.Replace(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	if f.fn != nil {
+		fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.fn()))
+		return
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := f.series[k]
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labels, s.values), formatFloat(s.value))
+			continue
+		}
+		var n uint64
+		for i, b := range f.buckets {
+			n += s.buckets[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.values, "le", formatFloat(b)), n)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, formatLabels(f.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, formatLabels(f.labels, s.values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, formatLabels(f.labels, s.values), s.count)
+	}
+}
+
+// Expose writes the metrics sorted by name in the text exposition format.
+func (r *Registry) Expose(w io.Writer) {
+	r.mtx.RLock()
+	names := make([]string, 0, len(r.families))
+	for k := range r.families {
+		names = append(names, k)
+	}
+	r.mtx.RUnlock()
+	sort.Strings(names)
+
+	for _, k := range names {
+		r.mtx.RLock()
+		f := r.families[k]
+		r.mtx.RUnlock()
+		f.write(w)
+	}
+}
+
+// Handler serves the metrics to Prometheus.
+func (r *Registry) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Expose(w)
+	}
+}
+
+// MetricsHandler serves the metrics of DefaultRegistry.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	DefaultRegistry.Handler()(w, r)
+}
+
+// HTTPMetrics counts the requests of the handlers and observes their
+// latencies.
+type HTTPMetrics struct {
+	requests *Counter
+	latency  *Histogram
+}
+
+// NewHTTPMetrics registers the metrics of the handlers with the prefix, ex:
+// "scraper_monitor".
+func NewHTTPMetrics(r *Registry, prefix string) *HTTPMetrics {
+	return &HTTPMetrics{
+		requests: r.NewCounter(prefix+"_http_requests_total", "The number of HTTP requests by handler and status code.", "handler", "code"),
+		latency:  r.NewHistogram(prefix+"_http_request_duration_seconds", "The latency of HTTP requests by handler.", nil, "handler"),
+	}
+}
+
+// Wrap instruments the handler under the name.
+func (m *HTTPMetrics) Wrap(handler string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t := time.Now()
+		sr := NewStatusRecorder(w)
+		fn(sr, r)
+		m.latency.ObserveDuration(t, handler)
+		m.requests.Inc(handler, strconv.Itoa(sr.Status))
+	}
+}
@@ -0,0 +1,73 @@
+package libs_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"scraper/libs"
+	"strings"
+	"testing"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	r := libs.NewRegistry()
+	c := r.NewCounter("test_requests_total", "The number of requests.", "handler", "code")
+	g := r.NewGauge("test_up", "Whether the target is up.", "target")
+	h := r.NewHistogram("test_latency_seconds", "The latency.", []float64{0.1, 1}, "handler")
+	r.NewGaugeFunc("test_size", "The size\nof the cache.", func() float64 { return 3 })
+
+	c.Inc("/check", "200")
+	c.Add(2, "/check", "200")
+	g.Set(1, `a"b`)
+	g.Set(0, "gone")
+	g.Delete("gone")
+	h.Observe(0.05, "/check")
+	h.Observe(0.1, "/check")
+	h.Observe(5, "/check")
+
+	w := httptest.NewRecorder()
+	r.Handler()(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	expected := `# HELP test_latency_seconds The latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{handler="/check",le="0.1"} 2
+test_latency_seconds_bucket{handler="/check",le="1"} 2
+test_latency_seconds_bucket{handler="/check",le="+Inf"} 3
+test_latency_seconds_sum{handler="/check"} 5.15
+test_latency_seconds_count{handler="/check"} 3
+# HELP test_requests_total The number of requests.
+# TYPE test_requests_total counter
+test_requests_total{handler="/check",code="200"} 3
+# HELP test_size The size\nof the cache.
+# TYPE test_size gauge
+test_size 3
+# HELP test_up Whether the target is up.
+# TYPE test_up gauge
+test_up{target="a\"b"} 1
+`
+	if got := w.Body.String(); got != expected {
+		t.Errorf("unexpected exposition:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestHTTPMetrics(t *testing.T) {
+	r := libs.NewRegistry()
+	m := libs.NewHTTPMetrics(r, "test")
+	fn := m.Wrap("/fail", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusBadGateway)
+	})
+	fn(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+
+	var sb strings.Builder
+	r.Expose(&sb)
+	for _, s := range []string{
+		`test_http_requests_total{handler="/fail",code="502"} 1`,
+		`test_http_request_duration_seconds_count{handler="/fail"} 1`,
+	} {
+		if !strings.Contains(sb.String(), s) {
+			t.Errorf("missing %s in:\n%s", s, sb.String())
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"scraper/libs"
+)
+
+var (
+	ErrForbidden = errors.New("not allowed to this scope")
+)
+
+// Identity is the caller authenticated by a credential.
+type Identity struct {
+	Name   string
+	Scopes []string
+}
+
+func (id Identity) Allowed(scope string) bool {
+	for _, s := range id.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator returns the identity owning the credential, ok is false if
+// the credential is invalid and err is a failure to check it.
+type Authenticator func(ctx context.Context, credential string) (id Identity, ok bool, err error)
+
+type identityKey struct{}
+
+// Caller returns the identity authenticated by Credential, ok is false if
+// none.
+func Caller(ctx context.Context) (Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(Identity)
+	return id, ok
+}
+
+// Credential authenticates the requests by the credential in the header, the
+// identity is set in their context. An invalid credential is replied the
+// error with the status code, a failure to check it, ex: the service
+// verifying it is down, is replied 502.
+func Credential(header string, authenticate Authenticator, invalid error, status_code int) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, ok, err := authenticate(r.Context(), r.Header.Get(header))
+			if err != nil {
+				slog.ErrorContext(r.Context(), "authenticate", "header", header, "error", err)
+				libs.ServerError(w, err, http.StatusBadGateway)
+				return
+			}
+			if !ok {
+				slog.WarnContext(r.Context(), "credential denied", "header", header, "method", r.Method, "url", r.URL.String(), "remote_addr", r.RemoteAddr)
+				libs.ServerError(w, invalid, status_code)
+				return
+			}
+			ctx := context.WithValue(r.Context(), identityKey{}, id)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Scope replies 403 to the callers not allowed to the scope, it follows
+// Credential.
+func Scope(scope string, denied error) Middleware {
+	if denied == nil {
+		denied = ErrForbidden
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id, _ := Caller(r.Context())
+			if !id.Allowed(scope) {
+				slog.WarnContext(r.Context(), "scope denied", "caller", id.Name, "scope", scope, "method", r.Method, "url", r.URL.String())
+				libs.ServerError(w, denied, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// APIKey authenticates the internal services by the key in the header
+// "api-key" sent by libs.ServiceClient, an empty key lets all requests in.
+func APIKey(key string) Middleware {
+	if key == "" {
+		return passthrough
+	}
+	h := sha256.Sum256([]byte(key))
+	return Credential("api-key", func(ctx context.Context, credential string) (Identity, bool, error) {
+		x := sha256.Sum256([]byte(credential))
+		return Identity{Name: "service"}, subtle.ConstantTimeCompare(h[:], x[:]) == 1, nil
+	}, libs.ErrIncorrectAPIKey, http.StatusUnauthorized)
+}
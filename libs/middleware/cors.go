@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"scraper/libs"
+)
+
+const corsMaxAge = "600" // the time in second a browser caches a preflight
+
+// CORS lets the browsers call the service from the origins, "*" allows any.
+// The preflight requests are replied without reaching the handler so they
+// need no credential. No origin disables CORS.
+func CORS(origins []string) Middleware {
+	if len(origins) == 0 {
+		return passthrough
+	}
+	allowed := map[string]bool{}
+	for _, s := range origins {
+		allowed[s] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			h := w.Header()
+			h.Add("Vary", "Origin")
+			if !allowed["*"] && !allowed[origin] {
+				next.ServeHTTP(w, r) // the browser blocks the response
+				return
+			}
+			h.Set("Access-Control-Allow-Origin", origin)
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				h.Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+				if s := r.Header.Get("Access-Control-Request-Headers"); s != "" {
+					h.Set("Access-Control-Allow-Headers", s)
+				}
+				h.Set("Access-Control-Max-Age", corsMaxAge)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			h.Set("Access-Control-Expose-Headers", libs.RequestIDHeader)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(io.Discard)
+	},
+}
+
+// gzipWriter compresses the response unless the handler already encoded it,
+// ex: a response proxied from another service.
+type gzipWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	started bool
+}
+
+func (gw *gzipWriter) WriteHeader(status_code int) {
+	if gw.started || status_code < 200 {
+		gw.ResponseWriter.WriteHeader(status_code)
+		return
+	}
+	gw.started = true
+	h := gw.Header()
+	if h.Get("Content-Encoding") == "" && status_code != http.StatusNoContent && status_code != http.StatusNotModified {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		gw.gz = gzipWriters.Get().(*gzip.Writer)
+		gw.gz.Reset(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(status_code)
+}
+
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+	if !gw.started {
+		if gw.Header().Get("Content-Type") == "" {
+			gw.Header().Set("Content-Type", http.DetectContentType(b)) // not sniffed from the compressed bytes
+		}
+		gw.WriteHeader(http.StatusOK)
+	}
+	if gw.gz == nil {
+		return gw.ResponseWriter.Write(b)
+	}
+	return gw.gz.Write(b)
+}
+
+func (gw *gzipWriter) Flush() {
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+func (gw *gzipWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+func (gw *gzipWriter) close() {
+	if gw.gz == nil {
+		return
+	}
+	gw.gz.Close()
+	gw.gz.Reset(io.Discard)
+	gzipWriters.Put(gw.gz)
+	gw.gz = nil
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, s := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		v := strings.Split(s, ";")
+		if strings.TrimSpace(v[0]) == "gzip" {
+			return len(v) == 1 || strings.ReplaceAll(v[1], " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// Gzip compresses the responses of the clients accepting it.
+func Gzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
@@ -0,0 +1,257 @@
+// Package middleware wraps the handlers of the services with the common
+// concerns: authentication, panic recovery, logging, body limits,
+// compression, CORS and timeouts.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"scraper/libs"
+	"sync"
+	"time"
+)
+
+var (
+	ErrPanic        = errors.New("internal error")
+	ErrBodyTooLarge = errors.New("request body too large")
+	ErrTimeout      = errors.New("request timeout")
+)
+
+// Middleware wraps a handler.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps the handler with the middlewares, the first one is the
+// outermost.
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+func passthrough(next http.Handler) http.Handler {
+	return next
+}
+
+// Options configures the middlewares applied to all the requests of a
+// service.
+type Options struct {
+	MaxBodySize int64         // in bytes, 0 for no limit
+	Timeout     time.Duration // 0 for no limit
+	CORSOrigins []string      // the origins allowed to call from a browser, "*" for any
+}
+
+// Service returns the handler of a service serving the mux: every request is
+// traced, given an id and logged, marked if versioned, recovered from panics,
+// checked for CORS, gzipped if accepted, limited in body size and in time.
+func Service(mux http.Handler, opts Options) http.Handler {
+	return Chain(mux,
+		libs.TraceHandler,
+		libs.LogHandler,
+		libs.APIVersionHandler,
+		Recover,
+		CORS(opts.CORSOrigins),
+		Gzip,
+		MaxBodySize(opts.MaxBodySize),
+		Timeout(opts.Timeout),
+	)
+}
+
+// recoverWriter records whether the response is started, a panic can't be
+// replied once the headers are sent.
+type recoverWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (rw *recoverWriter) WriteHeader(status_code int) {
+	if status_code >= 200 {
+		rw.started = true
+	}
+	rw.ResponseWriter.WriteHeader(status_code)
+}
+
+func (rw *recoverWriter) Write(b []byte) (int, error) {
+	rw.started = true
+	return rw.ResponseWriter.Write(b)
+}
+
+func (rw *recoverWriter) Flush() {
+	rw.started = true
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+func (rw *recoverWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Recover replies 500 to a request whose handler panics and logs the panic
+// with its stack, the server keeps serving. The connection is aborted when
+// the response is already started so that the client sees a truncated reply.
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &recoverWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v) // aborts the response on purpose
+			}
+			slog.ErrorContext(r.Context(), "panic", "error", v, "stack", string(debug.Stack()))
+			if rw.started {
+				panic(http.ErrAbortHandler)
+			}
+			libs.InternalServerError(w, ErrPanic)
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// MaxBodySize rejects the request bodies larger than the limit with 413,
+// reading beyond the limit fails if the length is not given upfront.
+func MaxBodySize(limit int64) Middleware {
+	if limit <= 0 {
+		return passthrough
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > limit {
+				libs.ServerError(w, ErrBodyTooLarge, http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// timeoutWriter forwards the response of a handler unless it timed out, only
+// the headers are held until the response starts so that the streamed and
+// large replies are not buffered.
+type timeoutWriter struct {
+	w        http.ResponseWriter
+	h        http.Header
+	mtx      sync.Mutex
+	started  bool
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+// start sends the headers of the handler, mtx is locked
+func (tw *timeoutWriter) start() {
+	tw.started = true
+	h := tw.w.Header()
+	for k, v := range tw.h {
+		h[k] = v
+	}
+}
+
+func (tw *timeoutWriter) WriteHeader(status_code int) {
+	tw.mtx.Lock()
+	defer tw.mtx.Unlock()
+	if tw.timedOut || tw.started {
+		return
+	}
+	if status_code >= 200 {
+		tw.start()
+	}
+	tw.w.WriteHeader(status_code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mtx.Lock()
+	defer tw.mtx.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.started {
+		tw.start() // the status is implied by the writer below, ex: sniffing the content type
+	}
+	return tw.w.Write(b)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mtx.Lock()
+	defer tw.mtx.Unlock()
+	if tw.timedOut {
+		return
+	}
+	if !tw.started {
+		tw.start()
+	}
+	http.NewResponseController(tw.w).Flush()
+}
+
+// timeout marks the response timed out, false if it is already started
+func (tw *timeoutWriter) timeout() bool {
+	tw.mtx.Lock()
+	defer tw.mtx.Unlock()
+	if tw.started {
+		return false
+	}
+	tw.timedOut = true
+	return true
+}
+
+// Timeout replies 503 to the requests not handled in time, their context is
+// cancelled so are the calls they make. A response already started is left
+// to the handler which sees its context cancelled.
+func Timeout(d time.Duration) Middleware {
+	if d <= 0 {
+		return passthrough
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			// the handler sees the headers set so far, ex: the request id
+			tw := &timeoutWriter{w: w, h: w.Header().Clone()}
+			done := make(chan struct{})
+			panicked := make(chan interface{}, 1)
+			go func() {
+				defer func() {
+					if v := recover(); v != nil {
+						panicked <- v
+					}
+				}()
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			select {
+			case v := <-panicked:
+				panic(v) // recovered by the outer middlewares
+			case <-done:
+				return
+			case <-ctx.Done():
+			}
+			if !tw.timeout() {
+				select {
+				case v := <-panicked:
+					panic(v)
+				case <-done:
+				}
+				return
+			}
+
+			libs.ServerError(w, ErrTimeout, http.StatusServiceUnavailable)
+		})
+	}
+}
+
+// Metrics exports the requests and latencies of the handler under the name,
+// ex: its pattern.
+func Metrics(m *libs.HTTPMetrics, handler string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return m.Wrap(handler, next.ServeHTTP)
+	}
+}
@@ -0,0 +1,195 @@
+package middleware_test
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"scraper/libs"
+	"scraper/libs/middleware"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecover(t *testing.T) {
+	h := middleware.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected status %d", w.Code)
+	}
+
+	// a started response is aborted rather than appended an error
+	h = middleware.Recover(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("boom")
+	}))
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("unexpected panic %v", v)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestGzip(t *testing.T) {
+	body := strings.Repeat("scraper ", 100)
+	h := middleware.Gzip(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/encoded" {
+			w.Header().Set("Content-Encoding", "br")
+		}
+		w.Write([]byte(body))
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip, deflate")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != "gzip" || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected headers %v", w.Header())
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(gz)
+	if err != nil || string(data) != body {
+		t.Errorf("unexpected body %q, error %v", data, err)
+	}
+
+	// an encoded response is not compressed again
+	r = httptest.NewRequest(http.MethodGet, "/encoded", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != "br" || w.Body.String() != body {
+		t.Errorf("unexpected response %v %q", w.Header(), w.Body.String())
+	}
+}
+
+func TestCORS(t *testing.T) {
+	called := false
+	h := middleware.CORS([]string{"https://example.com"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	r := httptest.NewRequest(http.MethodOptions, "/check", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	r.Header.Set("Access-Control-Request-Headers", "user_key")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if called || w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Headers") != "user_key" {
+		t.Errorf("unexpected preflight %d %v", w.Code, w.Header())
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/check", nil)
+	r.Header.Set("Origin", "https://other.com")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if !called || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("unexpected response to another origin %v", w.Header())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	h := middleware.Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}), middleware.MaxBodySize(8), middleware.Timeout(50*time.Millisecond))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("too large body")))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("unexpected status %d for a large body", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("ok")))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status %d for a slow handler", w.Code)
+	}
+
+	// the versioned API replies the timeout in the JSON envelope
+	w = httptest.NewRecorder()
+	libs.APIVersionHandler(h).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/query", strings.NewReader("ok")))
+	var v libs.ErrorEnvelope
+	if err := json.Unmarshal(w.Body.Bytes(), &v); err != nil || v.Error.Code != "service_unavailable" {
+		t.Errorf("unexpected timeout %q", w.Body.String())
+	}
+
+	// a streamed response is not buffered, it is left to the handler once
+	// started
+	flushed := make(chan bool, 1)
+	w = httptest.NewRecorder()
+	middleware.Timeout(50*time.Millisecond)(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("first"))
+		http.NewResponseController(rw).Flush()
+		flushed <- w.Flushed && w.Body.String() == "first"
+		<-r.Context().Done()
+		rw.Write([]byte(" last"))
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if !<-flushed || w.Code != http.StatusOK || w.Body.String() != "first last" {
+		t.Errorf("unexpected streamed response %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestCredential(t *testing.T) {
+	ErrDown := errors.New("down")
+	authenticate := func(ctx context.Context, token string) (middleware.Identity, bool, error) {
+		switch token {
+		case "ops":
+			return middleware.Identity{Name: "ops", Scopes: []string{"read"}}, true, nil
+		case "down":
+			return middleware.Identity{}, false, ErrDown
+		}
+		return middleware.Identity{}, false, nil
+	}
+	var caller string
+	h := middleware.Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := middleware.Caller(r.Context())
+		caller = id.Name
+	}), middleware.Credential("token", authenticate, errors.New("bad token"), http.StatusUnauthorized), middleware.Scope("read", nil))
+
+	for _, c := range []struct {
+		token  string
+		status int
+	}{
+		{"ops", http.StatusOK},
+		{"wrong", http.StatusUnauthorized},
+		{"down", http.StatusBadGateway},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("token", c.token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("unexpected status %d for %s", w.Code, c.token)
+		}
+	}
+	if caller != "ops" {
+		t.Errorf("unexpected caller %q", caller)
+	}
+
+	h = middleware.Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		middleware.Credential("token", authenticate, errors.New("bad token"), http.StatusUnauthorized), middleware.Scope("write", nil))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("token", "ops")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("unexpected status %d out of scope", w.Code)
+	}
+}
@@ -0,0 +1,6 @@
+// Package rpc holds the gRPC services of the internal traffic between the
+// monitor and the services Sampler and Tracker, generated from the .proto
+// files.
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative sampler.proto tracker.proto
@@ -0,0 +1,249 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.27.1
+// source: sampler.proto
+
+// The internal API of the service Sampler, served by its --grpc_port.
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Sampler_GetAll_FullMethodName  = "/scraper.sampler.v1.Sampler/GetAll"
+	Sampler_GetMany_FullMethodName = "/scraper.sampler.v1.Sampler/GetMany"
+	Sampler_Probe_FullMethodName   = "/scraper.sampler.v1.Sampler/Probe"
+	Sampler_Watch_FullMethodName   = "/scraper.sampler.v1.Sampler/Watch"
+)
+
+// SamplerClient is the client API for Sampler service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type SamplerClient interface {
+	// GetAll returns the data of all targets having the tags.
+	GetAll(ctx context.Context, in *GetAllRequest, opts ...grpc.CallOption) (*Samples, error)
+	// GetMany returns the data of the known targets among the addresses.
+	GetMany(ctx context.Context, in *GetManyRequest, opts ...grpc.CallOption) (*Samples, error)
+	// Probe checks the targets now, the results are returned but not applied.
+	Probe(ctx context.Context, in *ProbeRequest, opts ...grpc.CallOption) (*Samples, error)
+	// Watch sends the data of the targets having the tags after every round.
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Samples], error)
+}
+
+type samplerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewSamplerClient(cc grpc.ClientConnInterface) SamplerClient {
+	return &samplerClient{cc}
+}
+
+func (c *samplerClient) GetAll(ctx context.Context, in *GetAllRequest, opts ...grpc.CallOption) (*Samples, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Samples)
+	err := c.cc.Invoke(ctx, Sampler_GetAll_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *samplerClient) GetMany(ctx context.Context, in *GetManyRequest, opts ...grpc.CallOption) (*Samples, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Samples)
+	err := c.cc.Invoke(ctx, Sampler_GetMany_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *samplerClient) Probe(ctx context.Context, in *ProbeRequest, opts ...grpc.CallOption) (*Samples, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Samples)
+	err := c.cc.Invoke(ctx, Sampler_Probe_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *samplerClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[Samples], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Sampler_ServiceDesc.Streams[0], Sampler_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRequest, Samples]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Sampler_WatchClient = grpc.ServerStreamingClient[Samples]
+
+// SamplerServer is the server API for Sampler service.
+// All implementations must embed UnimplementedSamplerServer
+// for forward compatibility.
+type SamplerServer interface {
+	// GetAll returns the data of all targets having the tags.
+	GetAll(context.Context, *GetAllRequest) (*Samples, error)
+	// GetMany returns the data of the known targets among the addresses.
+	GetMany(context.Context, *GetManyRequest) (*Samples, error)
+	// Probe checks the targets now, the results are returned but not applied.
+	Probe(context.Context, *ProbeRequest) (*Samples, error)
+	// Watch sends the data of the targets having the tags after every round.
+	Watch(*WatchRequest, grpc.ServerStreamingServer[Samples]) error
+	mustEmbedUnimplementedSamplerServer()
+}
+
+// UnimplementedSamplerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedSamplerServer struct{}
+
+func (UnimplementedSamplerServer) GetAll(context.Context, *GetAllRequest) (*Samples, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAll not implemented")
+}
+func (UnimplementedSamplerServer) GetMany(context.Context, *GetManyRequest) (*Samples, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMany not implemented")
+}
+func (UnimplementedSamplerServer) Probe(context.Context, *ProbeRequest) (*Samples, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Probe not implemented")
+}
+func (UnimplementedSamplerServer) Watch(*WatchRequest, grpc.ServerStreamingServer[Samples]) error {
+	return status.Errorf(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedSamplerServer) mustEmbedUnimplementedSamplerServer() {}
+func (UnimplementedSamplerServer) testEmbeddedByValue()                 {}
+
+// UnsafeSamplerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to SamplerServer will
+// result in compilation errors.
+type UnsafeSamplerServer interface {
+	mustEmbedUnimplementedSamplerServer()
+}
+
+func RegisterSamplerServer(s grpc.ServiceRegistrar, srv SamplerServer) {
+	// If the following call pancis, it indicates UnimplementedSamplerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Sampler_ServiceDesc, srv)
+}
+
+func _Sampler_GetAll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAllRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SamplerServer).GetAll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sampler_GetAll_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SamplerServer).GetAll(ctx, req.(*GetAllRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sampler_GetMany_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetManyRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SamplerServer).GetMany(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sampler_GetMany_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SamplerServer).GetMany(ctx, req.(*GetManyRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sampler_Probe_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ProbeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(SamplerServer).Probe(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Sampler_Probe_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(SamplerServer).Probe(ctx, req.(*ProbeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Sampler_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(SamplerServer).Watch(m, &grpc.GenericServerStream[WatchRequest, Samples]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Sampler_WatchServer = grpc.ServerStreamingServer[Samples]
+
+// Sampler_ServiceDesc is the grpc.ServiceDesc for Sampler service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Sampler_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "scraper.sampler.v1.Sampler",
+	HandlerType: (*SamplerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetAll",
+			Handler:    _Sampler_GetAll_Handler,
+		},
+		{
+			MethodName: "GetMany",
+			Handler:    _Sampler_GetMany_Handler,
+		},
+		{
+			MethodName: "Probe",
+			Handler:    _Sampler_Probe_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       _Sampler_Watch_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "sampler.proto",
+}
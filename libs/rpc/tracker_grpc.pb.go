@@ -0,0 +1,205 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.27.1
+// source: tracker.proto
+
+// The internal API of the service Tracker, served by its --grpc_port.
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	Tracker_Update_FullMethodName   = "/scraper.tracker.v1.Tracker/Update"
+	Tracker_QueryOne_FullMethodName = "/scraper.tracker.v1.Tracker/QueryOne"
+	Tracker_QueryAll_FullMethodName = "/scraper.tracker.v1.Tracker/QueryAll"
+)
+
+// TrackerClient is the client API for Tracker service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TrackerClient interface {
+	// Update adds the counters of requests by user.
+	Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*UpdateReply, error)
+	// QueryOne counts the requests of a user in a range of time.
+	QueryOne(ctx context.Context, in *QueryOneRequest, opts ...grpc.CallOption) (*Count, error)
+	// QueryAll counts the requests of all users in a range of time.
+	QueryAll(ctx context.Context, in *QueryAllRequest, opts ...grpc.CallOption) (*Count, error)
+}
+
+type trackerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTrackerClient(cc grpc.ClientConnInterface) TrackerClient {
+	return &trackerClient{cc}
+}
+
+func (c *trackerClient) Update(ctx context.Context, in *UpdateRequest, opts ...grpc.CallOption) (*UpdateReply, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UpdateReply)
+	err := c.cc.Invoke(ctx, Tracker_Update_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trackerClient) QueryOne(ctx context.Context, in *QueryOneRequest, opts ...grpc.CallOption) (*Count, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Count)
+	err := c.cc.Invoke(ctx, Tracker_QueryOne_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *trackerClient) QueryAll(ctx context.Context, in *QueryAllRequest, opts ...grpc.CallOption) (*Count, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Count)
+	err := c.cc.Invoke(ctx, Tracker_QueryAll_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TrackerServer is the server API for Tracker service.
+// All implementations must embed UnimplementedTrackerServer
+// for forward compatibility.
+type TrackerServer interface {
+	// Update adds the counters of requests by user.
+	Update(context.Context, *UpdateRequest) (*UpdateReply, error)
+	// QueryOne counts the requests of a user in a range of time.
+	QueryOne(context.Context, *QueryOneRequest) (*Count, error)
+	// QueryAll counts the requests of all users in a range of time.
+	QueryAll(context.Context, *QueryAllRequest) (*Count, error)
+	mustEmbedUnimplementedTrackerServer()
+}
+
+// UnimplementedTrackerServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTrackerServer struct{}
+
+func (UnimplementedTrackerServer) Update(context.Context, *UpdateRequest) (*UpdateReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Update not implemented")
+}
+func (UnimplementedTrackerServer) QueryOne(context.Context, *QueryOneRequest) (*Count, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryOne not implemented")
+}
+func (UnimplementedTrackerServer) QueryAll(context.Context, *QueryAllRequest) (*Count, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method QueryAll not implemented")
+}
+func (UnimplementedTrackerServer) mustEmbedUnimplementedTrackerServer() {}
+func (UnimplementedTrackerServer) testEmbeddedByValue()                 {}
+
+// UnsafeTrackerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TrackerServer will
+// result in compilation errors.
+type UnsafeTrackerServer interface {
+	mustEmbedUnimplementedTrackerServer()
+}
+
+func RegisterTrackerServer(s grpc.ServiceRegistrar, srv TrackerServer) {
+	// If the following call pancis, it indicates UnimplementedTrackerServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&Tracker_ServiceDesc, srv)
+}
+
+func _Tracker_Update_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrackerServer).Update(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Tracker_Update_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrackerServer).Update(ctx, req.(*UpdateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tracker_QueryOne_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryOneRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrackerServer).QueryOne(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Tracker_QueryOne_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrackerServer).QueryOne(ctx, req.(*QueryOneRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Tracker_QueryAll_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryAllRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TrackerServer).QueryAll(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Tracker_QueryAll_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TrackerServer).QueryAll(ctx, req.(*QueryAllRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Tracker_ServiceDesc is the grpc.ServiceDesc for Tracker service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Tracker_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "scraper.tracker.v1.Tracker",
+	HandlerType: (*TrackerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Update",
+			Handler:    _Tracker_Update_Handler,
+		},
+		{
+			MethodName: "QueryOne",
+			Handler:    _Tracker_QueryOne_Handler,
+		},
+		{
+			MethodName: "QueryAll",
+			Handler:    _Tracker_QueryAll_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "tracker.proto",
+}
@@ -0,0 +1,73 @@
+package libs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Server is the HTTP server of a service, it serves HTTPS when given TLS
+// options and drains the in-flight requests on shutdown.
+type Server struct {
+	srv  *http.Server
+	tls  *TLSOptions
+	done chan struct{}
+}
+
+// NewServer creates the server listening at the port, a nil handler uses
+// http.DefaultServeMux and nil TLS options serve plain HTTP. The common
+// middlewares are applied by the caller, see middleware.Service.
+func NewServer(port int, handler http.Handler, opts *TLSOptions) (*Server, error) {
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	s := &Server{
+		srv:  &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: handler},
+		done: make(chan struct{}),
+	}
+	if opts != nil && opts.CertFile != "" {
+		cfg, err := opts.ServerConfig()
+		if err != nil {
+			return nil, err
+		}
+		s.srv.TLSConfig = cfg
+		s.tls = opts
+	}
+	return s, nil
+}
+
+// Start serves in background, it panics if the server can't listen.
+func (s *Server) Start() {
+	go func() {
+		defer close(s.done)
+		var err error
+		if s.tls != nil {
+			slog.Info("start listen TLS", "address", s.srv.Addr, "client_certificate", s.tls.CAFile != "")
+			err = s.srv.ListenAndServeTLS("", "")
+		} else {
+			slog.Info("start listen", "address", s.srv.Addr)
+			err = s.srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			panic(err)
+		}
+	}()
+}
+
+// Shutdown stops accepting connections and waits up to the timeout for the
+// in-flight requests, the remaining connections are closed after it.
+func (s *Server) Shutdown(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	slog.Info("shutdown server", "address", s.srv.Addr)
+	err := s.srv.Shutdown(ctx)
+	if err != nil {
+		s.srv.Close()
+	}
+	<-s.done
+	return err
+}
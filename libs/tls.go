@@ -0,0 +1,165 @@
+package libs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidCA         = errors.New("no certificate found in CA file")
+	ErrInvalidTLSVersion = errors.New("invalid TLS version")
+)
+
+// TLSOptions are the certificate files of a service. On the server side, a
+// client CA requires callers to present a certificate signed by it (mutual
+// TLS). On the client side, the CA verifies the server and the certificate
+// authenticates the client.
+type TLSOptions struct {
+	CertFile   string
+	KeyFile    string
+	CAFile     string
+	MinVersion string // "1.2" or "1.3", default "1.2"
+	// how often a server checks the files for changes, default 5 seconds
+	ReloadPeriod time.Duration
+}
+
+func (opts *TLSOptions) Enabled() bool {
+	return opts.CertFile != "" || opts.CAFile != ""
+}
+
+func (opts *TLSOptions) minVersion() (uint16, error) {
+	switch opts.MinVersion {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("%w: %s", ErrInvalidTLSVersion, opts.MinVersion)
+}
+
+func loadCertPool(filename string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCA, filename)
+	}
+	return pool, nil
+}
+
+func (opts *TLSOptions) ClientConfig() (*tls.Config, error) {
+	min_version, err := opts.minVersion()
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{MinVersion: min_version}
+	if opts.CAFile != "" {
+		pool, err := loadCertPool(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+	if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// ServerConfig returns a configuration which reloads the certificate, its key
+// and the client CA when the files change on disk, so certificates can be
+// renewed without a restart.
+func (opts *TLSOptions) ServerConfig() (*tls.Config, error) {
+	min_version, err := opts.minVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	cr := &certReloader{opts: *opts, base: &tls.Config{MinVersion: min_version}}
+	if cr.opts.ReloadPeriod <= 0 {
+		cr.opts.ReloadPeriod = 5 * time.Second
+	}
+	if err = cr.load(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion:         min_version,
+		GetConfigForClient: cr.configForClient,
+	}, nil
+}
+
+type certReloader struct {
+	opts       TLSOptions
+	base       *tls.Config
+	mtx        sync.Mutex
+	config     *tls.Config
+	modtimes   [3]time.Time
+	last_check time.Time
+}
+
+func (cr *certReloader) stat() [3]time.Time {
+	var v [3]time.Time
+	for i, filename := range []string{cr.opts.CertFile, cr.opts.KeyFile, cr.opts.CAFile} {
+		if filename == "" {
+			continue
+		}
+		if info, err := os.Stat(filename); err == nil {
+			v[i] = info.ModTime()
+		}
+	}
+	return v
+}
+
+func (cr *certReloader) load() error {
+	modtimes := cr.stat()
+	cert, err := tls.LoadX509KeyPair(cr.opts.CertFile, cr.opts.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	cfg := cr.base.Clone()
+	cfg.Certificates = []tls.Certificate{cert}
+	if cr.opts.CAFile != "" {
+		pool, err := loadCertPool(cr.opts.CAFile)
+		if err != nil {
+			return err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	cr.config = cfg
+	cr.modtimes = modtimes
+	return nil
+}
+
+func (cr *certReloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	cr.mtx.Lock()
+	defer cr.mtx.Unlock()
+
+	now := time.Now()
+	if now.Sub(cr.last_check) >= cr.opts.ReloadPeriod {
+		cr.last_check = now
+		if cr.stat() != cr.modtimes {
+			// keep serving the previous certificate if the new files are not valid yet
+			if err := cr.load(); err != nil {
+				slog.Error("reload certificate", "file", cr.opts.CertFile, "error", err)
+			} else {
+				slog.Info("reloaded certificate", "file", cr.opts.CertFile)
+			}
+		}
+	}
+	return cr.config, nil
+}
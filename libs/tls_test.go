@@ -0,0 +1,169 @@
+package libs_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"scraper/libs"
+	"scraper/libs/middleware"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// writeCert creates a certificate signed by the parent (self-signed if nil)
+// and writes it with its key to dir/name.crt and dir/name.key.
+func writeCert(t *testing.T, dir, name string, parent *testCert, ca bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  ca,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signer_key := tmpl, key
+	if parent != nil {
+		signer, signer_key = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signer_key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	key_der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key_der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := writeCert(t, dir, "ca", nil, true)
+	writeCert(t, dir, "server", ca, false)
+	writeCert(t, dir, "client", ca, false)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	server_opts := &libs.TLSOptions{CertFile: path("server.crt"), KeyFile: path("server.key"), CAFile: path("ca.crt")}
+	cfg, err := server_opts.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewUnstartedServer(middleware.APIKey("secret")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})))
+	ts.TLS = cfg
+	ts.StartTLS()
+	defer ts.Close()
+
+	client, err := libs.NewServiceClient("secret", &libs.TLSOptions{CertFile: path("client.crt"), KeyFile: path("client.key"), CAFile: path("ca.crt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := client.Get(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status %d", r.StatusCode)
+	}
+
+	// without the client certificate the handshake fails
+	anonymous, err := libs.NewServiceClient("secret", &libs.TLSOptions{CAFile: path("ca.crt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, err = anonymous.Get(context.Background(), ts.URL); err == nil {
+		r.Body.Close()
+		t.Errorf("expected handshake error without client certificate")
+	}
+
+	// without the API key the request is rejected
+	client.APIKey = ""
+	r, err = client.Get(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, r.StatusCode)
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := writeCert(t, dir, "ca", nil, true)
+	first := writeCert(t, dir, "server", ca, false)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	opts := &libs.TLSOptions{CertFile: path("server.crt"), KeyFile: path("server.key"), MinVersion: "1.3", ReloadPeriod: time.Nanosecond}
+	cfg, err := opts.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 {
+		t.Errorf("unexpected min version %x", cfg.MinVersion)
+	}
+
+	serial := func() *big.Int {
+		c, err := cfg.GetConfigForClient(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x, err := x509.ParseCertificate(c.Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return x.SerialNumber
+	}
+	if serial().Cmp(first.cert.SerialNumber) != 0 {
+		t.Fatalf("unexpected initial certificate")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	second := writeCert(t, dir, "server", ca, false)
+	if serial().Cmp(second.cert.SerialNumber) != 0 {
+		t.Errorf("expected the renewed certificate to be served")
+	}
+
+	if _, err = (&libs.TLSOptions{CertFile: path("server.crt"), KeyFile: path("server.key"), MinVersion: "1.0"}).ServerConfig(); err == nil {
+		t.Errorf("expected error for TLS 1.0")
+	}
+}
@@ -0,0 +1,323 @@
+package libs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The kinds of spans, as in OTLP.
+const (
+	SpanInternal = 1
+	SpanServer   = 2
+	SpanClient   = 3
+)
+
+// SpanContext identifies a span across the services, it is propagated in the
+// W3C Trace Context header "traceparent".
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte // 1: sampled
+}
+
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&1 == 1
+}
+
+// Traceparent returns the value of the header, ex:
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), sc.Flags)
+}
+
+// ParseTraceparent parses the header, ok is false if it is invalid.
+func ParseTraceparent(s string) (sc SpanContext, ok bool) {
+	v := strings.Split(strings.TrimSpace(s), "-")
+	if len(v) < 4 || len(v[0]) != 2 || v[0] == "ff" || len(v[1]) != 32 || len(v[2]) != 16 || len(v[3]) != 2 {
+		return sc, false
+	}
+	if v[0] == "00" && len(v) != 4 {
+		return sc, false
+	}
+	var flags [1]byte
+	_, e1 := hex.Decode(sc.TraceID[:], []byte(v[1]))
+	_, e2 := hex.Decode(sc.SpanID[:], []byte(v[2]))
+	_, e3 := hex.Decode(flags[:], []byte(v[3]))
+	if e1 != nil || e2 != nil || e3 != nil || !sc.Valid() {
+		return SpanContext{}, false
+	}
+	sc.Flags = flags[0]
+	return sc, true
+}
+
+// Attribute is a key-value pair describing a span, the value is a string, a
+// bool, an integer or a float.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is a timed operation of a trace. A nil span is not recorded, all its
+// methods are no-ops.
+type Span struct {
+	Context    SpanContext
+	Parent     [8]byte
+	Name       string
+	Kind       int
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes []Attribute
+	Error      string
+	tracer     *Tracer
+	mtx        sync.Mutex
+}
+
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.Attributes = append(s.Attributes, Attribute{key, value})
+}
+
+// SetError flags the span as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.Error = err.Error()
+}
+
+// End records the span, it is exported in the next batch.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mtx.Lock()
+	s.EndTime = time.Now()
+	s.mtx.Unlock()
+	s.tracer.record(s)
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+// SpanFromContext returns the current span, nil if none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// spanContext returns the context of the current span, or the one received
+// from the caller when not tracing.
+func spanContext(ctx context.Context) (SpanContext, bool) {
+	if s := SpanFromContext(ctx); s != nil {
+		return s.Context, true
+	}
+	sc, ok := ctx.Value(remoteKey{}).(SpanContext)
+	return sc, ok
+}
+
+// Inject sets the header "traceparent" of an outgoing request.
+func Inject(ctx context.Context, h http.Header) {
+	if sc, ok := spanContext(ctx); ok {
+		h.Set("traceparent", sc.Traceparent())
+	}
+}
+
+// Extract returns the context carrying the span of the caller given in the
+// header "traceparent", if valid.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, ok := ParseTraceparent(h.Get("traceparent"))
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// DefaultTracer records the spans of the service, it is disabled until Init.
+var DefaultTracer = new(Tracer)
+
+// StartSpan starts a span of DefaultTracer as a child of the current one.
+func StartSpan(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	return DefaultTracer.Start(ctx, name, kind)
+}
+
+const (
+	traceBatchSize     = 512
+	traceQueueSize     = 8192
+	traceFlushInterval = 5 * time.Second
+)
+
+// Tracer batches the ended spans and exports them.
+type Tracer struct {
+	service   string
+	exporters []SpanExporter
+	queue     chan *Span
+	done      chan struct{}
+	enabled   bool
+	mtx       sync.RWMutex
+}
+
+// Init enables the tracer of the service, nothing is recorded without
+// exporters.
+func (t *Tracer) Init(service string, exporters ...SpanExporter) {
+	if len(exporters) == 0 {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.service = service
+	t.exporters = exporters
+	t.queue = make(chan *Span, traceQueueSize)
+	t.done = make(chan struct{})
+	t.enabled = true
+	go t.loop(t.queue, t.done)
+}
+
+func (t *Tracer) Enabled() bool {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	return t.enabled
+}
+
+func newID(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+}
+
+// Start starts a span as a child of the current one or of the caller's, a
+// new trace is started if none. The span is nil if the tracer is disabled or
+// the caller does not sample the trace.
+func (t *Tracer) Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	if !t.Enabled() {
+		return ctx, nil
+	}
+	parent, ok := spanContext(ctx)
+	if ok && !parent.Sampled() {
+		return ctx, nil
+	}
+
+	s := &Span{Name: name, Kind: kind, StartTime: time.Now(), tracer: t}
+	if ok {
+		s.Context.TraceID = parent.TraceID
+		s.Parent = parent.SpanID
+	} else {
+		newID(s.Context.TraceID[:])
+	}
+	newID(s.Context.SpanID[:])
+	s.Context.Flags = 1
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (t *Tracer) record(s *Span) {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	if !t.enabled {
+		return
+	}
+	select {
+	case t.queue <- s:
+	default:
+		slog.Warn("tracer queue full, span dropped", "span", s.Name)
+	}
+}
+
+func (t *Tracer) loop(queue chan *Span, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	for {
+		select {
+		case s, ok := <-queue:
+			if !ok {
+				t.export(batch)
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) < traceBatchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		t.export(batch)
+		batch = nil
+	}
+}
+
+func (t *Tracer) export(spans []*Span) {
+	if len(spans) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, e := range t.exporters {
+		if err := e.Export(ctx, t.service, spans); err != nil {
+			slog.Error("tracer export", "spans", len(spans), "error", err)
+		}
+	}
+}
+
+// Shutdown exports the pending spans and disables the tracer.
+func (t *Tracer) Shutdown(timeout time.Duration) {
+	t.mtx.Lock()
+	if !t.enabled {
+		t.mtx.Unlock()
+		return
+	}
+	t.enabled = false
+	close(t.queue)
+	done := t.done
+	t.mtx.Unlock()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Error("tracer pending spans not exported", "timeout", timeout.String())
+	}
+	for _, e := range t.exporters {
+		if err := e.Close(); err != nil {
+			slog.Error("tracer close exporter", "error", err)
+		}
+	}
+}
+
+// TraceHandler starts a server span for every request, continuing the trace
+// of the caller.
+func TraceHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := Extract(r.Context(), r.Header)
+		ctx, span := StartSpan(ctx, r.Method+" "+r.URL.Path, SpanServer)
+		if span == nil {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		defer span.End()
+
+		sr := NewStatusRecorder(w)
+		next.ServeHTTP(sr, r.WithContext(ctx))
+		span.SetAttr("http.method", r.Method)
+		span.SetAttr("http.target", r.URL.Path)
+		span.SetAttr("http.status_code", sr.Status)
+		if sr.Status >= 500 {
+			span.SetError(fmt.Errorf("status %d", sr.Status))
+		}
+	})
+}
@@ -0,0 +1,217 @@
+package libs
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SpanExporter writes batches of ended spans.
+type SpanExporter interface {
+	Export(ctx context.Context, service string, spans []*Span) error
+	Close() error
+}
+
+// The OTLP JSON encoding of spans, see opentelemetry-proto
+// ExportTraceServiceRequest. The ids are in hex and the 64 bits integers in
+// decimal strings.
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 0: unset, 2: error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func otlpAttr(key string, value interface{}) otlpAttribute {
+	var v otlpValue
+	switch x := value.(type) {
+	case string:
+		v.StringValue = &x
+	case bool:
+		v.BoolValue = &x
+	case int:
+		s := strconv.Itoa(x)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(x, 10)
+		v.IntValue = &s
+	case float64:
+		v.DoubleValue = &x
+	case time.Duration:
+		f := x.Seconds()
+		v.DoubleValue = &f
+	default:
+		s := fmt.Sprint(x)
+		v.StringValue = &s
+	}
+	return otlpAttribute{Key: key, Value: v}
+}
+
+// EncodeOTLP returns the spans of the service in OTLP JSON.
+func EncodeOTLP(service string, spans []*Span) ([]byte, error) {
+	var ss otlpScopeSpans
+	ss.Scope.Name = "scraper"
+	for _, s := range spans {
+		s.mtx.Lock()
+		x := otlpSpan{
+			TraceID:           hex.EncodeToString(s.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.Context.SpanID[:]),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+		}
+		if s.Parent != [8]byte{} {
+			x.ParentSpanID = hex.EncodeToString(s.Parent[:])
+		}
+		for _, a := range s.Attributes {
+			x.Attributes = append(x.Attributes, otlpAttr(a.Key, a.Value))
+		}
+		if s.Error != "" {
+			x.Status = otlpStatus{Code: 2, Message: s.Error}
+		}
+		s.mtx.Unlock()
+		ss.Spans = append(ss.Spans, x)
+	}
+
+	var rs otlpResourceSpans
+	rs.Resource.Attributes = []otlpAttribute{otlpAttr("service.name", service)}
+	rs.ScopeSpans = []otlpScopeSpans{ss}
+	return json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{rs}})
+}
+
+// OTLPExporter posts the spans to an OpenTelemetry collector over OTLP/HTTP
+// with the JSON encoding.
+type OTLPExporter struct {
+	url    string
+	client *http.Client
+}
+
+// NewOTLPExporter creates the exporter to the traces endpoint of the
+// collector, ex: http://localhost:4318/v1/traces. The calls are not traced.
+func NewOTLPExporter(url string) *OTLPExporter {
+	return &OTLPExporter{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (oe *OTLPExporter) Export(ctx context.Context, service string, spans []*Span) error {
+	data, err := EncodeOTLP(service, spans)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, oe.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	resp, err := oe.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returns code %d: %s", resp.StatusCode, msg)
+	}
+	return nil
+}
+
+func (oe *OTLPExporter) Close() error {
+	return nil
+}
+
+// FileExporter appends the batches of spans to a file in OTLP JSON, one batch
+// per line, for local testing.
+type FileExporter struct {
+	f   *os.File
+	mtx sync.Mutex
+}
+
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{f: f}, nil
+}
+
+func (fe *FileExporter) Export(ctx context.Context, service string, spans []*Span) error {
+	data, err := EncodeOTLP(service, spans)
+	if err != nil {
+		return err
+	}
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+	_, err = fe.f.Write(append(data, '\n'))
+	return err
+}
+
+func (fe *FileExporter) Close() error {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+	return fe.f.Close()
+}
+
+// NewSpanExporters creates the exporters given by the arguments of a
+// service, both are optional.
+func NewSpanExporters(otlp_url, file string) ([]SpanExporter, error) {
+	var v []SpanExporter
+	if otlp_url != "" {
+		v = append(v, NewOTLPExporter(otlp_url))
+	}
+	if file != "" {
+		fe, err := NewFileExporter(file)
+		if err != nil {
+			return nil, err
+		}
+		v = append(v, fe)
+	}
+	return v, nil
+}
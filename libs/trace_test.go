@@ -0,0 +1,118 @@
+package libs_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"scraper/libs"
+	"testing"
+	"time"
+)
+
+func TestTraceparent(t *testing.T) {
+	s := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := libs.ParseTraceparent(s)
+	if !ok || !sc.Sampled() || sc.Traceparent() != s {
+		t.Errorf("unexpected span context %+v", sc)
+	}
+	for _, s := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		if _, ok := libs.ParseTraceparent(s); ok {
+			t.Errorf("expected %q to be invalid", s)
+		}
+	}
+}
+
+type spanFile struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []struct {
+				Key   string `json:"key"`
+				Value struct {
+					StringValue string `json:"stringValue"`
+				} `json:"value"`
+			} `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceID      string `json:"traceId"`
+				SpanID       string `json:"spanId"`
+				ParentSpanID string `json:"parentSpanId"`
+				Name         string `json:"name"`
+				Kind         int    `json:"kind"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func TestTracePropagation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	fe, err := libs.NewFileExporter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	libs.DefaultTracer.Init("test", fe)
+
+	backend := httptest.NewServer(libs.TraceHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})))
+	defer backend.Close()
+
+	client, err := libs.NewServiceClient("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frontend := httptest.NewServer(libs.TraceHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := client.Get(r.Context(), backend.URL+"/backend")
+		if err != nil {
+			libs.InternalServerError(w, err)
+			return
+		}
+		resp.Body.Close()
+	})))
+	defer frontend.Close()
+
+	const trace_id = "4bf92f3577b34da6a3ce929d0e0e4736"
+	r, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, frontend.URL+"/frontend", nil)
+	r.Header.Set("traceparent", "00-"+trace_id+"-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	libs.DefaultTracer.Shutdown(5 * time.Second)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f spanFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		t.Fatalf("invalid OTLP JSON: %v\n%s", err, data)
+	}
+	if f.ResourceSpans[0].Resource.Attributes[0].Value.StringValue != "test" {
+		t.Errorf("unexpected resource %s", data)
+	}
+
+	// the spans by kind and name, ex: "2 GET /frontend" for the server span
+	spans := map[string]struct{ id, parent string }{}
+	for _, p := range f.ResourceSpans[0].ScopeSpans[0].Spans {
+		if p.TraceID != trace_id {
+			t.Errorf("span %s not in the trace of the caller: %s", p.Name, p.TraceID)
+		}
+		spans[fmt.Sprintf("%d %s", p.Kind, p.Name)] = struct{ id, parent string }{p.SpanID, p.ParentSpanID}
+	}
+	server, client_span, backend_span := spans["2 GET /frontend"], spans["3 GET /backend"], spans["2 GET /backend"]
+	if len(spans) != 3 || server.parent != "00f067aa0ba902b7" || client_span.parent != server.id || backend_span.parent != client_span.id {
+		t.Errorf("unexpected spans %s", data)
+	}
+}
@@ -2,38 +2,26 @@ package libs
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
-	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 )
 
+// WaitCtrlC waits for CTRL+C (SIGINT) or SIGTERM, ex: from an orchestrator.
 func WaitCtrlC() {
 	cwait := make(chan os.Signal, 1)
-	signal.Notify(cwait, os.Interrupt)
+	signal.Notify(cwait, os.Interrupt, syscall.SIGTERM)
 	<-cwait
+	signal.Stop(cwait)
 }
 
-type CheckAPIKeyFn func(http.ResponseWriter, *http.Request) bool
-
-func MakeCheckAPIKey(apiKey string) CheckAPIKeyFn {
-	if len(apiKey) == 0 {
-		return func(w http.ResponseWriter, r *http.Request) bool {
-			return true
-		}
-	}
-
-	k := apiKey
-	return func(w http.ResponseWriter, r *http.Request) bool {
-		if r.Header.Get("api-key") != k {
-			http.Error(w, "incorrect API key", http.StatusUnauthorized)
-			return false
-		}
-		return true
-	}
-}
+var (
+	ErrIncorrectAPIKey  = errors.New("incorrect API key")
+	ErrMethodNotAllowed = errors.New(http.StatusText(http.StatusMethodNotAllowed))
+)
 
 func ReadBody(r *http.Request) ([]byte, error) {
 	data, err := io.ReadAll(r.Body)
@@ -56,8 +44,25 @@ func JSONReply(w http.ResponseWriter, x interface{}) error {
 	return json.NewEncoder(w).Encode(x)
 }
 
+// ServerError replies the error as text, followed by the id of the request
+// set by LogHandler to find its log lines. The versioned API replies it in
+// the JSON envelope.
 func ServerError(w http.ResponseWriter, err error, status_code int) {
-	http.Error(w, err.Error(), status_code)
+	if Versioned(w) {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Type", "application/json")
+		h.Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(status_code)
+		json.NewEncoder(w).Encode(NewErrorEnvelope(w, err, status_code))
+		return
+	}
+
+	msg := err.Error()
+	if id := w.Header().Get(RequestIDHeader); id != "" {
+		msg += " (request_id: " + id + ")"
+	}
+	http.Error(w, msg, status_code)
 }
 
 func InternalServerError(w http.ResponseWriter, err error) {
@@ -85,14 +90,6 @@ func Unique[T comparable](v []T) []T {
 	return rs
 }
 
-func Serve(port int) {
-	log.Printf("start listen at :%d", port)
-	err := http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
-	if err != nil {
-		panic(err)
-	}
-}
-
 // FileExists checks a file exists or not.
 func FileExists(filename string) bool {
 	info, err := os.Stat(filename)
@@ -101,3 +98,22 @@ func FileExists(filename string) bool {
 	}
 	return !info.IsDir()
 }
+
+// StatusRecorder is a http.ResponseWriter which records the status code.
+type StatusRecorder struct {
+	http.ResponseWriter
+	Status int
+}
+
+func (sr *StatusRecorder) WriteHeader(status_code int) {
+	sr.Status = status_code
+	sr.ResponseWriter.WriteHeader(status_code)
+}
+
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (sr *StatusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
@@ -1,142 +1,590 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"scraper/libs"
+	"scraper/libs/middleware"
 	"scraper/monitor/src/monitor"
+	"scraper/sampler/src/sampler"
+	"scraper/tracker/src/tracker"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/alexflint/go-arg"
+	"google.golang.org/grpc"
 )
 
 type appArgs struct {
-	Port           int    `arg:"-p,--port" default:"8090" help:"the server listening port."`
-	AdminToken     string `arg:"-a,--admin" default:"" help:"the admin's token to use this service"`
-	SamplerService string `arg:"-s,--sampler,required" help:"the address of the service Sampler, ex: http://localhost:8092"`
-	SamplingPeriod int    `arg:"--period" default:"300" help:"the period in second to update data from Sampler"`
-	TrackerService string `arg:"-t,--tracker,required" help:"the address of the service Tracker, ex: http://localhost:8091"`
-	TrackerPeriod  int    `arg:"--tracker_period" default:"30" help:"the period in second to update service Tracker"`
+	Port            int           `arg:"-p,--port" default:"8090" help:"the server listening port."`
+	AdminToken      string        `arg:"-a,--admin" default:"" secret:"true" help:"the admin's token to use this service, it is allowed to all scopes"`
+	AdminTokens     string        `arg:"--admin_tokens" default:"" help:"the JSON file of named admin tokens with scopes"`
+	SamplerService  string        `arg:"-s,--sampler" help:"the address of the service Sampler, ex: http://localhost:8092, required unless all-in-one"`
+	SamplingPeriod  int           `arg:"--period" default:"300" help:"the period in second to update data from Sampler"`
+	TrackerService  string        `arg:"-t,--tracker" help:"the address of the service Tracker, ex: http://localhost:8091, required unless all-in-one"`
+	TrackerPeriod   int           `arg:"--tracker_period" default:"30" help:"the period in second to update service Tracker"`
+	SamplerGRPC     string        `arg:"--sampler_grpc" default:"" help:"the gRPC address of the service Sampler to fetch the data, ex: localhost:9092, JSON over HTTP if empty"`
+	TrackerGRPC     string        `arg:"--tracker_grpc" default:"" help:"the gRPC address of the service Tracker to send the counters, ex: localhost:9091, JSON over HTTP if empty"`
+	AlertWebhook    string        `arg:"--alert_webhook" default:"" secret:"true" help:"the URL to post alerts to when a target goes down"`
+	UserCacheTTL    int           `arg:"--user_cache_ttl" default:"60" help:"the time in second a verified user key is cached"`
+	LegacyUserID    bool          `arg:"--legacy_user_id" help:"deprecated, trust the header user_id of the former API without a key while migrating the clients, removed in the next release"`
+	SamplerKey      string        `arg:"--sampler_key" default:"" secret:"true" help:"the API key to access the service Sampler"`
+	TrackerKey      string        `arg:"--tracker_key" default:"" secret:"true" help:"the API key to access the service Tracker"`
+	UpstreamCert    string        `arg:"--upstream_cert" default:"" help:"the client certificate file presented to Sampler and Tracker (mutual TLS)"`
+	UpstreamKey     string        `arg:"--upstream_key" default:"" help:"the key file of the client certificate"`
+	UpstreamCA      string        `arg:"--upstream_ca" default:"" help:"the CA file verifying the certificates of Sampler and Tracker"`
+	UpstreamTimeout int           `arg:"--upstream_timeout" default:"10" help:"the timeout in second of a call to Sampler or Tracker"`
+	UpstreamRetries int           `arg:"--upstream_retries" default:"2" help:"the number of retries of a failed call to Sampler or Tracker"`
+	BreakerFailures int           `arg:"--breaker_failures" default:"5" help:"the consecutive failures after which the calls to a service are stopped, 0 to disable"`
+	BreakerCooldown int           `arg:"--breaker_cooldown" default:"30" help:"the time in second before calling again a service after its breaker opened"`
+	ServerCert      string        `arg:"--server_cert" default:"" help:"the certificate file to serve HTTPS, reloaded when it changes"`
+	ServerKey       string        `arg:"--server_key" default:"" help:"the key file of the certificate"`
+	LegacyCert      string        `arg:"--tls_cert" default:"" help:"deprecated, the former name of --upstream_cert"`
+	LegacyKey       string        `arg:"--tls_key" default:"" help:"deprecated, the former name of --upstream_key"`
+	LegacyCA        string        `arg:"--tls_ca" default:"" help:"deprecated, the former name of --upstream_ca"`
+	ClientCA        string        `arg:"--client_ca" default:"" help:"the CA file verifying client certificates, enables mutual TLS"`
+	TLSMinVersion   string        `arg:"--tls_min_version" default:"1.2" help:"the minimum TLS version: 1.2 or 1.3"`
+	MaxBodySize     int64         `arg:"--max_body" default:"1048576" help:"the maximum size in byte of a request body, 0 for no limit"`
+	RequestTimeout  int           `arg:"--request_timeout" default:"60" help:"the time in second to handle a request, 0 for no limit"`
+	CORSOrigins     []string      `arg:"--cors_origin,separate" help:"an origin allowed to call this service from a browser, * for any"`
+	ShutdownTimeout int           `arg:"--shutdown_timeout" default:"10" help:"the time in second to drain in-flight requests on shutdown"`
+	LogLevel        string        `arg:"--log_level" default:"info" help:"the minimum level of the JSON logs: debug, info, warn or error"`
+	TraceOTLP       string        `arg:"--trace_otlp" default:"" help:"the OTLP/HTTP traces endpoint of the collector, ex: http://localhost:4318/v1/traces"`
+	TraceFile       string        `arg:"--trace_file" default:"" help:"the file the spans are appended to in OTLP JSON, for local testing"`
+	AllInOne        *allInOneArgs `arg:"subcommand:all-in-one" help:"run the services Sampler and Tracker in this process"`
+	libs.ConfigArgs
 }
 
+// Validate checks the arguments once loaded from the configuration
+func (a *appArgs) Validate() error {
+	var err error
+	if a.AllInOne == nil && (a.SamplerService == "" || a.TrackerService == "") {
+		err = ErrMissingServices
+	}
+	return errors.Join(err,
+		deprecated("tls_cert", "upstream_cert", a.LegacyCert, &a.UpstreamCert),
+		deprecated("tls_key", "upstream_key", a.LegacyKey, &a.UpstreamKey),
+		deprecated("tls_ca", "upstream_ca", a.LegacyCA, &a.UpstreamCA),
+		libs.CheckRange("port", int64(a.Port), 1, 65535),
+		libs.CheckMin("period", int64(a.SamplingPeriod), 1),
+		libs.CheckMin("tracker_period", int64(a.TrackerPeriod), 1),
+		libs.CheckMin("user_cache_ttl", int64(a.UserCacheTTL), 0),
+		libs.CheckMin("upstream_timeout", int64(a.UpstreamTimeout), 1),
+		libs.CheckRange("upstream_retries", int64(a.UpstreamRetries), 0, 100),
+		libs.CheckMin("breaker_failures", int64(a.BreakerFailures), 0),
+		libs.CheckMin("breaker_cooldown", int64(a.BreakerCooldown), 0),
+		libs.CheckMin("max_body", a.MaxBodySize, 0),
+		libs.CheckMin("request_timeout", int64(a.RequestTimeout), 0),
+		libs.CheckMin("shutdown_timeout", int64(a.ShutdownTimeout), 0),
+		libs.CheckOneOf("tls_min_version", a.TLSMinVersion, "1.2", "1.3"),
+		libs.CheckOneOf("log_level", strings.ToLower(a.LogLevel), "debug", "info", "warn", "error"),
+	)
+}
+
+// deprecated sets the argument from its deprecated flag, both can't be given
+// different values
+func deprecated(old, name, legacy string, value *string) error {
+	if legacy == "" {
+		return nil
+	}
+	if *value != "" && *value != legacy {
+		return fmt.Errorf("%w: --%s is the deprecated name of --%s, give only one", libs.ErrInvalidConfig, old, name)
+	}
+	*value = legacy
+	return nil
+}
+
+// allInOneArgs are the arguments of the services Sampler and Tracker running
+// in the monitor's process, the sampling period is the monitor's one
+type allInOneArgs struct {
+	SitesFile string   `arg:"-f,--file" default:"sites.txt" help:"the file contains list of address"`
+	Timeout   int      `arg:"--timeout" default:"60" help:"sampling timeout in second"`
+	Canaries  []string `arg:"--canary,separate" help:"the address checked before each round to verify the sampler's own network, ex: 192.168.1.1:53"`
+	MaxFailed float64  `arg:"--max_failed" default:"0" help:"the ratio of failed targets above which a round is suspect, 0 to disable"`
+	DBFile    string   `arg:"-d,--db" default:"db" help:"the database file of the tracker"`
+}
+
+// localPeriod is the period the data of the sampler running in process are
+// copied to the cache
+const localPeriod = 5 * time.Second
+
 var (
-	ErrInvalidUserID       = errors.New("invalid user id")
 	ErrIncorrectAdminToken = errors.New("incorrect admin token")
+	ErrAdminScope          = errors.New("admin token not allowed to this scope")
+	ErrMissingTarget       = errors.New("missing target")
+	ErrMissingServices     = errors.New("--sampler and --tracker are required unless all-in-one")
 )
 
 var (
-	adminToken string
-	tk         *monitor.Tracker
-	sm         *monitor.Sampler
+	server          *libs.Server
+	shutdownTimeout time.Duration
+	admins          monitor.AdminTokens
+	tk              *monitor.Tracker
+	sm              *monitor.Sampler
+	conns           []*grpc.ClientConn
+	local_sm        *sampler.Manager // the sampler running in process, all-in-one
+	local_tk        *tracker.Tracker // the tracker running in process, all-in-one
+	legacyUserID    bool             // accepts the deprecated header user_id
+	// the effective configuration, secrets redacted
+	effective   map[string]interface{}
+	mux         = http.NewServeMux()
+	httpMetrics = libs.NewHTTPMetrics(libs.DefaultRegistry, "scraper_monitor")
 )
 
 func startup() {
 	var err error
 	var a appArgs
-	arg.MustParse(&a)
+	libs.MustLoadConfig("monitor", &a)
+	effective = libs.EffectiveConfig(&a)
+	err = libs.InitLogger(a.LogLevel)
+	if err != nil {
+		panic(err)
+	}
+
+	if a.LegacyCert != "" || a.LegacyKey != "" || a.LegacyCA != "" {
+		slog.Warn("--tls_cert, --tls_key and --tls_ca are deprecated, use --upstream_cert, --upstream_key and --upstream_ca for the client certificate, --server_cert and --server_key to serve HTTPS")
+	}
+
+	exporters, err := libs.NewSpanExporters(a.TraceOTLP, a.TraceFile)
+	if err != nil {
+		panic(err)
+	}
+	libs.DefaultTracer.Init("monitor", exporters...)
+
+	if a.AdminToken != "" {
+		err = admins.Add(monitor.AdminToken{Name: "admin", Token: a.AdminToken, Scopes: monitor.AllScopes})
+		if err != nil {
+			panic(err)
+		}
+	}
+	if a.AdminTokens != "" {
+		err = admins.Load(a.AdminTokens)
+		if err != nil {
+			panic(err)
+		}
+	}
 
-	adminToken = a.AdminToken
 	tk, err = monitor.NewTracker(a.TrackerService, time.Duration(a.TrackerPeriod)*time.Second)
 	if err != nil {
 		panic(err)
 	}
-	sm = monitor.NewSampler(a.SamplerService, time.Duration(a.SamplingPeriod)*time.Second)
+	tk.SetUserCacheTTL(time.Duration(a.UserCacheTTL) * time.Second)
+	period := time.Duration(a.SamplingPeriod) * time.Second
+	if a.AllInOne != nil {
+		period = localPeriod
+	}
+	sm = monitor.NewSampler(a.SamplerService, period)
+	if a.AllInOne != nil {
+		startLocal(a)
+	} else {
+		connect(a)
+	}
+	sm.Alerter().Init(a.AlertWebhook)
+
+	legacyUserID = a.LegacyUserID
+	user := []middleware.Middleware{
+		legacyUser,
+		middleware.Credential("user_key", authenticateUser, monitor.ErrInvalidUserKey, http.StatusUnauthorized),
+		countUser,
+	}
+	handle("/force", force, user...)
+	handle("/check", check, user...)
+	handle("/min", min, user...)
+	handle("/max", max, user...)
+	handle("/uptime", uptime, user...)
+	handle("/tags", tags, user...)
+	mux.HandleFunc("/metrics", libs.MetricsHandler)
+	mux.HandleFunc(libs.APIPrefix+"/", libs.NotFound)
+	handle("/healthz", libs.Healthz)
+	handle("/openapi.json", monitor.ServeOpenAPI)
+	handle("/readyz", libs.MakeReadyz(5*time.Second, map[string]libs.ReadyCheck{
+		"sampler": func(ctx context.Context) (interface{}, error) {
+			return sm.Breaker(), sm.Ping(ctx)
+		},
+		"tracker": func(ctx context.Context) (interface{}, error) {
+			return tk.Breaker(), tk.Ping(ctx)
+		},
+		"cache": func(ctx context.Context) (interface{}, error) {
+			return sm.CheckCache()
+		},
+	}))
 
-	http.HandleFunc("/force", force)
-	http.HandleFunc("/check", check)
-	http.HandleFunc("/min", min)
-	http.HandleFunc("/max", max)
-	http.HandleFunc("/admin_query_one", one)
-	http.HandleFunc("/admin_query_all", all)
+	if admins.Empty() {
+		slog.Warn("no admin token given, admin routes are disabled")
+	} else {
+		handle("/admin_query_one", one, admin(monitor.ScopeReadStats)...)
+		handle("/admin_query_all", all, admin(monitor.ScopeReadStats)...)
+		handle("/admin_user_create", users, admin(monitor.ScopeManageUsers)...)
+		handle("/admin_user_rotate", users, admin(monitor.ScopeManageUsers)...)
+		handle("/admin_user_revoke", users, admin(monitor.ScopeManageUsers)...)
+		handle("/admin_maintenance", maintenance, admin(monitor.ScopeManageTargets)...)
+		handle("/admin_incidents", incidents, admin(monitor.ScopeReadStats)...)
+		handle("/admin_dependencies", dependencies, admin(monitor.ScopeReadStats)...)
+		handle("/admin_audit", forward, admin(monitor.ScopeReadAudit)...)
+		handle("/admin_config", config, admin(monitor.ScopeReadConfig)...)
+	}
 
-	go libs.Serve(a.Port)
+	var tls_opts *libs.TLSOptions
+	if a.ServerCert != "" {
+		tls_opts = &libs.TLSOptions{CertFile: a.ServerCert, KeyFile: a.ServerKey, CAFile: a.ClientCA, MinVersion: a.TLSMinVersion}
+	}
+	handler := middleware.Service(mux, middleware.Options{
+		MaxBodySize: a.MaxBodySize,
+		Timeout:     time.Duration(a.RequestTimeout) * time.Second,
+		CORSOrigins: a.CORSOrigins,
+	})
+	server, err = libs.NewServer(a.Port, handler, tls_opts)
+	if err != nil {
+		panic(err)
+	}
+	server.Start()
+	shutdownTimeout = time.Duration(a.ShutdownTimeout) * time.Second
 }
 
-func checkUserID(w http.ResponseWriter, r *http.Request) bool {
-	user := r.Header.Get("user_id")
-	if len(user) == 0 {
-		libs.BadRequest(w, ErrInvalidUserID)
-		return false
+// connect sets the clients of the services Sampler and Tracker
+func connect(a appArgs) {
+	client_tls := &libs.TLSOptions{CertFile: a.UpstreamCert, KeyFile: a.UpstreamKey, CAFile: a.UpstreamCA}
+	resilience := libs.DefaultResilience
+	resilience.Timeout = time.Duration(a.UpstreamTimeout) * time.Second
+	resilience.Retries = a.UpstreamRetries
+	resilience.BreakerFailures = a.BreakerFailures
+	resilience.BreakerCooldown = time.Duration(a.BreakerCooldown) * time.Second
+	client, err := libs.NewServiceClient(a.TrackerKey, client_tls)
+	if err != nil {
+		panic(err)
+	}
+	client.SetResilience(resilience)
+	tk.SetClient(client)
+	if a.TrackerGRPC != "" {
+		conn, err := client.DialGRPC(a.TrackerGRPC)
+		if err != nil {
+			panic(err)
+		}
+		conns = append(conns, conn)
+		tk.SetTransport(monitor.NewGRPCTracker(conn, tk.Transport()))
+	}
+	client, err = libs.NewServiceClient(a.SamplerKey, client_tls)
+	if err != nil {
+		panic(err)
+	}
+	client.SetResilience(resilience)
+	sm.SetClient(client)
+	if a.SamplerGRPC != "" {
+		conn, err := client.DialGRPC(a.SamplerGRPC)
+		if err != nil {
+			panic(err)
+		}
+		conns = append(conns, conn)
+		sm.SetTransport(monitor.NewGRPCSampler(conn, sm.Transport()))
+	}
+}
+
+// startLocal starts the services Sampler and Tracker in process, the monitor
+// calls them directly
+func startLocal(a appArgs) {
+	data, err := os.ReadFile(a.AllInOne.SitesFile)
+	if err != nil {
+		panic(err)
+	}
+	sites, err := sampler.ParseSites(string(data))
+	if err != nil {
+		panic(err)
+	}
+	local_sm, err = sampler.NewSamplerManagerFromSites(time.Duration(a.SamplingPeriod)*time.Second, time.Duration(a.AllInOne.Timeout)*time.Second, sites)
+	if err != nil {
+		panic(err)
+	}
+	local_sm.SetSelfCheck(a.AllInOne.Canaries, a.AllInOne.MaxFailed)
+
+	local_tk = new(tracker.Tracker)
+	slog.Info("open database", "folder", a.AllInOne.DBFile)
+	err = local_tk.Init(a.AllInOne.DBFile)
+	if err != nil {
+		panic(err)
 	}
 
-	tk.Trigger(user)
-	return true
+	sm.SetTransport(monitor.NewDirectSampler(local_sm))
+	tk.SetTransport(monitor.NewDirectTracker(local_tk))
 }
 
-func force(w http.ResponseWriter, r *http.Request) {
-	if !checkUserID(w, r) {
-		return
+// handle registers the handler under the pattern and its versioned one,
+// wrapped with the middlewares, their requests and latencies are exported
+// under their pattern
+func handle(pattern string, fn http.HandlerFunc, mws ...middleware.Middleware) {
+	for _, p := range []string{pattern, libs.APIPrefix + pattern} {
+		mux.Handle(p, middleware.Chain(fn, append([]middleware.Middleware{middleware.Metrics(httpMetrics, p)}, mws...)...))
+	}
+}
+
+type legacyUserKey struct{}
+
+// legacyUser marks the requests sending the deprecated header user_id
+// without user_key when --legacy_user_id is given, their user is trusted
+// without a key while the clients migrate.
+func legacyUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user_id := r.Header.Get("user_id")
+		if !legacyUserID || user_id == "" || r.Header.Get("user_key") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		slog.WarnContext(r.Context(), "deprecated header user_id, send the API key in user_key", "user_id", user_id, "remote_addr", r.RemoteAddr)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), legacyUserKey{}, user_id)))
+	})
+}
+
+// authenticateUser verifies the user key with the service Tracker, or
+// trusts the deprecated header user_id marked by legacyUser
+func authenticateUser(ctx context.Context, key string) (middleware.Identity, bool, error) {
+	if user_id, ok := ctx.Value(legacyUserKey{}).(string); ok && key == "" {
+		return middleware.Identity{Name: user_id}, true, nil
+	}
+	user, err := tk.Authenticate(ctx, key)
+	if errors.Is(err, monitor.ErrInvalidUserKey) {
+		return middleware.Identity{}, false, nil
 	}
+	return middleware.Identity{Name: user}, err == nil, err
+}
+
+// countUser counts the request of the user for the service Tracker
+func countUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := middleware.Caller(r.Context())
+		tk.Trigger(id.Name)
+		next.ServeHTTP(w, r)
+	})
+}
 
+func force(w http.ResponseWriter, r *http.Request) {
 	target := r.URL.Query().Get("target")
-	log.Printf("force update target %s", target)
+	slog.InfoContext(r.Context(), "force update", "target", target)
+	versioned := libs.Versioned(w)
 	if target == "" {
+		if versioned {
+			libs.BadRequest(w, ErrMissingTarget)
+		}
 		return
 	}
 
 	sm.ForceUpdate(target)
+	if versioned {
+		libs.JSONReply(w, Forced{Target: target})
+	}
 }
 
-func check(w http.ResponseWriter, r *http.Request) {
-	if !checkUserID(w, r) {
-		return
-	}
+// Forced is the reply of the versioned /force
+type Forced struct {
+	Target string `json:"target"`
+}
 
-	targets := r.URL.Query()["target"]
-	log.Printf("check targets: %v", targets)
-	if len(targets) == 0 {
+func check(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	targets := q["target"]
+	tags := q["tag"]
+	slog.InfoContext(r.Context(), "check", "targets", targets, "tags", tags)
+	if len(targets) == 0 && len(tags) == 0 {
 		w.Write([]byte("{}"))
 		return
 	}
 
-	libs.JSONReply(w, sm.Query(targets))
+	_, span := libs.StartSpan(r.Context(), "cache query", libs.SpanInternal)
+	v := sm.QueryTags(targets, tags)
+	span.End()
+	libs.JSONReply(w, v)
 }
 
 func min(w http.ResponseWriter, r *http.Request) {
-	if !checkUserID(w, r) {
+	tags := r.URL.Query()["tag"]
+	if len(tags) > 0 {
+		libs.JSONReply(w, sm.MinTagged(tags))
 		return
 	}
-
 	libs.JSONReply(w, sm.Min())
 }
 
 func max(w http.ResponseWriter, r *http.Request) {
-	if !checkUserID(w, r) {
+	tags := r.URL.Query()["tag"]
+	if len(tags) > 0 {
+		libs.JSONReply(w, sm.MaxTagged(tags))
 		return
 	}
-
 	libs.JSONReply(w, sm.Max())
 }
 
-func one(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("admin_token") != adminToken {
-		libs.BadRequest(w, ErrIncorrectAdminToken)
-		return
+func uptime(w http.ResponseWriter, r *http.Request) {
+	targets := r.URL.Query()["target"]
+	libs.JSONReply(w, sm.Uptime(targets))
+}
+
+func tags(w http.ResponseWriter, r *http.Request) {
+	libs.JSONReply(w, sm.TagSummaries())
+}
+
+// admin checks the admin token is allowed to the scope, logs the action and
+// records it in the audit log of the service Tracker, the ones denied the
+// scope included. The requests without a valid token are not recorded.
+func admin(scope string) []middleware.Middleware {
+	return []middleware.Middleware{
+		middleware.Credential("admin_token", authenticateAdmin, ErrIncorrectAdminToken, http.StatusUnauthorized),
+		audit,
+		middleware.Scope(scope, ErrAdminScope),
 	}
+}
 
+func authenticateAdmin(ctx context.Context, token string) (middleware.Identity, bool, error) {
+	at, ok := admins.Authenticate(token)
+	if !ok {
+		return middleware.Identity{}, false, nil
+	}
+	return middleware.Identity{Name: at.Name, Scopes: at.Scopes}, true, nil
+}
+
+// audit records the request of the authenticated admin
+func audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := middleware.Caller(r.Context())
+		entry := monitor.AuditEntry{
+			Actor:     id.Name,
+			Endpoint:  r.Method + " " + r.URL.Path,
+			Params:    r.URL.RawQuery,
+			CreatedAt: time.Now().Unix(),
+		}
+		sr := libs.NewStatusRecorder(w)
+		next.ServeHTTP(sr, r)
+		entry.Status = sr.Status
+		slog.InfoContext(r.Context(), "admin", "admin", entry.Actor, "method", r.Method, "url", r.URL.String(), "status", sr.Status)
+		tk.Audit(entry)
+	})
+}
+
+func config(w http.ResponseWriter, r *http.Request) {
+	libs.JSONReply(w, effective)
+}
+
+func forward(w http.ResponseWriter, r *http.Request) {
 	tk.Forward(w, r)
 }
 
+// replyCount replies the number as JSON, or as text to the unversioned API
+func replyCount(w http.ResponseWriter, n int64, err error) {
+	if err != nil {
+		libs.ServerError(w, err, libs.UpstreamStatus(err))
+		return
+	}
+	if libs.Versioned(w) {
+		libs.JSONReply(w, tracker.Count{Count: n})
+		return
+	}
+	w.Write([]byte(strconv.FormatInt(n, 10)))
+}
+
+func one(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to, err := tracker.ParseRange(q)
+	if err != nil {
+		libs.BadRequest(w, err)
+		return
+	}
+	n, err := tk.QueryOne(r.Context(), q.Get("user"), from, to)
+	replyCount(w, n, err)
+}
+
 func all(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("admin_token") != adminToken {
-		libs.BadRequest(w, ErrIncorrectAdminToken)
+	from, to, err := tracker.ParseRange(r.URL.Query())
+	if err != nil {
+		libs.BadRequest(w, err)
 		return
 	}
+	n, err := tk.QueryAll(r.Context(), from, to)
+	replyCount(w, n, err)
+}
 
+func users(w http.ResponseWriter, r *http.Request) {
 	tk.Forward(w, r)
+	if strings.TrimPrefix(r.URL.Path, libs.APIPrefix) != "/admin_user_create" {
+		tk.InvalidateUser(r.URL.Query().Get("user"))
+	}
+}
+
+func maintenance(w http.ResponseWriter, r *http.Request) {
+	mm := sm.Maintenance()
+	switch r.Method {
+	case http.MethodGet:
+		libs.JSONReply(w, mm.List())
+	case http.MethodPost:
+		var mw monitor.MaintenanceWindow
+		err := libs.JSONParse(r, &mw)
+		if err != nil {
+			libs.BadRequest(w, err)
+			return
+		}
+		p, err := mm.Add(mw)
+		if err != nil {
+			libs.BadRequest(w, err)
+			return
+		}
+		slog.InfoContext(r.Context(), "add maintenance window", "id", p.ID, "targets", p.Targets)
+		libs.JSONReply(w, p)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		err := mm.Remove(id)
+		if err != nil {
+			libs.ServerError(w, err, http.StatusNotFound)
+			return
+		}
+		slog.InfoContext(r.Context(), "remove maintenance window", "id", id)
+		if libs.Versioned(w) {
+			libs.JSONReply(w, monitor.MaintenanceWindow{ID: id})
+		}
+	default:
+		libs.ServerError(w, libs.ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+	}
+}
+
+func incidents(w http.ResponseWriter, r *http.Request) {
+	libs.JSONReply(w, sm.Alerter().Incidents())
+}
+
+func dependencies(w http.ResponseWriter, r *http.Request) {
+	v, err := sm.Graph(r.Context())
+	if err != nil {
+		libs.ServerError(w, err, http.StatusBadGateway)
+		return
+	}
+	libs.JSONReply(w, v)
 }
 
 func exec() {
+	if local_sm != nil {
+		local_sm.Run()
+	}
 	sm.Run()
 	tk.Run()
 
 	fmt.Println("Press CTRL+C to exit.")
 	libs.WaitCtrlC()
 
+	err := server.Shutdown(shutdownTimeout)
+	if err != nil {
+		slog.Error("shutdown server", "error", err)
+	}
 	sm.Stop()
-	tk.Stop()
+	tk.Stop() // flushes the pending counters
+	for _, conn := range conns {
+		conn.Close()
+	}
+	if local_sm != nil {
+		local_sm.Stop()
+		err = local_tk.Close()
+		if err != nil {
+			slog.Error("close database", "error", err)
+		}
+	}
+	libs.DefaultTracer.Shutdown(5 * time.Second)
 	fmt.Println("bye bye!")
 }
 
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"scraper/libs"
+	"scraper/libs/middleware"
+	"scraper/monitor/src/monitor"
+	"strings"
+	"testing"
+)
+
+func TestEffectiveConfigRedacted(t *testing.T) {
+	var a appArgs
+	environ := map[string]string{
+		"SCRAPER_MONITOR_ADMIN":       "topsecret",
+		"SCRAPER_MONITOR_SAMPLER_KEY": "topsecret",
+		"SCRAPER_MONITOR_TRACKER_KEY": "topsecret",
+	}
+	_, err := libs.LoadConfig("monitor", &a, []string{
+		"--sampler", "http://monitor:pw@localhost:8092",
+		"--tracker", "http://localhost:8091?key=pw",
+		"--alert_webhook", "https://hooks.example.com/services/topsecret",
+	}, func(k string) (string, bool) {
+		v, ok := environ[k]
+		return v, ok
+	})
+	if err != nil || a.AdminToken != "topsecret" {
+		t.Fatalf("unexpected arguments %+v, %v", a, err)
+	}
+
+	var b strings.Builder
+	err = libs.WriteConfig(&b, "monitor", &a)
+	if err != nil || strings.Contains(b.String(), "topsecret") || strings.Contains(b.String(), "pw") {
+		t.Errorf("secrets in the effective configuration %q, %v", b.String(), err)
+	}
+	if m := libs.EffectiveConfig(&a); m["sampler"] != "http://[redacted]@localhost:8092" {
+		t.Errorf("unexpected sampler %v", m["sampler"])
+	}
+}
+
+func TestDeprecatedTLSFlags(t *testing.T) {
+	args := []string{"--sampler", "http://localhost:8092", "--tracker", "http://localhost:8091"}
+	env := func(string) (string, bool) { return "", false }
+
+	// --tls_cert is still the client certificate of the calls to the services
+	var a appArgs
+	_, err := libs.LoadConfig("monitor", &a, append(args, "--tls_cert", "client.pem", "--tls_key", "client.key", "--server_cert", "server.pem"), env)
+	if err != nil || a.UpstreamCert != "client.pem" || a.UpstreamKey != "client.key" || a.ServerCert != "server.pem" {
+		t.Errorf("unexpected arguments %+v, %v", a, err)
+	}
+
+	a = appArgs{}
+	_, err = libs.LoadConfig("monitor", &a, append(args, "--tls_cert", "client.pem", "--upstream_cert", "other.pem"), env)
+	if !errors.Is(err, libs.ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func TestLegacyUserID(t *testing.T) {
+	// the header user_id of the former API is not trusted by default
+	var a appArgs
+	_, err := libs.LoadConfig("monitor", &a, []string{"--sampler", "http://localhost:8092", "--tracker", "http://localhost:8091"}, func(string) (string, bool) { return "", false })
+	if err != nil || a.LegacyUserID {
+		t.Fatalf("unexpected arguments %+v, %v", a, err)
+	}
+
+	defer func(v bool) { legacyUserID = v }(legacyUserID)
+	handler := middleware.Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := middleware.Caller(r.Context())
+		w.Write([]byte(id.Name))
+	}), legacyUser, middleware.Credential("user_key", authenticateUser, monitor.ErrInvalidUserKey, http.StatusUnauthorized))
+
+	// it is trusted without a key only with --legacy_user_id
+	for accepted, code := range map[bool]int{true: http.StatusOK, false: http.StatusUnauthorized} {
+		legacyUserID = accepted
+		r := httptest.NewRequest(http.MethodGet, "/check", nil)
+		r.Header.Set("user_id", "alice")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != code || accepted && w.Body.String() != "alice" {
+			t.Errorf("unexpected reply %d %q with user_id accepted %v", w.Code, w.Body.String(), accepted)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package monitor
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	ScopeReadStats     = "read-stats"
+	ScopeManageTargets = "manage-targets"
+	ScopeManageUsers   = "manage-users"
+	ScopeReadAudit     = "read-audit"
+	ScopeReadConfig    = "read-config"
+)
+
+var AllScopes = []string{ScopeReadStats, ScopeManageTargets, ScopeManageUsers, ScopeReadAudit, ScopeReadConfig}
+
+var (
+	ErrEmptyAdminToken = errors.New("empty admin token")
+	ErrUnknownScope    = errors.New("unknown admin scope")
+)
+
+// AdminToken is a named admin token allowed to the given scopes.
+type AdminToken struct {
+	Name   string   `json:"name"`
+	Token  string   `json:"token"`
+	Scopes []string `json:"scopes"`
+
+	hash [sha256.Size]byte
+}
+
+func (at *AdminToken) Allowed(scope string) bool {
+	for _, s := range at.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminTokens authenticates admins, tokens are compared in constant time.
+type AdminTokens struct {
+	tokens []AdminToken
+}
+
+func (ats *AdminTokens) Add(at AdminToken) error {
+	if at.Token == "" {
+		return fmt.Errorf("%w: %s", ErrEmptyAdminToken, at.Name)
+	}
+	for _, s := range at.Scopes {
+		found := false
+		for _, x := range AllScopes {
+			found = found || s == x
+		}
+		if !found {
+			return fmt.Errorf("%w: %s of %s", ErrUnknownScope, s, at.Name)
+		}
+	}
+	at.hash = sha256.Sum256([]byte(at.Token))
+	at.Token = ""
+	ats.tokens = append(ats.tokens, at)
+	return nil
+}
+
+// Load adds the tokens of a JSON file, ex:
+// [{"name": "ops", "token": "...", "scopes": ["read-stats"]}]
+func (ats *AdminTokens) Load(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var v []AdminToken
+	err = json.Unmarshal(data, &v)
+	if err != nil {
+		return err
+	}
+	for _, at := range v {
+		if err = ats.Add(at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ats *AdminTokens) Empty() bool {
+	return len(ats.tokens) == 0
+}
+
+// Authenticate returns the admin owning the token, all tokens are compared so
+// the time does not depend on which one matches.
+func (ats *AdminTokens) Authenticate(token string) (*AdminToken, bool) {
+	h := sha256.Sum256([]byte(token))
+	var found *AdminToken
+	for i := range ats.tokens {
+		if subtle.ConstantTimeCompare(h[:], ats.tokens[i].hash[:]) == 1 {
+			found = &ats.tokens[i]
+		}
+	}
+	return found, found != nil && token != ""
+}
@@ -0,0 +1,48 @@
+package monitor_test
+
+import (
+	"os"
+	"path/filepath"
+	"scraper/monitor/src/monitor"
+	"testing"
+)
+
+func TestAdminTokens(t *testing.T) {
+	var ats monitor.AdminTokens
+	if !ats.Empty() {
+		t.Fatal("expected no admin token")
+	}
+	if err := ats.Add(monitor.AdminToken{Name: "empty"}); err == nil {
+		t.Errorf("expected error for empty token")
+	}
+
+	filename := filepath.Join(t.TempDir(), "admins.json")
+	err := os.WriteFile(filename, []byte(`[
+		{"name": "ops", "token": "ops-secret", "scopes": ["read-stats"]},
+		{"name": "root", "token": "root-secret", "scopes": ["read-stats", "manage-targets", "manage-users"]}
+	]`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ats.Load(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	at, ok := ats.Authenticate("ops-secret")
+	if !ok || at.Name != "ops" {
+		t.Fatalf("unexpected authentication %+v", at)
+	}
+	if !at.Allowed(monitor.ScopeReadStats) || at.Allowed(monitor.ScopeManageUsers) {
+		t.Errorf("unexpected scopes %v", at.Scopes)
+	}
+	if _, ok = ats.Authenticate("wrong"); ok {
+		t.Errorf("unexpected authentication of a wrong token")
+	}
+	if _, ok = ats.Authenticate(""); ok {
+		t.Errorf("unexpected authentication of an empty token")
+	}
+
+	if err = ats.Add(monitor.AdminToken{Name: "x", Token: "x", Scopes: []string{"everything"}}); err == nil {
+		t.Errorf("expected error for unknown scope")
+	}
+}
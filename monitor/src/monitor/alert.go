@@ -0,0 +1,99 @@
+package monitor
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const maxIncidents = 1000
+
+// Incident is opened when a target goes down and resolved when it is back.
+type Incident struct {
+	Target     string `json:"target"`
+	OpenedAt   int64  `json:"opened_at"`
+	ResolvedAt int64  `json:"resolved_at,omitempty"`
+}
+
+// Alerter fires an alert on the transition of a target from up to down.
+// Alerts are logged and, if a webhook is set, posted to it as JSON.
+type Alerter struct {
+	mtx       sync.Mutex
+	webhook   string
+	open      map[string]*Incident
+	incidents []*Incident
+}
+
+func (al *Alerter) Init(webhook string) {
+	al.mtx.Lock()
+	defer al.mtx.Unlock()
+	al.webhook = webhook
+	al.open = make(map[string]*Incident)
+	al.incidents = nil
+}
+
+func (al *Alerter) fire(p Incident) {
+	slog.Warn("alert: target is down", "target", p.Target)
+	if al.webhook == "" {
+		return
+	}
+
+	go func(webhook string) {
+		data, err := json.Marshal(p)
+		if err != nil {
+			slog.Error("alert webhook", "target", p.Target, "error", err)
+			return
+		}
+		r, err := http.Post(webhook, "application/json", bytes.NewBuffer(data))
+		if err != nil {
+			slog.Error("alert webhook", "target", p.Target, "error", err)
+			return
+		}
+		r.Body.Close()
+	}(al.webhook)
+}
+
+// Observe updates the state of a target, suppressed failures (e.g. during
+// maintenance) neither open nor resolve an incident.
+func (al *Alerter) Observe(address string, available, suppressed bool) {
+	if suppressed && !available {
+		return
+	}
+
+	al.mtx.Lock()
+	defer al.mtx.Unlock()
+	p, down := al.open[address]
+	if available {
+		if down {
+			p.ResolvedAt = time.Now().Unix()
+			delete(al.open, address)
+			slog.Info("resolved: target is up", "target", address)
+		}
+		return
+	}
+	if down {
+		return
+	}
+
+	p = &Incident{Target: address, OpenedAt: time.Now().Unix()}
+	al.open[address] = p
+	al.incidents = append(al.incidents, p)
+	if len(al.incidents) > maxIncidents {
+		al.incidents = al.incidents[len(al.incidents)-maxIncidents:]
+	}
+	al.fire(*p)
+}
+
+// Incidents returns the recent incidents, the latest last.
+func (al *Alerter) Incidents() []Incident {
+	al.mtx.Lock()
+	defer al.mtx.Unlock()
+	v := make([]Incident, len(al.incidents))
+	for i, p := range al.incidents {
+		v[i] = *p
+	}
+	return v
+}
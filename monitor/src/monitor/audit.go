@@ -0,0 +1,59 @@
+package monitor
+
+import (
+	"context"
+	"log/slog"
+)
+
+// maxAuditQueue is the number of entries kept while the service Tracker is
+// unavailable, the oldest are dropped beyond.
+const maxAuditQueue = 10000
+
+// AuditEntry is an admin request recorded in the service Tracker.
+type AuditEntry struct {
+	Actor     string `json:"actor"` // the name of the admin token
+	Endpoint  string `json:"endpoint"`
+	Params    string `json:"params,omitempty"`
+	Status    int    `json:"status"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// Audit queues the entry, queued entries are sent along with the counters.
+func (tk *Tracker) Audit(p AuditEntry) {
+	tk.audit_mtx.Lock()
+	defer tk.audit_mtx.Unlock()
+	tk.audit = append(tk.audit, p)
+	tk.trimAudit()
+}
+
+// trimAudit drops the oldest entries beyond maxAuditQueue, audit_mtx is locked
+func (tk *Tracker) trimAudit() {
+	n := len(tk.audit) - maxAuditQueue
+	if n <= 0 {
+		return
+	}
+	slog.Warn("tracker audit queue full", "dropped", n)
+	auditDropped.Add(float64(n))
+	tk.audit = append([]AuditEntry(nil), tk.audit[n:]...)
+}
+
+// flushAudit sends the queued entries, they are queued again on failure so
+// no entry is lost while the service Tracker is briefly unavailable.
+func (tk *Tracker) flushAudit(ctx context.Context) {
+	tk.audit_mtx.Lock()
+	v := tk.audit
+	tk.audit = nil
+	tk.audit_mtx.Unlock()
+	if len(v) == 0 {
+		return
+	}
+
+	err := tk.transport.Audit(ctx, v)
+	if err != nil {
+		tk.error(ctx, err)
+		tk.audit_mtx.Lock()
+		tk.audit = append(v, tk.audit...)
+		tk.trimAudit()
+		tk.audit_mtx.Unlock()
+	}
+}
@@ -72,6 +72,23 @@ func (sm *SafeStringMap[T]) SetMany(m map[string]T) {
 	}
 }
 
+// DeleteIf removes the items matching the predicate.
+func (sm *SafeStringMap[T]) DeleteIf(fn func(key string, value T) bool) {
+	sm.mtx.Lock()
+	defer sm.mtx.Unlock()
+	for k, v := range sm.data {
+		if fn(k, v) {
+			delete(sm.data, k)
+		}
+	}
+}
+
+func (sm *SafeStringMap[T]) Len() int {
+	sm.mtx.RLock()
+	defer sm.mtx.RUnlock()
+	return len(sm.data)
+}
+
 func (sm *SafeStringMap[T]) Clear() map[string]T {
 	sm.mtx.Lock()
 	defer sm.mtx.Unlock()
@@ -0,0 +1,158 @@
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCron = errors.New("invalid cron expression")
+
+// CronSchedule is a parsed standard 5-field cron expression:
+// minute hour day-of-month month day-of-week.
+type CronSchedule struct {
+	minute [60]bool
+	hour   [24]bool
+	dom    [32]bool
+	month  [13]bool
+	dow    [7]bool
+	// day-of-month and day-of-week are OR-ed when both are restricted
+	dom_any bool
+	dow_any bool
+}
+
+func parseCronField(s string, lo, hi int, out []bool) (bool, error) {
+	star := s == "*"
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			v, err := strconv.Atoi(part[i+1:])
+			if err != nil || v <= 0 {
+				return false, fmt.Errorf("%w: bad step %q", ErrInvalidCron, part)
+			}
+			step = v
+			part = part[:i]
+		}
+
+		from, to := lo, hi
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			v, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return false, fmt.Errorf("%w: bad value %q", ErrInvalidCron, part)
+			}
+			from, to = v, v
+			if len(bounds) == 2 {
+				to, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return false, fmt.Errorf("%w: bad value %q", ErrInvalidCron, part)
+				}
+			} else if step > 1 {
+				to = hi
+			}
+		}
+
+		if from < lo || to > hi || from > to {
+			return false, fmt.Errorf("%w: %q out of range [%d, %d]", ErrInvalidCron, part, lo, hi)
+		}
+		for v := from; v <= to; v += step {
+			out[v] = true
+		}
+	}
+	return star, nil
+}
+
+// ParseCron parses expressions like "0 2 * * 1-5" (02:00 on weekdays).
+func ParseCron(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: expected 5 fields, got %d", ErrInvalidCron, len(fields))
+	}
+
+	cs := new(CronSchedule)
+	var err error
+	if _, err = parseCronField(fields[0], 0, 59, cs.minute[:]); err != nil {
+		return nil, err
+	}
+	if _, err = parseCronField(fields[1], 0, 23, cs.hour[:]); err != nil {
+		return nil, err
+	}
+	if cs.dom_any, err = parseCronField(fields[2], 1, 31, cs.dom[:]); err != nil {
+		return nil, err
+	}
+	if _, err = parseCronField(fields[3], 1, 12, cs.month[:]); err != nil {
+		return nil, err
+	}
+
+	// both 0 and 7 mean Sunday
+	var dow [8]bool
+	if cs.dow_any, err = parseCronField(fields[4], 0, 7, dow[:]); err != nil {
+		return nil, err
+	}
+	copy(cs.dow[:], dow[:7])
+	cs.dow[0] = cs.dow[0] || dow[7]
+	return cs, nil
+}
+
+// Match reports whether the minute containing t is a start time of the schedule.
+func (cs *CronSchedule) Match(t time.Time) bool {
+	return cs.minute[t.Minute()] && cs.hour[t.Hour()] && cs.matchDay(t)
+}
+
+// matchDay reports whether the day of t has start times.
+func (cs *CronSchedule) matchDay(t time.Time) bool {
+	if !cs.month[t.Month()] {
+		return false
+	}
+
+	dom := cs.dom[t.Day()]
+	dow := cs.dow[t.Weekday()]
+	switch {
+	case cs.dom_any && cs.dow_any:
+		return true
+	case cs.dom_any:
+		return dow
+	case cs.dow_any:
+		return dom
+	}
+	return dom || dow
+}
+
+// prev returns the latest start time of the schedule in the minute of t or
+// before, ok is false if there is none since the limit. The days without a
+// start are skipped whole.
+func (cs *CronSchedule) prev(t, limit time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	for !t.Before(limit) {
+		if cs.matchDay(t) {
+			for h := t.Hour(); h >= 0; h-- {
+				if !cs.hour[h] {
+					continue
+				}
+				m := 59
+				if h == t.Hour() {
+					m = t.Minute()
+				}
+				for ; m >= 0; m-- {
+					if cs.minute[m] {
+						x := time.Date(t.Year(), t.Month(), t.Day(), h, m, 0, 0, t.Location())
+						return x, !x.Before(limit)
+					}
+				}
+			}
+		}
+		// the last minute of the day before
+		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).Add(-time.Minute)
+	}
+	return time.Time{}, false
+}
+
+// ActiveWithin reports whether the schedule fired within the duration d before t,
+// i.e. a window of length d started by the schedule still covers t.
+func (cs *CronSchedule) ActiveWithin(t time.Time, d time.Duration) bool {
+	t = t.Truncate(time.Minute)
+	start, ok := cs.prev(t, t.Add(-d))
+	return ok && t.Sub(start) < d
+}
@@ -0,0 +1,93 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"scraper/libs"
+	"scraper/sampler/src/sampler"
+	"scraper/tracker/src/tracker"
+)
+
+// directSampler calls the manager of the sampler running in the same
+// process, ex: in the all-in-one mode.
+type directSampler struct {
+	m *sampler.Manager
+}
+
+// NewDirectSampler returns the transport calling the manager in process.
+func NewDirectSampler(m *sampler.Manager) SamplerTransport {
+	return directSampler{m: m}
+}
+
+func (t directSampler) GetAll(ctx context.Context) ([]sampler.SampleData, string, error) {
+	return t.m.GetAll(), t.m.Health().Status, nil
+}
+
+func (t directSampler) GetMany(ctx context.Context, addresses []string) ([]sampler.SampleData, string, error) {
+	return t.m.GetMany(addresses), t.m.Health().Status, nil
+}
+
+func (t directSampler) Graph(ctx context.Context) ([]sampler.GraphNode, error) {
+	return t.m.Graph(), nil
+}
+
+func (t directSampler) Ping(ctx context.Context) error {
+	return t.m.Ready()
+}
+
+// directTracker calls the tracker running in the same process, ex: in the
+// all-in-one mode.
+type directTracker struct {
+	tk  *tracker.Tracker
+	mux *http.ServeMux // the JSON API of the tracker serving the forwarded requests
+}
+
+// NewDirectTracker returns the transport calling the tracker in process, its
+// database is open.
+func NewDirectTracker(tk *tracker.Tracker) TrackerTransport {
+	mux := http.NewServeMux()
+	for pattern, fn := range tk.Routes() {
+		mux.HandleFunc(pattern, fn)
+		mux.HandleFunc(libs.APIPrefix+pattern, fn)
+	}
+	return directTracker{tk: tk, mux: mux}
+}
+
+func (t directTracker) Update(ctx context.Context, counters map[string]int64) error {
+	return t.tk.Update(ctx, counters)
+}
+
+func (t directTracker) QueryOne(ctx context.Context, user_id string, from, to int64) (int64, error) {
+	from, to = tracker.TimeRange(from, to)
+	return t.tk.QueryOne(ctx, user_id, from, to)
+}
+
+func (t directTracker) QueryAll(ctx context.Context, from, to int64) (int64, error) {
+	from, to = tracker.TimeRange(from, to)
+	return t.tk.QueryAll(ctx, from, to)
+}
+
+func (t directTracker) Authenticate(ctx context.Context, key string) (string, error) {
+	user_id, err := t.tk.Authenticate(ctx, key)
+	if errors.Is(err, tracker.ErrInvalidKey) {
+		return "", ErrInvalidUserKey
+	}
+	return user_id, err
+}
+
+func (t directTracker) Audit(ctx context.Context, entries []AuditEntry) error {
+	v := make([]tracker.AuditEntry, len(entries))
+	for i, p := range entries {
+		v[i] = tracker.AuditEntry(p)
+	}
+	return t.tk.AppendAudit(ctx, v)
+}
+
+func (t directTracker) Forward(w http.ResponseWriter, r *http.Request) {
+	t.mux.ServeHTTP(w, r)
+}
+
+func (t directTracker) Ping(ctx context.Context) error {
+	return t.tk.Ping(ctx)
+}
@@ -0,0 +1,70 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"scraper/libs"
+	"time"
+)
+
+var ErrStaleCache = errors.New("the cache of the sampler's data is stale")
+
+func ping(ctx context.Context, client *libs.ServiceClient, service_address string) error {
+	r, err := client.Get(ctx, service_address+"/healthz")
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s/healthz returns code %d", service_address, r.StatusCode)
+	}
+	return nil
+}
+
+// Ping checks the service Sampler is reachable.
+func (sm *Sampler) Ping(ctx context.Context) error {
+	return sm.transport.Ping(ctx)
+}
+
+// Ping checks the service Tracker is reachable.
+func (tk *Tracker) Ping(ctx context.Context) error {
+	return tk.transport.Ping(ctx)
+}
+
+// Breaker returns the state of the circuit breaker of the calls to the
+// service Sampler.
+func (sm *Sampler) Breaker() libs.BreakerState {
+	return sm.client.Breaker.State()
+}
+
+// Breaker returns the state of the circuit breaker of the calls to the
+// service Tracker.
+func (tk *Tracker) Breaker() libs.BreakerState {
+	return tk.client.Breaker.State()
+}
+
+// CacheInfo is the freshness of the cached data of the service Sampler.
+type CacheInfo struct {
+	Targets   int     `json:"targets"`
+	UpdatedAt int64   `json:"updated_at,omitempty"`
+	Age       float64 `json:"age_seconds,omitempty"`
+}
+
+// CheckCache reports an error if the data were not updated for two periods.
+func (sm *Sampler) CheckCache() (CacheInfo, error) {
+	info := CacheInfo{Targets: sm.cache.Len()}
+	t := sm.updated_at.Get()
+	if t.IsZero() {
+		return info, fmt.Errorf("%w: never updated", ErrStaleCache)
+	}
+
+	age := time.Since(t)
+	info.UpdatedAt = t.Unix()
+	info.Age = age.Seconds()
+	if age > 2*sm.period {
+		return info, fmt.Errorf("%w: updated %v ago", ErrStaleCache, age.Round(time.Second))
+	}
+	return info, nil
+}
@@ -0,0 +1,147 @@
+package monitor
+
+import (
+	"errors"
+	"scraper/sampler/src/sampler"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrMaintenanceNoTarget = errors.New("maintenance window must have at least one target or tag")
+	ErrMaintenanceNoTime   = errors.New("maintenance window needs either start/end or cron/duration")
+	ErrMaintenanceNotFound = errors.New("maintenance window not found")
+	ErrMaintenanceDuration = errors.New("maintenance window duration must be at most 7 days")
+)
+
+// MaxMaintenanceDuration bounds the duration of a recurring window in second,
+// its activity is checked minute by minute.
+const MaxMaintenanceDuration = 7 * 24 * 3600
+
+// MaintenanceWindow is a planned downtime of some targets or of the targets
+// having all of the given tags, either one-off
+// (start/end in unix-epoch seconds) or recurring (cron expression in UTC and
+// a duration in seconds).
+type MaintenanceWindow struct {
+	ID       string   `json:"id"`
+	Targets  []string `json:"targets,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Start    int64    `json:"start,omitempty"`
+	End      int64    `json:"end,omitempty"`
+	Cron     string   `json:"cron,omitempty"`
+	Duration int64    `json:"duration,omitempty"`
+	Comment  string   `json:"comment,omitempty"`
+
+	schedule *CronSchedule
+}
+
+func (mw *MaintenanceWindow) validate() error {
+	if len(mw.Targets) == 0 && len(mw.Tags) == 0 {
+		return ErrMaintenanceNoTarget
+	}
+
+	if mw.Cron != "" {
+		if mw.Duration <= 0 {
+			return ErrMaintenanceNoTime
+		}
+		if mw.Duration > MaxMaintenanceDuration {
+			return ErrMaintenanceDuration
+		}
+		cs, err := ParseCron(mw.Cron)
+		if err != nil {
+			return err
+		}
+		mw.schedule = cs
+		return nil
+	}
+
+	if mw.Start <= 0 || mw.End <= mw.Start {
+		return ErrMaintenanceNoTime
+	}
+	return nil
+}
+
+// Active reports whether the window covers the time t.
+func (mw *MaintenanceWindow) Active(t time.Time) bool {
+	if mw.schedule != nil {
+		return mw.schedule.ActiveWithin(t.UTC(), time.Duration(mw.Duration)*time.Second)
+	}
+	u := t.Unix()
+	return u >= mw.Start && u < mw.End
+}
+
+func (mw *MaintenanceWindow) covers(address string, tags []string) bool {
+	for _, s := range mw.Targets {
+		if s == address {
+			return true
+		}
+	}
+	return len(mw.Tags) > 0 && sampler.HasTags(tags, mw.Tags)
+}
+
+type MaintenanceManager struct {
+	mtx     sync.RWMutex
+	windows map[string]*MaintenanceWindow
+	last_id int64
+}
+
+func (mm *MaintenanceManager) Init() {
+	mm.mtx.Lock()
+	defer mm.mtx.Unlock()
+	mm.windows = make(map[string]*MaintenanceWindow)
+}
+
+// Add validates the window and registers it, the assigned id is returned.
+func (mm *MaintenanceManager) Add(mw MaintenanceWindow) (*MaintenanceWindow, error) {
+	err := mw.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	mm.mtx.Lock()
+	defer mm.mtx.Unlock()
+	mm.last_id++
+	mw.ID = strconv.FormatInt(mm.last_id, 10)
+	p := &mw
+	mm.windows[mw.ID] = p
+	return p, nil
+}
+
+func (mm *MaintenanceManager) Remove(id string) error {
+	mm.mtx.Lock()
+	defer mm.mtx.Unlock()
+	if _, ok := mm.windows[id]; !ok {
+		return ErrMaintenanceNotFound
+	}
+	delete(mm.windows, id)
+	return nil
+}
+
+func (mm *MaintenanceManager) List() []MaintenanceWindow {
+	mm.mtx.RLock()
+	defer mm.mtx.RUnlock()
+	v := make([]MaintenanceWindow, 0, len(mm.windows))
+	for _, p := range mm.windows {
+		v = append(v, *p)
+	}
+	sort.Slice(v, func(i, j int) bool {
+		a, _ := strconv.ParseInt(v[i].ID, 10, 64)
+		b, _ := strconv.ParseInt(v[j].ID, 10, 64)
+		return a < b
+	})
+	return v
+}
+
+// InMaintenance reports whether any window covering the target is active at t.
+func (mm *MaintenanceManager) InMaintenance(address string, tags []string, t time.Time) bool {
+	mm.mtx.RLock()
+	defer mm.mtx.RUnlock()
+	for _, p := range mm.windows {
+		if p.covers(address, tags) && p.Active(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package monitor_test
+
+import (
+	"errors"
+	"scraper/monitor/src/monitor"
+	"testing"
+	"time"
+)
+
+func TestCronSchedule(t *testing.T) {
+	cs, err := monitor.ParseCron("30 2 * * 1-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	monday := time.Date(2023, 5, 1, 2, 30, 0, 0, time.UTC)
+	if !cs.Match(monday) {
+		t.Errorf("expected match at %v", monday)
+	}
+	if cs.Match(monday.AddDate(0, 0, 5)) {
+		t.Errorf("unexpected match on saturday")
+	}
+	if !cs.ActiveWithin(monday.Add(59*time.Minute), time.Hour) {
+		t.Errorf("expected window to be active")
+	}
+	if cs.ActiveWithin(monday.Add(time.Hour), time.Hour) {
+		t.Errorf("expected window to be over")
+	}
+
+	// the latest start is found without stepping the window minute by minute
+	for _, expr := range []string{"30 2 * * 1-5", "*/15 9-17 * * *", "0 0 1 * *", "0 12 29 2 *", "5 4 13 * 5", "59 23 31 12 *"} {
+		cs, err := monitor.ParseCron(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range []time.Duration{time.Minute, 90 * time.Minute, 24 * time.Hour, monitor.MaxMaintenanceDuration * time.Second} {
+			for at := time.Date(2024, 2, 25, 0, 7, 0, 0, time.UTC); at.Before(time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)); at = at.Add(37 * time.Minute) {
+				active := false
+				for dt := time.Duration(0); dt < d && !active; dt += time.Minute {
+					active = cs.Match(at.Add(-dt))
+				}
+				if cs.ActiveWithin(at, d) != active {
+					t.Fatalf("%q for %v at %v: expected active %v", expr, d, at, active)
+				}
+			}
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "a * * * *"} {
+		if _, err := monitor.ParseCron(expr); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
+
+func TestMaintenanceManager(t *testing.T) {
+	var mm monitor.MaintenanceManager
+	mm.Init()
+
+	now := time.Now()
+	p, err := mm.Add(monitor.MaintenanceWindow{
+		Targets: []string{"jd.com"},
+		Start:   now.Add(-time.Minute).Unix(),
+		End:     now.Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !mm.InMaintenance("jd.com", nil, now) {
+		t.Errorf("expected jd.com in maintenance")
+	}
+	if mm.InMaintenance("live.com", nil, now) {
+		t.Errorf("unexpected live.com in maintenance")
+	}
+
+	if _, err = mm.Add(monitor.MaintenanceWindow{Targets: []string{"jd.com"}, Cron: "0 * * * *"}); err == nil {
+		t.Errorf("expected error for cron window without duration")
+	}
+	if _, err = mm.Add(monitor.MaintenanceWindow{Targets: []string{"jd.com"}, Cron: "0 * * * *", Duration: monitor.MaxMaintenanceDuration + 1}); !errors.Is(err, monitor.ErrMaintenanceDuration) {
+		t.Errorf("expected ErrMaintenanceDuration, got %v", err)
+	}
+
+	_, err = mm.Add(monitor.MaintenanceWindow{
+		Tags:  []string{"team=payments"},
+		Start: now.Add(-time.Minute).Unix(),
+		End:   now.Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mm.InMaintenance("alipay.com", []string{"region=cn", "team=payments"}, now) {
+		t.Errorf("expected alipay.com in maintenance by tag")
+	}
+
+	if err = mm.Remove(p.ID); err != nil {
+		t.Fatal(err)
+	}
+	if mm.InMaintenance("jd.com", nil, now) {
+		t.Errorf("unexpected jd.com in maintenance after removal")
+	}
+}
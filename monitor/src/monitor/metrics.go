@@ -0,0 +1,12 @@
+package monitor
+
+import "scraper/libs"
+
+var (
+	upstreamErrors = libs.DefaultRegistry.NewCounter("scraper_monitor_upstream_errors_total",
+		"The number of failed calls to the upstream services: sampler or tracker.", "upstream")
+	cacheTargets = libs.DefaultRegistry.NewGauge("scraper_monitor_cache_targets",
+		"The number of targets in the cache of the sampler's data.")
+	auditDropped = libs.DefaultRegistry.NewCounter("scraper_monitor_audit_dropped_total",
+		"The number of audit entries dropped while the tracker was unavailable.")
+)
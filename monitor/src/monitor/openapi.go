@@ -0,0 +1,18 @@
+package monitor
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// OpenAPI is the OpenAPI 3 document of the monitor's API, the package
+// scraper/client follows it.
+//
+//go:embed openapi.json
+var OpenAPI []byte
+
+// ServeOpenAPI serves the OpenAPI document.
+func ServeOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(OpenAPI)
+}
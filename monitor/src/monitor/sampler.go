@@ -1,89 +1,72 @@
 package monitor
 
 import (
-	"bytes"
-	"encoding/json"
-	"io"
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
+	"scraper/libs"
 	"scraper/sampler/src/sampler"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
 type Sampler struct {
 	service_address        string
+	client                 *libs.ServiceClient
+	transport              SamplerTransport
 	url_request_update_all string
 	url_request_query      string
 	period                 time.Duration
-	cache                  SafeStringMap[sampler.Status]
+	cache                  SafeStringMap[sampler.SampleData]
 	force                  SafeStringMap[bool]
 	wg                     sync.WaitGroup
-	running                atomic.Bool
-	update_evt             chan bool
-	force_evt              chan bool
+	run_mtx                sync.Mutex
+	cancel                 context.CancelFunc
 	min                    SafeValue[*sampler.SampleData]
 	max                    SafeValue[*sampler.SampleData]
+	sampler_status         SafeValue[string]
+	updated_at             SafeValue[time.Time] // the last successful update from the service Sampler
+	maintenance            MaintenanceManager
+	uptime                 UptimeManager
+	alerter                Alerter
 }
 
 func (sm *Sampler) Init() {
 	sm.cache.Clear()
 	sm.force.Clear()
+	sm.maintenance.Init()
+	sm.uptime.Init()
+	sm.alerter.Init("")
 	sm.url_request_update_all = sm.service_address + "/all"
 	sm.url_request_query = sm.service_address + "/query"
-	sm.update_evt = make(chan bool)
-	sm.force_evt = make(chan bool)
 }
 
-func (sm *Sampler) error(err error) {
-	log.Printf("Sampler Error: %v\n", err)
+func (sm *Sampler) error(ctx context.Context, err error) {
+	slog.ErrorContext(ctx, "sampler error", "error", err)
+	upstreamErrors.Inc("sampler")
 }
 
-func (sm *Sampler) update_data(r *http.Response) {
-	data, err := io.ReadAll(r.Body)
-	r.Body.Close()
-	if err != nil {
-		sm.error(err)
-		return
-	}
+func (sm *Sampler) update_data(ctx context.Context, v []sampler.SampleData, status string) {
+	_, span := libs.StartSpan(ctx, "cache update", libs.SpanInternal)
+	defer span.End()
 
-	var v []sampler.SampleData
-	err = json.Unmarshal(data, &v)
-	if err != nil {
-		sm.error(err)
-		return
+	if status == sampler.HealthDegraded && sm.sampler_status.Get() != status {
+		slog.WarnContext(ctx, "sampler is degraded, its data are flagged as suspect")
 	}
+	sm.sampler_status.Set(status)
+	sm.updated_at.Set(time.Now())
 
 	n := len(v)
 	if n > 0 {
-		m := map[string]sampler.Status{}
-		var pmin, pmax *sampler.SampleData
-		var i int
-		for i = 0; i < n; i++ {
-			p := &v[i]
-			m[p.Address] = p.Status
-			if p.Availability {
-				pmin = p
-				pmax = p
-				break
-			}
+		sm.annotate(v)
+		m := map[string]sampler.SampleData{}
+		for i := 0; i < n; i++ {
+			m[v[i].Address] = v[i]
 		}
-
-		for ; i < n; i++ {
-			p := &v[i]
-			m[p.Address] = p.Status
-			if p.Availability {
-				if p.AccessTime > pmax.AccessTime {
-					pmax = p
-				}
-				if p.AccessTime < pmin.AccessTime {
-					pmin = p
-				}
-			}
-		}
-
 		sm.cache.SetMany(m)
+		cacheTargets.Set(float64(sm.cache.Len()))
+
+		pmin, pmax := minMax(v)
 		if pmin != nil {
 			x := new(sampler.SampleData)
 			*x = *pmin
@@ -98,22 +81,74 @@ func (sm *Sampler) update_data(r *http.Response) {
 	}
 }
 
-func (sm *Sampler) update_all() {
-	log.Printf("Sampler::update_all")
-	r, err := http.Get(sm.url_request_update_all)
+// minMax returns the fastest and the slowest available items
+func minMax(v []sampler.SampleData) (pmin, pmax *sampler.SampleData) {
+	n := len(v)
+	var i int
+	for i = 0; i < n; i++ {
+		p := &v[i]
+		if p.Availability {
+			pmin = p
+			pmax = p
+			break
+		}
+	}
+
+	for ; i < n; i++ {
+		p := &v[i]
+		if p.Availability {
+			if p.AccessTime > pmax.AccessTime {
+				pmax = p
+			}
+			if p.AccessTime < pmin.AccessTime {
+				pmin = p
+			}
+		}
+	}
+	return
+}
+
+// annotate flags failures in maintenance windows and feeds uptime and alerts,
+// failures due to a failed dependency do not fire alerts either. Suspect data
+// come from a round of a degraded sampler and are left out.
+func (sm *Sampler) annotate(v []sampler.SampleData) {
+	t := time.Now()
+	for i := range v {
+		p := &v[i]
+		if p.Suspect {
+			continue
+		}
+		if !p.Availability {
+			p.Maintenance = sm.maintenance.InMaintenance(p.Address, p.Tags, t)
+		}
+		sm.uptime.Record(p.Address, p.Status)
+		sm.alerter.Observe(p.Address, p.Availability, p.Maintenance || p.UnreachableDueToParent)
+	}
+}
+
+func (sm *Sampler) update_all(ctx context.Context) {
+	slog.DebugContext(ctx, "sampler update all")
+	ctx, span := libs.StartSpan(ctx, "sampler update all", libs.SpanInternal)
+	defer span.End()
+
+	v, status, err := sm.transport.GetAll(ctx)
 	if err != nil {
-		sm.error(err)
+		sm.error(ctx, err)
 		return
 	}
-	sm.update_data(r)
+	sm.update_data(ctx, v, status)
 }
 
-func (sm *Sampler) update_force() {
+func (sm *Sampler) update_force(ctx context.Context) {
 	m := sm.force.Clear()
 	n := len(m)
 	if n == 0 {
 		return
 	}
+	ctx, span := libs.StartSpan(ctx, "sampler update force", libs.SpanInternal)
+	defer span.End()
+	span.SetAttr("targets", n)
+
 	vaddresses := make([]string, n)
 	i := 0
 	for s := range m {
@@ -121,18 +156,23 @@ func (sm *Sampler) update_force() {
 		i++
 	}
 
-	data, err := json.Marshal(vaddresses)
+	v, status, err := sm.transport.GetMany(ctx, vaddresses)
 	if err != nil {
-		sm.error(err)
+		sm.error(ctx, err)
 		return
 	}
+	sm.update_data(ctx, v, status)
+}
 
-	r, err := http.Post(sm.url_request_query, "application/json", bytes.NewBuffer(data))
-	if err != nil {
-		sm.error(err)
-		return
-	}
-	sm.update_data(r)
+// Graph returns the dependency graph of the targets from the service Sampler.
+func (sm *Sampler) Graph(ctx context.Context) ([]sampler.GraphNode, error) {
+	return sm.transport.Graph(ctx)
+}
+
+// SamplerStatus returns the health status reported by the service Sampler
+// along with its last data.
+func (sm *Sampler) SamplerStatus() string {
+	return sm.sampler_status.Get()
 }
 
 func (sm *Sampler) Min() *sampler.SampleData {
@@ -143,48 +183,77 @@ func (sm *Sampler) Max() *sampler.SampleData {
 	return sm.max.Get()
 }
 
+// Tagged returns the cached items having all of the queried tags.
+func (sm *Sampler) Tagged(tags []string) []sampler.SampleData {
+	m := sm.cache.All()
+	v := make([]sampler.SampleData, 0, len(m))
+	for _, x := range m {
+		v = append(v, x)
+	}
+	return sampler.FilterTags(v, tags)
+}
+
+// MinTagged returns the fastest item having all of the queried tags.
+func (sm *Sampler) MinTagged(tags []string) *sampler.SampleData {
+	pmin, _ := minMax(sm.Tagged(tags))
+	return pmin
+}
+
+// MaxTagged returns the slowest item having all of the queried tags.
+func (sm *Sampler) MaxTagged(tags []string) *sampler.SampleData {
+	_, pmax := minMax(sm.Tagged(tags))
+	return pmax
+}
+
+// Stop stops the update loops, the in-flight requests are cancelled.
 func (sm *Sampler) Stop() {
-	if sm.running.Load() {
-		sm.running.Store(false)
-		sm.update_evt <- false
-		sm.force_evt <- false
+	sm.run_mtx.Lock()
+	defer sm.run_mtx.Unlock()
+	sm.stop()
+}
+
+func (sm *Sampler) stop() {
+	if sm.cancel != nil {
+		sm.cancel()
 		sm.wg.Wait()
+		sm.cancel = nil
 	}
 }
 
 func (sm *Sampler) Run() {
-	sm.Stop()
+	sm.run_mtx.Lock()
+	defer sm.run_mtx.Unlock()
+	sm.stop()
 
-	sm.running.Store(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	sm.cancel = cancel
 
 	sm.wg.Add(1)
 	go func(sm *Sampler) {
-		for sm.running.Load() {
+		defer sm.wg.Done()
+		for ctx.Err() == nil {
 			t := time.Now()
-			sm.update_all()
+			sm.update_all(ctx)
 			dt := time.Since(t)
 			if dt < sm.period {
 				select {
-				case <-sm.update_evt:
-					break
+				case <-ctx.Done():
 				case <-time.After(sm.period - dt):
 				}
 			}
 		}
-		sm.wg.Done()
 	}(sm)
 
 	sm.wg.Add(1)
 	go func(sm *Sampler) {
-		for sm.running.Load() {
-			sm.update_force()
+		defer sm.wg.Done()
+		for ctx.Err() == nil {
+			sm.update_force(ctx)
 			select {
-			case <-sm.force_evt:
-				break
+			case <-ctx.Done():
 			case <-time.After(time.Second):
 			}
 		}
-		sm.wg.Done()
 	}(sm)
 }
 
@@ -193,13 +262,62 @@ func (sm *Sampler) ForceUpdate(target_address string) {
 }
 
 func (sm *Sampler) Query(targets []string) map[string]sampler.Status {
-	return sm.cache.GetMany(targets)
+	return sm.QueryTags(targets, nil)
+}
+
+// QueryTags returns the status of the targets having all of the queried tags,
+// all cached targets are candidates when no target is given.
+func (sm *Sampler) QueryTags(targets []string, tags []string) map[string]sampler.Status {
+	var m map[string]sampler.SampleData
+	if len(targets) == 0 {
+		m = sm.cache.All()
+	} else {
+		m = sm.cache.GetMany(targets)
+	}
+
+	rs := make(map[string]sampler.Status, len(m))
+	for k, x := range m {
+		if sampler.HasTags(x.Tags, tags) {
+			rs[k] = x.Status
+		}
+	}
+	return rs
+}
+
+func (sm *Sampler) Uptime(targets []string) map[string]Uptime {
+	return sm.uptime.GetMany(targets)
+}
+
+func (sm *Sampler) Maintenance() *MaintenanceManager {
+	return &sm.maintenance
+}
+
+func (sm *Sampler) Alerter() *Alerter {
+	return &sm.alerter
+}
+
+// SetClient sets the client calling the service Sampler, ex: with an API key.
+func (sm *Sampler) SetClient(client *libs.ServiceClient) {
+	sm.client = client
+}
+
+// SetTransport sets the transport calling the service Sampler, the JSON API
+// is used by default.
+func (sm *Sampler) SetTransport(transport SamplerTransport) {
+	sm.transport = transport
+}
+
+// Transport returns the transport calling the service Sampler.
+func (sm *Sampler) Transport() SamplerTransport {
+	return sm.transport
 }
 
 func NewSampler(service_address string, period time.Duration) *Sampler {
 	sm := new(Sampler)
 	sm.period = period
 	sm.service_address = service_address
+	sm.client = &libs.ServiceClient{Client: http.DefaultClient, Breaker: libs.NewBreaker(0, 0)}
+	sm.transport = httpSampler{sm: sm}
 	sm.Init()
 	return sm
 }
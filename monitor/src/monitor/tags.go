@@ -0,0 +1,69 @@
+package monitor
+
+import (
+	"scraper/libs"
+	"strings"
+)
+
+// TagSummary aggregates the availability of the targets sharing a tag.
+type TagSummary struct {
+	Targets      int     `json:"targets"`
+	Available    int     `json:"available"`
+	Maintenance  int     `json:"maintenance"`
+	Availability float64 `json:"availability"` // ratio of the currently available targets
+	Uptime       float64 `json:"uptime"`       // ratio of the up samples of all targets
+}
+
+// TagSummaries returns the summary of every tag of the cached targets, a
+// target tagged "region=cn" is counted both in "region=cn" and in "region".
+func (sm *Sampler) TagSummaries() map[string]TagSummary {
+	v := sm.Tagged(nil)
+	addresses := make([]string, len(v))
+	for i := range v {
+		addresses[i] = v[i].Address
+	}
+	uptimes := sm.uptime.GetMany(addresses)
+
+	type acc struct {
+		TagSummary
+		up, down int64
+	}
+	m := map[string]*acc{}
+	for i := range v {
+		p := &v[i]
+		var keys []string
+		for _, t := range p.Tags {
+			keys = append(keys, t)
+			if k, _, ok := strings.Cut(t, "="); ok {
+				keys = append(keys, k)
+			}
+		}
+
+		u := uptimes[p.Address]
+		for _, k := range libs.Unique(keys) {
+			x, ok := m[k]
+			if !ok {
+				x = new(acc)
+				m[k] = x
+			}
+			x.Targets++
+			if p.Availability {
+				x.Available++
+			} else if p.Maintenance {
+				x.Maintenance++
+			}
+			x.up += u.Up
+			x.down += u.Down
+		}
+	}
+
+	rs := make(map[string]TagSummary, len(m))
+	for k, x := range m {
+		x.Availability = float64(x.Available) / float64(x.Targets)
+		if x.up+x.down > 0 {
+			x.Uptime = float64(x.up) / float64(x.up+x.down)
+		}
+		rs[k] = x.TagSummary
+	}
+	return rs
+}
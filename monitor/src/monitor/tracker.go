@@ -1,82 +1,102 @@
 package monitor
 
 import (
-	"bytes"
-	"encoding/json"
-	"io"
-	"log"
+	"context"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
-	"strconv"
+	"scraper/libs"
 	"sync"
-	"sync/atomic"
 	"time"
 )
 
+const flushTimeout = 10 * time.Second
+
 type Tracker struct {
 	service_address string
+	client          *libs.ServiceClient
+	transport       TrackerTransport
 	proxy           *httputil.ReverseProxy
 	period          time.Duration
 	counter_man     CounterManager
+	users           SafeStringMap[cachedUser] // key hash -> user
+	user_ttl        time.Duration
+	audit_mtx       sync.Mutex
+	audit           []AuditEntry
 	wg              sync.WaitGroup
-	running         atomic.Bool
-	evt             chan bool
+	run_mtx         sync.Mutex
+	cancel          context.CancelFunc
 }
 
-func (tk *Tracker) error(err error) {
-	log.Printf("Tracker Error: %v\n", err)
+func (tk *Tracker) error(ctx context.Context, err error) {
+	slog.ErrorContext(ctx, "tracker error", "error", err)
+	upstreamErrors.Inc("tracker")
 }
 
-func (tk *Tracker) update() {
+func (tk *Tracker) update(ctx context.Context) {
+	ctx, span := libs.StartSpan(ctx, "tracker update", libs.SpanInternal)
+	defer span.End()
+
+	tk.flushAudit(ctx)
+
 	m := tk.counter_man.ChangedInfo()
 	n := len(m)
-	log.Printf("Tracker::update %d item changed", n)
+	slog.DebugContext(ctx, "tracker update", "changed", n)
 	if n == 0 {
 		return
 	}
 
-	data, err := json.Marshal(m)
-	if err != nil {
-		tk.error(err)
-		return
-	}
-
-	r, err := http.Post(tk.service_address+"/update", "application/json", bytes.NewBuffer(data))
+	err := tk.transport.Update(ctx, m)
 	if err != nil {
-		tk.error(err)
-		return
-	}
-	if r.StatusCode != 200 {
-		log.Printf("Tracker update returns code %d", r.StatusCode)
+		tk.error(ctx, err)
 	}
 }
 
+// Stop stops the update loop and flushes the pending counters and audit
+// entries to the service Tracker.
 func (tk *Tracker) Stop() {
-	if tk.running.Load() {
-		tk.running.Store(false)
-		tk.evt <- false
-		tk.wg.Wait()
+	tk.run_mtx.Lock()
+	defer tk.run_mtx.Unlock()
+	tk.stop()
+}
+
+func (tk *Tracker) stop() {
+	if tk.cancel == nil {
+		return
 	}
+	tk.cancel()
+	tk.wg.Wait()
+	tk.cancel = nil
+
+	slog.Info("tracker flush pending data")
+	ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancel()
+	tk.update(ctx)
 }
 
 func (tk *Tracker) Run() {
-	tk.Stop()
+	tk.run_mtx.Lock()
+	defer tk.run_mtx.Unlock()
+	tk.stop()
 
-	log.Printf("Tracker::Run period = %v", tk.period)
+	slog.Info("tracker run", "period", tk.period.String())
 
-	tk.running.Store(true)
+	// the context only interrupts the wait, an update in progress is completed
+	// so that no counter is lost
+	ctx, cancel := context.WithCancel(context.Background())
+	tk.cancel = cancel
 	tk.wg.Add(1)
 	go func(tk *Tracker) {
-		for tk.running.Load() {
-			tk.update()
+		defer tk.wg.Done()
+		for {
+			tk.update(context.Background())
 			select {
-			case <-tk.evt:
-				break
+			case <-ctx.Done():
+				return
 			case <-time.After(tk.period):
 			}
 		}
-		tk.wg.Done()
 	}(tk)
 }
 
@@ -84,18 +104,38 @@ func (tk *Tracker) Trigger(user_id string) {
 	tk.counter_man.Update(user_id)
 }
 
-func (tk *Tracker) parseRespond(r *http.Response) (int64, error) {
-	data, err := io.ReadAll(r.Body)
-	r.Body.Close()
-	if err != nil {
-		return 0, err
-	}
+// QueryOne returns the number of requests of the user in the time range, a
+// zero end is the current time.
+func (tk *Tracker) QueryOne(ctx context.Context, user_id string, from, to int64) (int64, error) {
+	return tk.transport.QueryOne(ctx, user_id, from, to)
+}
 
-	return strconv.ParseInt(string(data), 10, 64)
+// QueryAll returns the number of requests of all users in the time range, a
+// zero end is the current time.
+func (tk *Tracker) QueryAll(ctx context.Context, from, to int64) (int64, error) {
+	return tk.transport.QueryAll(ctx, from, to)
 }
 
 func (tk *Tracker) Forward(w http.ResponseWriter, r *http.Request) {
-	tk.proxy.ServeHTTP(w, r)
+	tk.transport.Forward(w, r)
+}
+
+// SetClient sets the client calling the service Tracker, ex: with an API key.
+// The forwarded admin queries use it as well.
+func (tk *Tracker) SetClient(client *libs.ServiceClient) {
+	tk.client = client
+	tk.proxy.Transport = client.Transport()
+}
+
+// SetTransport sets the transport calling the service Tracker, the JSON API
+// is used by default.
+func (tk *Tracker) SetTransport(transport TrackerTransport) {
+	tk.transport = transport
+}
+
+// Transport returns the transport calling the service Tracker.
+func (tk *Tracker) Transport() TrackerTransport {
+	return tk.transport
 }
 
 func NewTracker(service_address string, period time.Duration) (*Tracker, error) {
@@ -106,9 +146,22 @@ func NewTracker(service_address string, period time.Duration) (*Tracker, error)
 	}
 
 	tk.service_address = service_address
+	tk.client = &libs.ServiceClient{Client: http.DefaultClient, Breaker: libs.NewBreaker(0, 0)}
+	tk.transport = httpTracker{tk: tk}
 	tk.proxy = httputil.NewSingleHostReverseProxy(u)
+	director := tk.proxy.Director
+	tk.proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Header.Del("admin_token")
+		tk.client.Authorize(r)
+	}
+	tk.proxy.ModifyResponse = func(r *http.Response) error {
+		r.Header.Del(libs.APIVersionHeader) // already set by the monitor
+		return nil
+	}
 	tk.period = period
-	tk.evt = make(chan bool)
 	tk.counter_man.Init()
+	tk.users.Clear()
+	tk.user_ttl = time.Minute
 	return tk, nil
 }
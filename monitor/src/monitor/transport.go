@@ -0,0 +1,290 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"scraper/libs"
+	"scraper/libs/rpc"
+	"scraper/sampler/src/sampler"
+	"scraper/tracker/src/tracker"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// SamplerTransport calls the service Sampler, over HTTP, gRPC or in process.
+// The data of the targets come along with the health status of the sampler.
+type SamplerTransport interface {
+	GetAll(ctx context.Context) ([]sampler.SampleData, string, error)
+	GetMany(ctx context.Context, addresses []string) ([]sampler.SampleData, string, error)
+	Graph(ctx context.Context) ([]sampler.GraphNode, error)
+	Ping(ctx context.Context) error
+}
+
+// TrackerTransport calls the service Tracker, over HTTP, gRPC or in process.
+type TrackerTransport interface {
+	Update(ctx context.Context, counters map[string]int64) error
+	QueryOne(ctx context.Context, user_id string, from, to int64) (int64, error)
+	QueryAll(ctx context.Context, from, to int64) (int64, error)
+	// Authenticate returns the user owning the key, or ErrInvalidUserKey.
+	Authenticate(ctx context.Context, key string) (string, error)
+	Audit(ctx context.Context, entries []AuditEntry) error
+	// Forward serves the admin requests handled by the tracker, ex: the
+	// management of the users.
+	Forward(w http.ResponseWriter, r *http.Request)
+	Ping(ctx context.Context) error
+}
+
+// httpSampler calls the JSON API of the service Sampler with the client of
+// the sampler.
+type httpSampler struct {
+	sm *Sampler
+}
+
+func (t httpSampler) read(r *http.Response) ([]sampler.SampleData, string, error) {
+	data, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("sampler returns code %d: %s", r.StatusCode, data)
+	}
+
+	var v []sampler.SampleData
+	err = json.Unmarshal(data, &v)
+	return v, r.Header.Get("Sampler-Status"), err
+}
+
+func (t httpSampler) GetAll(ctx context.Context) ([]sampler.SampleData, string, error) {
+	r, err := t.sm.client.Get(ctx, t.sm.url_request_update_all)
+	if err != nil {
+		return nil, "", err
+	}
+	return t.read(r)
+}
+
+func (t httpSampler) GetMany(ctx context.Context, addresses []string) ([]sampler.SampleData, string, error) {
+	data, err := json.Marshal(addresses)
+	if err != nil {
+		return nil, "", err
+	}
+	r, err := t.sm.client.Post(ctx, t.sm.url_request_query, "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return nil, "", err
+	}
+	return t.read(r)
+}
+
+func (t httpSampler) Graph(ctx context.Context) ([]sampler.GraphNode, error) {
+	r, err := t.sm.client.Get(ctx, t.sm.service_address+"/graph")
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sampler graph returns code %d: %s", r.StatusCode, data)
+	}
+
+	var v []sampler.GraphNode
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+func (t httpSampler) Ping(ctx context.Context) error {
+	return ping(ctx, t.sm.client, t.sm.service_address)
+}
+
+// grpcSampler calls the gRPC service of the Sampler, the other calls go
+// through the embedded transport.
+type grpcSampler struct {
+	SamplerTransport
+	client rpc.SamplerClient
+}
+
+// NewGRPCSampler returns the transport fetching the data of the targets over
+// the connection, ex: from libs.ServiceClient.DialGRPC. The graph and the
+// health are fetched with the other transport.
+func NewGRPCSampler(cc grpc.ClientConnInterface, other SamplerTransport) SamplerTransport {
+	return grpcSampler{SamplerTransport: other, client: rpc.NewSamplerClient(cc)}
+}
+
+func (t grpcSampler) GetAll(ctx context.Context) ([]sampler.SampleData, string, error) {
+	x, err := t.client.GetAll(ctx, &rpc.GetAllRequest{}, libs.Idempotent)
+	if err != nil {
+		return nil, "", err
+	}
+	v, health := sampler.FromProto(x)
+	return v, health.Status, nil
+}
+
+func (t grpcSampler) GetMany(ctx context.Context, addresses []string) ([]sampler.SampleData, string, error) {
+	x, err := t.client.GetMany(ctx, &rpc.GetManyRequest{Addresses: addresses}, libs.Idempotent)
+	if err != nil {
+		return nil, "", err
+	}
+	v, health := sampler.FromProto(x)
+	return v, health.Status, nil
+}
+
+// httpTracker calls the JSON API of the service Tracker with the client of
+// the tracker.
+type httpTracker struct {
+	tk *Tracker
+}
+
+func (t httpTracker) Update(ctx context.Context, counters map[string]int64) error {
+	data, err := json.Marshal(counters)
+	if err != nil {
+		return err
+	}
+
+	r, err := t.tk.client.Post(ctx, t.tk.service_address+"/update", "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("tracker update returns code %d", r.StatusCode)
+	}
+	return nil
+}
+
+func (t httpTracker) query(ctx context.Context, path string, q url.Values) (int64, error) {
+	r, err := t.tk.client.Get(ctx, t.tk.service_address+path+"?"+q.Encode())
+	if err != nil {
+		return 0, err
+	}
+	data, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return 0, err
+	}
+	if r.StatusCode != http.StatusOK {
+		return 0, libs.ParseError(r.StatusCode, data)
+	}
+
+	if r.Header.Get(libs.APIVersionHeader) == "" {
+		return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+	var v tracker.Count
+	err = json.Unmarshal(data, &v)
+	return v.Count, err
+}
+
+func rangeValues(from, to int64) url.Values {
+	q := url.Values{}
+	q.Set("from", strconv.FormatInt(from, 10))
+	if to != 0 {
+		q.Set("to", strconv.FormatInt(to, 10))
+	}
+	return q
+}
+
+func (t httpTracker) QueryOne(ctx context.Context, user_id string, from, to int64) (int64, error) {
+	q := rangeValues(from, to)
+	q.Set("user", user_id)
+	return t.query(ctx, "/admin_query_one", q)
+}
+
+func (t httpTracker) QueryAll(ctx context.Context, from, to int64) (int64, error) {
+	return t.query(ctx, "/admin_query_all", rangeValues(from, to))
+}
+
+func (t httpTracker) Authenticate(ctx context.Context, key string) (string, error) {
+	data, err := json.Marshal(userKey{Key: key})
+	if err != nil {
+		return "", err
+	}
+	r, err := t.tk.client.Post(ctx, t.tk.service_address+"/auth", "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return "", err
+	}
+	data, err = io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return "", err
+	}
+
+	switch r.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return "", ErrInvalidUserKey
+	default:
+		return "", fmt.Errorf("tracker auth returns code %d: %w", r.StatusCode, libs.ParseError(r.StatusCode, data))
+	}
+
+	var u userKey
+	err = json.Unmarshal(data, &u)
+	return u.UserID, err
+}
+
+func (t httpTracker) Audit(ctx context.Context, entries []AuditEntry) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+
+	r, err := t.tk.client.Post(ctx, t.tk.service_address+"/audit", "application/json", bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("tracker audit returns code %d", r.StatusCode)
+	}
+	return nil
+}
+
+func (t httpTracker) Forward(w http.ResponseWriter, r *http.Request) {
+	t.tk.proxy.ServeHTTP(w, r)
+}
+
+func (t httpTracker) Ping(ctx context.Context) error {
+	return ping(ctx, t.tk.client, t.tk.service_address)
+}
+
+// grpcTracker calls the gRPC service of the Tracker, the other calls go
+// through the embedded transport.
+type grpcTracker struct {
+	TrackerTransport
+	client rpc.TrackerClient
+}
+
+// NewGRPCTracker returns the transport sending the counters and their queries
+// over the connection, ex: from libs.ServiceClient.DialGRPC. The users and
+// the audit log go through the other transport.
+func NewGRPCTracker(cc grpc.ClientConnInterface, other TrackerTransport) TrackerTransport {
+	return grpcTracker{TrackerTransport: other, client: rpc.NewTrackerClient(cc)}
+}
+
+func (t grpcTracker) Update(ctx context.Context, counters map[string]int64) error {
+	_, err := t.client.Update(ctx, &rpc.UpdateRequest{Counters: counters})
+	return err
+}
+
+func (t grpcTracker) QueryOne(ctx context.Context, user_id string, from, to int64) (int64, error) {
+	x, err := t.client.QueryOne(ctx, &rpc.QueryOneRequest{User: user_id, From: from, To: to}, libs.Idempotent)
+	if err != nil {
+		return 0, err
+	}
+	return x.GetCount(), nil
+}
+
+func (t grpcTracker) QueryAll(ctx context.Context, from, to int64) (int64, error) {
+	x, err := t.client.QueryAll(ctx, &rpc.QueryAllRequest{From: from, To: to}, libs.Idempotent)
+	if err != nil {
+		return 0, err
+	}
+	return x.GetCount(), nil
+}
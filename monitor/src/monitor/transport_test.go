@@ -0,0 +1,227 @@
+package monitor_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"scraper/libs"
+	"scraper/libs/rpc"
+	"scraper/monitor/src/monitor"
+	"scraper/sampler/src/sampler"
+	"scraper/tracker/src/tracker"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// serveGRPC serves the services registered by fn at a local address
+func serveGRPC(t *testing.T, api_key string, fn func(s *libs.GRPCServer)) string {
+	s, err := libs.NewGRPCServer(0, api_key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn(s)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(s.Stop)
+	return ln.Addr().String()
+}
+
+func dial(t *testing.T, address, api_key string) *grpc.ClientConn {
+	client, err := libs.NewServiceClient(api_key, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := client.DialGRPC(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPCSampler(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	up := ln.Addr().String()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	m := sampler.NewSamplerManager(time.Minute, time.Second, []string{up})
+	round := m.Watch(ctx)
+	m.Run()
+	defer m.Stop()
+	<-round
+
+	address := serveGRPC(t, "secret", func(s *libs.GRPCServer) {
+		rpc.RegisterSamplerServer(s, sampler.NewGRPCService(m))
+	})
+
+	tr := monitor.NewGRPCSampler(dial(t, address, "secret"), nil)
+	v, health, err := tr.GetAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 1 || v[0].Address != up || !v[0].Availability || health != sampler.HealthOK {
+		t.Errorf("unexpected data %+v, %q", v, health)
+	}
+	if v, _, err = tr.GetMany(ctx, []string{"unknown.com"}); err != nil || len(v) != 0 {
+		t.Errorf("unexpected data of unknown target %+v, %v", v, err)
+	}
+
+	x, err := rpc.NewSamplerClient(dial(t, address, "secret")).Probe(ctx, &rpc.ProbeRequest{Addresses: []string{up}})
+	if err != nil || len(x.GetSamples()) != 1 || !x.GetSamples()[0].GetStatus().GetAvailability() {
+		t.Errorf("unexpected probe %v, %v", x, err)
+	}
+
+	_, _, err = monitor.NewGRPCSampler(dial(t, address, "wrong"), nil).GetAll(ctx)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected code Unauthenticated, got %v", err)
+	}
+}
+
+func TestGRPCTracker(t *testing.T) {
+	tk := new(tracker.Tracker)
+	err := tk.Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.Close()
+
+	address := serveGRPC(t, "", func(s *libs.GRPCServer) {
+		rpc.RegisterTrackerServer(s, tracker.NewGRPCService(tk))
+	})
+
+	ctx := context.Background()
+	tr := monitor.NewGRPCTracker(dial(t, address, ""), nil)
+	err = tr.Update(ctx, map[string]int64{"alice": 3, "bob": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := time.Now().Unix() + 60
+	if n, err := tr.QueryOne(ctx, "alice", 0, to); err != nil || n != 3 {
+		t.Errorf("unexpected count of alice %d, %v", n, err)
+	}
+	if n, err := tr.QueryAll(ctx, 0, to); err != nil || n != 5 {
+		t.Errorf("unexpected count of all %d, %v", n, err)
+	}
+}
+
+// unavailableTracker fails every call as if the service was restarting
+type unavailableTracker struct {
+	rpc.UnimplementedTrackerServer
+	updates, queries atomic.Int32
+}
+
+func (s *unavailableTracker) Update(context.Context, *rpc.UpdateRequest) (*rpc.UpdateReply, error) {
+	s.updates.Add(1)
+	return nil, status.Error(codes.Unavailable, "restarting")
+}
+
+func (s *unavailableTracker) QueryAll(context.Context, *rpc.QueryAllRequest) (*rpc.Count, error) {
+	s.queries.Add(1)
+	return nil, status.Error(codes.Unavailable, "restarting")
+}
+
+func TestGRPCRetry(t *testing.T) {
+	s := new(unavailableTracker)
+	address := serveGRPC(t, "", func(gs *libs.GRPCServer) {
+		rpc.RegisterTrackerServer(gs, s)
+	})
+
+	client, err := libs.NewServiceClient("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := libs.DefaultResilience
+	opts.RetryDelay = time.Millisecond
+	opts.BreakerFailures = 0
+	client.SetResilience(opts)
+	conn, err := client.DialGRPC(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the counters are sent once so that they are not counted twice, the
+	// queries are retried
+	tr := monitor.NewGRPCTracker(conn, nil)
+	ctx := context.Background()
+	if err := tr.Update(ctx, map[string]int64{"alice": 1}); status.Code(err) != codes.Unavailable || s.updates.Load() != 1 {
+		t.Errorf("unexpected update %d times, %v", s.updates.Load(), err)
+	}
+	if _, err := tr.QueryAll(ctx, 0, 0); status.Code(err) != codes.Unavailable || s.queries.Load() != int32(opts.Retries+1) {
+		t.Errorf("unexpected query %d times, %v", s.queries.Load(), err)
+	}
+}
+
+func TestDirectSampler(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// the sampler in process is ready after its first round
+	ctx := context.Background()
+	m := sampler.NewSamplerManager(time.Minute, time.Second, []string{ln.Addr().String()})
+	tr := monitor.NewDirectSampler(m)
+	if err := tr.Ping(ctx); !errors.Is(err, sampler.ErrFirstRound) {
+		t.Errorf("expected ErrFirstRound, got %v", err)
+	}
+	m.Once(ctx)
+	if err := tr.Ping(ctx); err != nil {
+		t.Errorf("unexpected ping %v", err)
+	}
+}
+
+func TestDirectTracker(t *testing.T) {
+	tk := new(tracker.Tracker)
+	err := tk.Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.Close()
+
+	// the forwarded admin requests are served in process
+	tr := monitor.NewDirectTracker(tk)
+	w := httptest.NewRecorder()
+	tr.Forward(w, httptest.NewRequest(http.MethodPost, "/v1/admin_user_create?user=alice", nil))
+	var u struct {
+		Key string `json:"key"`
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &u)
+	if w.Code != http.StatusOK || err != nil {
+		t.Fatalf("unexpected reply %d %s", w.Code, w.Body.String())
+	}
+
+	ctx := context.Background()
+	if user_id, err := tr.Authenticate(ctx, u.Key); err != nil || user_id != "alice" {
+		t.Errorf("unexpected authentication %q, %v", user_id, err)
+	}
+	if _, err := tr.Authenticate(ctx, "wrong"); !errors.Is(err, monitor.ErrInvalidUserKey) {
+		t.Errorf("expected ErrInvalidUserKey, got %v", err)
+	}
+
+	err = tr.Update(ctx, map[string]int64{"alice": 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := tr.QueryOne(ctx, "alice", 0, time.Now().Unix()+60); err != nil || n != 4 {
+		t.Errorf("unexpected count of alice %d, %v", n, err)
+	}
+}
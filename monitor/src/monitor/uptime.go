@@ -0,0 +1,73 @@
+package monitor
+
+import (
+	"scraper/sampler/src/sampler"
+	"sync"
+)
+
+// Uptime counts the samples of a target, failures during maintenance and
+// failures due to a failed dependency are counted apart and do not take part
+// in the ratio.
+type Uptime struct {
+	Up          int64   `json:"up"`
+	Down        int64   `json:"down"`
+	Maintenance int64   `json:"maintenance"`
+	Unreachable int64   `json:"unreachable_due_to_parent"`
+	Ratio       float64 `json:"ratio"`
+}
+
+func (u *Uptime) ratio() {
+	n := u.Up + u.Down
+	if n > 0 {
+		u.Ratio = float64(u.Up) / float64(n)
+	}
+}
+
+type UptimeManager struct {
+	mtx  sync.RWMutex
+	data map[string]*Uptime
+}
+
+func (um *UptimeManager) Init() {
+	um.mtx.Lock()
+	defer um.mtx.Unlock()
+	um.data = make(map[string]*Uptime)
+}
+
+func (um *UptimeManager) get(address string) *Uptime {
+	p, ok := um.data[address]
+	if !ok {
+		p = new(Uptime)
+		um.data[address] = p
+	}
+	return p
+}
+
+func (um *UptimeManager) Record(address string, st sampler.Status) {
+	um.mtx.Lock()
+	defer um.mtx.Unlock()
+	p := um.get(address)
+	switch {
+	case st.Availability:
+		p.Up++
+	case st.Maintenance:
+		p.Maintenance++
+	case st.UnreachableDueToParent:
+		p.Unreachable++
+	default:
+		p.Down++
+	}
+	p.ratio()
+}
+
+func (um *UptimeManager) GetMany(addresses []string) map[string]Uptime {
+	um.mtx.RLock()
+	defer um.mtx.RUnlock()
+	m := map[string]Uptime{}
+	for _, s := range addresses {
+		if p, ok := um.data[s]; ok {
+			m[s] = *p
+		}
+	}
+	return m
+}
@@ -0,0 +1,54 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"scraper/tracker/src/tracker"
+	"time"
+)
+
+var ErrInvalidUserKey = errors.New("invalid user key")
+
+type cachedUser struct {
+	user_id string
+	expires time.Time
+}
+
+type userKey struct {
+	UserID string `json:"user_id,omitempty"`
+	Key    string `json:"key,omitempty"`
+}
+
+// Authenticate returns the id of the user owning the API key. Keys are
+// verified by the service Tracker, valid keys are cached for a while.
+func (tk *Tracker) Authenticate(ctx context.Context, key string) (string, error) {
+	if key == "" {
+		return "", ErrInvalidUserKey
+	}
+
+	h := tracker.HashKey(key)
+	p, ok := tk.users.Get(h)
+	if ok && time.Now().Before(p.expires) {
+		return p.user_id, nil
+	}
+
+	user_id, err := tk.transport.Authenticate(ctx, key)
+	if errors.Is(err, ErrInvalidUserKey) {
+		tk.users.DeleteIf(func(k string, _ cachedUser) bool { return k == h })
+	}
+	if err != nil {
+		return "", err
+	}
+	tk.users.Set(h, cachedUser{user_id: user_id, expires: time.Now().Add(tk.user_ttl)})
+	return user_id, nil
+}
+
+// InvalidateUser drops the cached keys of the user, ex: after a rotation or a revocation.
+func (tk *Tracker) InvalidateUser(user_id string) {
+	tk.users.DeleteIf(func(_ string, p cachedUser) bool { return p.user_id == user_id })
+}
+
+// SetUserCacheTTL sets how long a verified key is trusted without asking the service Tracker.
+func (tk *Tracker) SetUserCacheTTL(ttl time.Duration) {
+	tk.user_ttl = ttl
+}
@@ -10,6 +10,9 @@ fi
 tracker_port=$(($monitor_port + 1))
 sampler_port=$(($monitor_port + 2))
 
-./tracker -p="$tracker_port" &>> tracker.log &
-./sampler -p="$sampler_port" &>> sampler.log &
-./monitor -p="$monitor_port" -a="admin_token_example" -s="http://localhost:$sampler_port" -t="http://localhost:$tracker_port" &>> monitor.log &
\ No newline at end of file
+export SCRAPER_MONITOR_PORT="$monitor_port" SCRAPER_TRACKER_PORT="$tracker_port" SCRAPER_SAMPLER_PORT="$sampler_port"
+export SCRAPER_MONITOR_SAMPLER="http://localhost:$sampler_port" SCRAPER_MONITOR_TRACKER="http://localhost:$tracker_port"
+
+./tracker &>> tracker.log &
+./sampler &>> sampler.log &
+./monitor -a="admin_token_example" &>> monitor.log &
\ No newline at end of file
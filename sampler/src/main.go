@@ -1,67 +1,190 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"scraper/libs"
+	"scraper/libs/middleware"
+	"scraper/libs/rpc"
 	"scraper/sampler/src/sampler"
 	"strings"
 	"time"
-
-	"github.com/alexflint/go-arg"
 )
 
 type appArgs struct {
-	Port      int    `arg:"-p,--port" default:"8092" help:"the server listening port."`
-	SitesFile string `arg:"-f,--file" default:"sites.txt" help:"the file contains list of address"`
-	Period    int    `arg:"--period" default:"300" help:"sampling period in second"`
-	Timeout   int    `arg:"--timeout" default:"60" help:"sampling timeout in second"`
-	APIKey    string `arg:"-k,--key" default:"" help:"the API key to access this service"`
+	Port            int        `arg:"-p,--port" default:"8092" help:"the server listening port."`
+	SitesFile       string     `arg:"-f,--file" default:"sites.txt" help:"the file contains list of address"`
+	Period          int        `arg:"--period" default:"300" help:"sampling period in second"`
+	Timeout         int        `arg:"--timeout" default:"60" help:"sampling timeout in second"`
+	APIKey          string     `arg:"-k,--key" default:"" secret:"true" help:"the API key to access this service"`
+	GRPCPort        int        `arg:"--grpc_port" default:"0" help:"the port serving gRPC for the internal traffic, 0 to disable"`
+	Canaries        []string   `arg:"--canary,separate" help:"the address checked before each round to verify the sampler's own network, ex: 192.168.1.1:53"`
+	MaxFailed       float64    `arg:"--max_failed" default:"0" help:"the ratio of failed targets above which a round is suspect, 0 to disable"`
+	Exports         []string   `arg:"--export,separate" secret:"true" help:"the URL of a backend the probe results are pushed to, ex: influx+http://localhost:8086/write?db=probes, graphite://localhost:2003, statsd://localhost:8125"`
+	TLSCert         string     `arg:"--tls_cert" default:"" help:"the certificate file to serve HTTPS, reloaded when it changes"`
+	TLSKey          string     `arg:"--tls_key" default:"" help:"the key file of the certificate"`
+	ClientCA        string     `arg:"--client_ca" default:"" help:"the CA file verifying client certificates, enables mutual TLS"`
+	TLSMinVersion   string     `arg:"--tls_min_version" default:"1.2" help:"the minimum TLS version: 1.2 or 1.3"`
+	MaxBodySize     int64      `arg:"--max_body" default:"1048576" help:"the maximum size in byte of a request body, 0 for no limit"`
+	RequestTimeout  int        `arg:"--request_timeout" default:"60" help:"the time in second to handle a request, 0 for no limit"`
+	CORSOrigins     []string   `arg:"--cors_origin,separate" help:"an origin allowed to call this service from a browser, * for any"`
+	ShutdownTimeout int        `arg:"--shutdown_timeout" default:"10" help:"the time in second to drain in-flight requests on shutdown"`
+	LogLevel        string     `arg:"--log_level" default:"info" help:"the minimum level of the JSON logs: debug, info, warn or error"`
+	TraceOTLP       string     `arg:"--trace_otlp" default:"" help:"the OTLP/HTTP traces endpoint of the collector, ex: http://localhost:4318/v1/traces"`
+	TraceFile       string     `arg:"--trace_file" default:"" help:"the file the spans are appended to in OTLP JSON, for local testing"`
+	Probe           *probeArgs `arg:"subcommand:probe" help:"run a single round of sampling, print the results and exit, 1 if a target is down"`
+	libs.ConfigArgs
+}
+
+// Validate checks the arguments once loaded from the configuration
+func (a *appArgs) Validate() error {
+	return errors.Join(
+		libs.CheckRange("port", int64(a.Port), 1, 65535),
+		libs.CheckRange("grpc_port", int64(a.GRPCPort), 0, 65535),
+		libs.CheckMin("period", int64(a.Period), 1),
+		libs.CheckMin("timeout", int64(a.Timeout), 1),
+		libs.CheckMin("max_body", a.MaxBodySize, 0),
+		libs.CheckMin("request_timeout", int64(a.RequestTimeout), 0),
+		libs.CheckMin("shutdown_timeout", int64(a.ShutdownTimeout), 0),
+		libs.CheckOneOf("tls_min_version", a.TLSMinVersion, "1.2", "1.3"),
+		libs.CheckOneOf("log_level", strings.ToLower(a.LogLevel), "debug", "info", "warn", "error"),
+		checkRatio("max_failed", a.MaxFailed),
+	)
 }
 
+// checkRatio returns libs.ErrInvalidConfig when the ratio is out of [0, 1]
+func checkRatio(name string, v float64) error {
+	if v < 0 || v > 1 {
+		return fmt.Errorf("%w: %s must be in [0, 1], got %v", libs.ErrInvalidConfig, name, v)
+	}
+	return nil
+}
+
+// probeArgs are the arguments of the one-shot mode, ex: to check the targets
+// after a deploy
+type probeArgs struct {
+	Output string   `arg:"-o,--output" default:"table" help:"the output format: table or json"`
+	JUnit  string   `arg:"--junit" default:"" help:"the file the JUnit XML report is written to"`
+	Tags   []string `arg:"--tag,separate" help:"only the targets having the tag fail the probe, all the targets are reported"`
+}
+
+// the exit codes of the one-shot mode
+const (
+	exitDown  = 1 // a target is down
+	exitError = 2 // the round is suspect or the report fails
+)
+
+var ErrOutput = errors.New("unknown output format")
+
 var (
-	sm          *sampler.Manager
-	checkAPIKey libs.CheckAPIKeyFn
+	server          *libs.Server
+	grpcServer      *libs.GRPCServer
+	shutdownTimeout time.Duration
+	sm              *sampler.Manager
+	mux             = http.NewServeMux()
+	httpMetrics     = libs.NewHTTPMetrics(libs.DefaultRegistry, "scraper_sampler")
 )
 
 func startup() {
 	var err error
 	var a appArgs
-	arg.MustParse(&a)
+	libs.MustLoadConfig("sampler", &a)
+	err = libs.InitLogger(a.LogLevel)
+	if err != nil {
+		panic(err)
+	}
+
+	exporters, err := libs.NewSpanExporters(a.TraceOTLP, a.TraceFile)
+	if err != nil {
+		panic(err)
+	}
+	libs.DefaultTracer.Init("sampler", exporters...)
 
 	data, err := os.ReadFile(a.SitesFile)
 	if err != nil {
 		panic(err)
 	}
 
-	v := strings.Split(string(data), "\n")
-	n := len(v)
-	var vaddress []string
-	for i := 0; i < n; i++ {
-		s := strings.TrimSpace(v[i])
-		if len(s) > 0 {
-			vaddress = append(vaddress, s)
-		}
+	sites, err := sampler.ParseSites(string(data))
+	if err != nil {
+		panic(err)
 	}
 
-	checkAPIKey = libs.MakeCheckAPIKey(a.APIKey)
+	sm, err = sampler.NewSamplerManagerFromSites(time.Second*time.Duration(a.Period), time.Second*time.Duration(a.Timeout), sites)
+	if err != nil {
+		panic(err)
+	}
+	sm.SetSelfCheck(a.Canaries, a.MaxFailed)
+	if a.Probe != nil {
+		code := probe(a.Probe)
+		libs.DefaultTracer.Shutdown(5 * time.Second)
+		os.Exit(code)
+	}
+	for _, s := range a.Exports {
+		ex, err := sampler.ParseExport(s)
+		if err != nil {
+			panic(err)
+		}
+		slog.Info("export probe results", "exporter", ex.Name())
+		sm.AddExport(ex)
+	}
 
-	sm = sampler.NewSamplerManager(time.Second*time.Duration(a.Period), time.Second*time.Duration(a.Timeout), vaddress)
+	api_key := middleware.APIKey(a.APIKey)
+	handle("/query", query, api_key)
+	handle("/one", one, api_key)
+	handle("/all", all, api_key)
+	handle("/graph", graph, api_key)
+	handle("/status", status, api_key)
+	mux.HandleFunc("/metrics", libs.MetricsHandler)
+	mux.HandleFunc(libs.APIPrefix+"/", libs.NotFound)
+	handle("/healthz", libs.Healthz)
+	handle("/readyz", libs.MakeReadyz(time.Second, map[string]libs.ReadyCheck{
+		"first_round": func(ctx context.Context) (interface{}, error) {
+			return sm.Health(), sm.Ready()
+		},
+	}))
 
-	http.HandleFunc("/query", query)
-	http.HandleFunc("/one", one)
-	http.HandleFunc("/all", all)
+	var tls_opts *libs.TLSOptions
+	if a.TLSCert != "" {
+		tls_opts = &libs.TLSOptions{CertFile: a.TLSCert, KeyFile: a.TLSKey, CAFile: a.ClientCA, MinVersion: a.TLSMinVersion}
+	}
+	handler := middleware.Service(mux, middleware.Options{
+		MaxBodySize: a.MaxBodySize,
+		Timeout:     time.Duration(a.RequestTimeout) * time.Second,
+		CORSOrigins: a.CORSOrigins,
+	})
+	server, err = libs.NewServer(a.Port, handler, tls_opts)
+	if err != nil {
+		panic(err)
+	}
+	server.Start()
 
-	go libs.Serve(a.Port)
+	if a.GRPCPort > 0 {
+		grpcServer, err = libs.NewGRPCServer(a.GRPCPort, a.APIKey, tls_opts)
+		if err != nil {
+			panic(err)
+		}
+		rpc.RegisterSamplerServer(grpcServer, sampler.NewGRPCService(sm))
+		grpcServer.Start()
+	}
+	shutdownTimeout = time.Duration(a.ShutdownTimeout) * time.Second
 }
 
-func query(w http.ResponseWriter, r *http.Request) {
-	if !checkAPIKey(w, r) {
-		return
+// handle registers the handler under the pattern and its versioned one,
+// wrapped with the middlewares, their requests and latencies are exported
+// under their pattern
+func handle(pattern string, fn http.HandlerFunc, mws ...middleware.Middleware) {
+	for _, p := range []string{pattern, libs.APIPrefix + pattern} {
+		mux.Handle(p, middleware.Chain(fn, append([]middleware.Middleware{middleware.Metrics(httpMetrics, p)}, mws...)...))
 	}
+}
 
+func query(w http.ResponseWriter, r *http.Request) {
 	var address []string
 
 	err := libs.JSONParse(r, &address)
@@ -71,42 +194,114 @@ func query(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := sm.GetMany(address)
+	setHealthHeader(w)
 	err = libs.JSONReply(w, &data)
 	if err != nil {
-		log.Print(err)
+		slog.ErrorContext(r.Context(), "reply", "error", err)
 	}
 }
 
 func one(w http.ResponseWriter, r *http.Request) {
-	if !checkAPIKey(w, r) {
-		return
-	}
-
 	address := r.URL.Query().Get("address")
 	p := sm.GetOne(address)
 	err := libs.JSONReply(w, p)
 	if err != nil {
-		log.Print(err)
+		slog.ErrorContext(r.Context(), "reply", "error", err)
 	}
 }
 
 func all(w http.ResponseWriter, r *http.Request) {
-	if !checkAPIKey(w, r) {
-		return
+	setHealthHeader(w)
+	tags := r.URL.Query()["tag"]
+	err := libs.JSONReply(w, sampler.FilterTags(sm.GetAll(), tags))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "reply", "error", err)
+	}
+}
+
+// setHealthHeader reports a degraded sampler along with its data
+func setHealthHeader(w http.ResponseWriter) {
+	w.Header().Set("Sampler-Status", sm.Health().Status)
+}
+
+func status(w http.ResponseWriter, r *http.Request) {
+	err := libs.JSONReply(w, sm.Health())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "reply", "error", err)
 	}
+}
 
-	err := libs.JSONReply(w, sm.GetAll())
+func graph(w http.ResponseWriter, r *http.Request) {
+	err := libs.JSONReply(w, sm.Graph())
 	if err != nil {
-		log.Print(err)
+		slog.ErrorContext(r.Context(), "reply", "error", err)
 	}
 }
 
+// probe runs a single round and reports its results, it returns the exit code
+func probe(a *probeArgs) int {
+	if a.Output != "table" && a.Output != "json" {
+		fmt.Fprintln(os.Stderr, "error:", ErrOutput)
+		return exitError
+	}
+
+	t := time.Now()
+	health := sm.Once(context.Background())
+	elapsed := time.Since(t)
+	v := sm.GetAll()
+
+	var err error
+	if a.Output == "json" {
+		err = json.NewEncoder(os.Stdout).Encode(v)
+	} else {
+		err = sampler.WriteTable(os.Stdout, v)
+	}
+	if err == nil && a.JUnit != "" {
+		err = writeJUnit(a.JUnit, v, a.Tags, elapsed)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return exitError
+	}
+
+	if health.Status != sampler.HealthOK {
+		fmt.Fprintln(os.Stderr, "error: suspect round,", health.Reason)
+		return exitError
+	}
+	if down := sampler.Down(v, a.Tags); len(down) > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d targets down\n", len(down), len(v))
+		return exitDown
+	}
+	return 0
+}
+
+func writeJUnit(filename string, v []sampler.SampleData, tags []string, elapsed time.Duration) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = sampler.WriteJUnit(f, "sampler", v, tags, elapsed)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 func exec() {
 	sm.Run()
 
 	libs.WaitCtrlC()
 
-	sm.Stop()
+	if grpcServer != nil {
+		grpcServer.Shutdown(shutdownTimeout)
+	}
+	err := server.Shutdown(shutdownTimeout)
+	if err != nil {
+		slog.Error("shutdown server", "error", err)
+	}
+	sm.Stop() // cancels the in-flight probes
+	libs.DefaultTracer.Shutdown(5 * time.Second)
 	fmt.Println("bye bye!")
 }
 
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"scraper/libs"
+	"strings"
+	"testing"
+)
+
+func TestEffectiveConfigRedacted(t *testing.T) {
+	var a appArgs
+	environ := map[string]string{"SCRAPER_SAMPLER_KEY": "topsecret"}
+	_, err := libs.LoadConfig("sampler", &a, []string{
+		"--export", "influx+http://probes:pw@localhost:8086/write?db=probes&p=pw",
+		"--trace_otlp", "http://collector:pw@localhost:4318/v1/traces?token=pw",
+	}, func(k string) (string, bool) {
+		v, ok := environ[k]
+		return v, ok
+	})
+	if err != nil || a.APIKey != "topsecret" {
+		t.Fatalf("unexpected arguments %+v, %v", a, err)
+	}
+
+	var b strings.Builder
+	err = libs.WriteConfig(&b, "sampler", &a)
+	if err != nil || strings.Contains(b.String(), "topsecret") || strings.Contains(b.String(), "pw") {
+		t.Errorf("secrets in the effective configuration %q, %v", b.String(), err)
+	}
+	if m := libs.EffectiveConfig(&a); m["trace_otlp"] != "http://[redacted]@localhost:4318/v1/traces?token=[redacted]" {
+		t.Errorf("unexpected trace_otlp %v", m["trace_otlp"])
+	}
+}
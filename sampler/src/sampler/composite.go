@@ -0,0 +1,147 @@
+package sampler
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidComposite = errors.New("invalid composite target")
+)
+
+// CompositeTarget is a service built from several member targets, it is
+// available when all, any or at least K of its members are available.
+type CompositeTarget struct {
+	Name      string
+	K         int // number of members required to be available
+	Members   []string
+	Tags      []string
+	DependsOn []string
+}
+
+// Mode returns the textual form of the rule: "all", "any" or "<k>of".
+func (ct *CompositeTarget) Mode() string {
+	switch ct.K {
+	case len(ct.Members):
+		return "all"
+	case 1:
+		return "any"
+	}
+	return strconv.Itoa(ct.K) + "of"
+}
+
+// ex: tmall = 2of(login.tmall.com, api.tmall.com, cdn.tmall.com) team=retail
+var compositeLine = regexp.MustCompile(`^(\S+)\s*=\s*(all|any|\d+of)\((.*)\)(.*)$`)
+
+func parseComposite(s string) (*CompositeTarget, bool, error) {
+	v := compositeLine.FindStringSubmatch(s)
+	if v == nil {
+		return nil, false, nil
+	}
+
+	ct := &CompositeTarget{Name: v[1]}
+	ct.Tags, ct.DependsOn = parseAttributes(strings.Fields(v[4]))
+	for _, m := range strings.Split(v[3], ",") {
+		m = strings.TrimSpace(m)
+		if len(m) > 0 {
+			ct.Members = append(ct.Members, m)
+		}
+	}
+	ct.Members = uniqueSorted(ct.Members)
+
+	n := len(ct.Members)
+	if n == 0 {
+		return nil, true, fmt.Errorf("%w %s: no member", ErrInvalidComposite, ct.Name)
+	}
+	switch mode := v[2]; mode {
+	case "all":
+		ct.K = n
+	case "any":
+		ct.K = 1
+	default:
+		k, _ := strconv.Atoi(strings.TrimSuffix(mode, "of"))
+		if k < 1 || k > n {
+			return nil, true, fmt.Errorf("%w %s: %s of %d members", ErrInvalidComposite, ct.Name, mode, n)
+		}
+		ct.K = k
+	}
+	return ct, true, nil
+}
+
+func uniqueSorted(v []string) []string {
+	m := map[string]bool{}
+	rs := make([]string, 0, len(v))
+	for _, s := range v {
+		if !m[s] {
+			m[s] = true
+			rs = append(rs, s)
+		}
+	}
+	sort.Strings(rs)
+	return rs
+}
+
+// Composite computes the status of a composite target from its members.
+type Composite struct {
+	def     CompositeTarget
+	members []*Sampler
+	data    SampleData
+	mtx     sync.RWMutex
+}
+
+// Update evaluates the rule over the current data of the members. The access
+// time is the one of the K-th fastest available member, so the slowest one
+// for "all" and the fastest one for "any".
+func (cp *Composite) Update() {
+	var times []time.Duration
+	suspect := false
+	for _, p := range cp.members {
+		x := p.CurrentData()
+		if x.Availability {
+			times = append(times, x.AccessTime)
+		}
+		suspect = suspect || x.Suspect
+	}
+
+	cp.mtx.Lock()
+	defer cp.mtx.Unlock()
+	cp.data.Suspect = suspect
+	if len(times) < cp.def.K {
+		cp.data.Availability = false
+		return
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	cp.data.Availability = true
+	cp.data.AccessTime = times[cp.def.K-1]
+}
+
+func (cp *Composite) CurrentData() SampleData {
+	cp.mtx.RLock()
+	defer cp.mtx.RUnlock()
+	return cp.data
+}
+
+func (cp *Composite) setUnreachable(value bool) {
+	cp.mtx.Lock()
+	defer cp.mtx.Unlock()
+	cp.data.UnreachableDueToParent = value
+}
+
+func newComposite(def CompositeTarget, members []*Sampler) *Composite {
+	return &Composite{
+		def:     def,
+		members: members,
+		data: SampleData{
+			Address:   def.Name,
+			Tags:      def.Tags,
+			Composite: def.Mode(),
+			Members:   def.Members,
+		},
+	}
+}
@@ -0,0 +1,300 @@
+package sampler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidExport = errors.New("invalid export")
+)
+
+// exportStopTimeout bounds the final flush of the exports on stop.
+const exportStopTimeout = 5 * time.Second
+
+// exportTimeout is the default bound of a periodic flush and of a write.
+const exportTimeout = 10 * time.Second
+
+// Point is the result of a probe pushed to the time-series backends.
+type Point struct {
+	Target     string
+	Tags       []string
+	Available  bool
+	AccessTime time.Duration
+	Suspect    bool // the round was suspect, its results are not applied
+	Time       time.Time
+}
+
+// Exporter writes a batch of points to a time-series backend.
+type Exporter interface {
+	Name() string
+	Write(ctx context.Context, points []Point) error
+}
+
+// ExportOptions configures the buffering of an export.
+type ExportOptions struct {
+	BatchSize     int           // the maximum number of points written at once
+	BufferSize    int           // the oldest points are dropped above it
+	FlushInterval time.Duration // the pending points are written at least this often
+	FlushTimeout  time.Duration // bounds a periodic flush, a hung backend does not block the loop
+}
+
+func (opts *ExportOptions) setDefaults() {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.BufferSize < opts.BatchSize {
+		opts.BufferSize = 20 * opts.BatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 10 * time.Second
+	}
+	if opts.FlushTimeout <= 0 {
+		opts.FlushTimeout = exportTimeout
+	}
+}
+
+// Export buffers the points and writes them in batches to an exporter. The
+// points of a failed write are kept for the next flush.
+type Export struct {
+	exporter Exporter
+	opts     ExportOptions
+	buf      []Point
+	mtx      sync.Mutex
+	wake     chan struct{}
+	wg       sync.WaitGroup
+	run_mtx  sync.Mutex
+	cancel   context.CancelFunc
+	metrics  *metrics // the ones of the manager once added
+}
+
+func NewExport(exporter Exporter, opts ExportOptions) *Export {
+	opts.setDefaults()
+	return &Export{
+		exporter: exporter,
+		opts:     opts,
+		wake:     make(chan struct{}, 1),
+		metrics:  unregistered,
+	}
+}
+
+func (ex *Export) Name() string {
+	return ex.exporter.Name()
+}
+
+// Push queues the points, a batch is written as soon as it is full.
+func (ex *Export) Push(points ...Point) {
+	ex.mtx.Lock()
+	ex.buf = append(ex.buf, points...)
+	ex.dropOverflow()
+	full := len(ex.buf) >= ex.opts.BatchSize
+	ex.mtx.Unlock()
+
+	if full {
+		select {
+		case ex.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// dropOverflow drops the oldest points above the buffer size, the mutex must
+// be held.
+func (ex *Export) dropOverflow() {
+	if n := len(ex.buf) - ex.opts.BufferSize; n > 0 {
+		slog.Warn("export buffer full, points dropped", "exporter", ex.Name(), "points", n)
+		ex.metrics.exportPoints.Add(float64(n), ex.Name(), "dropped")
+		ex.buf = append([]Point(nil), ex.buf[n:]...)
+	}
+}
+
+// Pending returns the number of buffered points.
+func (ex *Export) Pending() int {
+	ex.mtx.Lock()
+	defer ex.mtx.Unlock()
+	return len(ex.buf)
+}
+
+// Flush writes the buffered points batch by batch, it stops at the first
+// failed batch which is put back in front of the buffer.
+func (ex *Export) Flush(ctx context.Context) error {
+	for {
+		ex.mtx.Lock()
+		n := len(ex.buf)
+		if n > ex.opts.BatchSize {
+			n = ex.opts.BatchSize
+		}
+		batch := ex.buf[:n:n]
+		ex.buf = ex.buf[n:]
+		ex.mtx.Unlock()
+		if n == 0 {
+			return nil
+		}
+
+		err := ex.exporter.Write(ctx, batch)
+		if err != nil {
+			ex.metrics.exportPoints.Add(float64(n), ex.Name(), "error")
+			ex.mtx.Lock()
+			ex.buf = append(batch, ex.buf...)
+			ex.dropOverflow()
+			ex.mtx.Unlock()
+			return err
+		}
+		ex.metrics.exportPoints.Add(float64(n), ex.Name(), "sent")
+	}
+}
+
+// Run flushes the buffer periodically and when a batch is full.
+func (ex *Export) Run() {
+	ex.run_mtx.Lock()
+	defer ex.run_mtx.Unlock()
+	ex.stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ex.cancel = cancel
+	ex.wg.Add(1)
+	go func() {
+		defer ex.wg.Done()
+		ticker := time.NewTicker(ex.opts.FlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-ex.wake:
+			}
+			flush_ctx, cancel := context.WithTimeout(ctx, ex.opts.FlushTimeout)
+			err := ex.Flush(flush_ctx)
+			cancel()
+			if err != nil && ctx.Err() == nil {
+				slog.Error("export", "exporter", ex.Name(), "error", err)
+			}
+		}
+	}()
+}
+
+// Stop stops the loop and writes the remaining points within the timeout.
+func (ex *Export) Stop(timeout time.Duration) {
+	ex.run_mtx.Lock()
+	defer ex.run_mtx.Unlock()
+	ex.stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := ex.Flush(ctx)
+	if err != nil {
+		slog.Error("export, points lost", "exporter", ex.Name(), "points", ex.Pending(), "error", err)
+	}
+}
+
+func (ex *Export) stop() {
+	if ex.cancel != nil {
+		ex.cancel()
+		ex.wg.Wait()
+		ex.cancel = nil
+	}
+}
+
+// ExportConfig is the common configuration of the exporters.
+type ExportConfig struct {
+	Prefix  string        // the measurement or the metric path prefix
+	Tags    []string      // static tags added to every point, ex: "env=prod"
+	Timeout time.Duration // bounds a write without a deadline, 10s if zero
+}
+
+func (cfg *ExportConfig) timeout() time.Duration {
+	if cfg.Timeout <= 0 {
+		return exportTimeout
+	}
+	return cfg.Timeout
+}
+
+// pointTags returns the static tags, the tags of the point and its target as
+// sorted key-value pairs, the value of a bare tag is "true".
+func (cfg *ExportConfig) pointTags(p *Point) [][2]string {
+	m := map[string]string{}
+	for _, v := range [][]string{cfg.Tags, p.Tags} {
+		for _, s := range v {
+			k, x, ok := strings.Cut(s, "=")
+			if !ok {
+				x = "true"
+			}
+			m[k] = x
+		}
+	}
+	m["target"] = p.Target
+	pairs := make([][2]string, 0, len(m))
+	for k, v := range m {
+		pairs = append(pairs, [2]string{k, v})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	return pairs
+}
+
+// ParseExport creates an export from its URL, the scheme selects the backend:
+//
+//	influx+http://localhost:8086/write?db=probes
+//	graphite://localhost:2003
+//	statsd://localhost:8125
+//
+// The options are given as query parameters removed from the URL: prefix,
+// tag (repeated, ex: tag=env=prod), batch, buffer, flush and timeout (in
+// second) and the InfluxDB token.
+func ParseExport(s string) (*Export, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s: %v", ErrInvalidExport, s, err)
+	}
+
+	q := u.Query()
+	var opts ExportOptions
+	for k, p := range map[string]*int{"batch": &opts.BatchSize, "buffer": &opts.BufferSize} {
+		if v := q.Get(k); v != "" {
+			if *p, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("%w %s: %s: %v", ErrInvalidExport, s, k, err)
+			}
+		}
+	}
+	for k, p := range map[string]*time.Duration{"flush": &opts.FlushInterval, "timeout": &opts.FlushTimeout} {
+		if v := q.Get(k); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("%w %s: %s: %v", ErrInvalidExport, s, k, err)
+			}
+			*p = time.Duration(n) * time.Second
+		}
+	}
+	cfg := ExportConfig{Prefix: q.Get("prefix"), Tags: q["tag"], Timeout: opts.FlushTimeout}
+	token := q.Get("token")
+	for _, k := range []string{"batch", "buffer", "flush", "timeout", "prefix", "tag", "token"} {
+		q.Del(k)
+	}
+	u.RawQuery = q.Encode()
+
+	if (u.Scheme == "graphite" || u.Scheme == "statsd") && u.Host == "" {
+		return nil, fmt.Errorf("%w %s: no host", ErrInvalidExport, s)
+	}
+
+	var exporter Exporter
+	switch u.Scheme {
+	case "influx+http", "influx+https":
+		u.Scheme = strings.TrimPrefix(u.Scheme, "influx+")
+		exporter = NewInfluxExporter(u.String(), token, cfg)
+	case "graphite":
+		exporter = NewGraphiteExporter(u.Host, cfg)
+	case "statsd":
+		exporter = NewStatsDExporter(u.Host, cfg)
+	default:
+		return nil, fmt.Errorf("%w %s: unknown scheme %q", ErrInvalidExport, s, u.Scheme)
+	}
+	return NewExport(exporter, opts), nil
+}
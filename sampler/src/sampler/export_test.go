@@ -0,0 +1,207 @@
+package sampler_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	sampler "scraper/sampler/src/sampler"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPoints() []sampler.Point {
+	t := time.Unix(1700000000, 0)
+	return []sampler.Point{
+		{Target: "jd.com", Tags: []string{"team=retail", "critical"}, Available: true, AccessTime: 12500 * time.Microsecond, Time: t},
+		{Target: "live.com:443", Available: false, Suspect: true, Time: t},
+	}
+}
+
+func TestInfluxExport(t *testing.T) {
+	body := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("db") != "probes" || r.URL.Query().Get("token") != "" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		if r.Header.Get("Authorization") != "Token secret" {
+			t.Errorf("unexpected authorization %q", r.Header.Get("Authorization"))
+		}
+		data, _ := io.ReadAll(r.Body)
+		body <- string(data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	ex, err := sampler.ParseExport("influx+" + ts.URL + "/write?db=probes&token=secret&tag=env=prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex.Push(testPoints()...)
+	if err = ex.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "probe,critical=true,env=prod,target=jd.com,team=retail available=1i,access_time_ms=12.5,suspect=false 1700000000000000000\n" +
+		"probe,env=prod,target=live.com:443 available=0i,suspect=true 1700000000000000000\n"
+	if got := <-body; got != expected {
+		t.Errorf("unexpected body:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestGraphiteExport(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan []string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var v []string
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			v = append(v, sc.Text())
+		}
+		lines <- v
+	}()
+
+	ex, err := sampler.ParseExport("graphite://" + ln.Addr().String() + "?prefix=probes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex.Push(testPoints()...)
+	if err = ex.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"probes.jd_com.available;critical=true;team=retail 1 1700000000",
+		"probes.jd_com.access_time_ms;critical=true;team=retail 12.5 1700000000",
+		"probes.live_com_443.available 0 1700000000",
+	}
+	if got := <-lines; strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected lines:\n%s", strings.Join(got, "\n"))
+	}
+}
+
+func TestStatsDExport(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ex, err := sampler.ParseExport("statsd://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex.Push(testPoints()...)
+	if err = ex.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "scraper.sampler.jd_com.available:1|g|#critical:true,team:retail\n" +
+		"scraper.sampler.jd_com.access_time:12.5|ms|#critical:true,team:retail\n" +
+		"scraper.sampler.live_com_443.available:0|g"
+	if got := string(buf[:n]); got != expected {
+		t.Errorf("unexpected packet:\n%s", got)
+	}
+}
+
+type failingExporter struct {
+	fail    bool
+	written []sampler.Point
+}
+
+func (fe *failingExporter) Name() string { return "test" }
+
+func (fe *failingExporter) Write(ctx context.Context, points []sampler.Point) error {
+	if fe.fail {
+		return errors.New("backend down")
+	}
+	fe.written = append(fe.written, points...)
+	return nil
+}
+
+func TestExportBuffering(t *testing.T) {
+	fe := &failingExporter{fail: true}
+	ex := sampler.NewExport(fe, sampler.ExportOptions{BatchSize: 2, BufferSize: 3, FlushInterval: time.Hour})
+
+	points := testPoints()
+	ex.Push(points[0], points[1], points[0])
+	if err := ex.Flush(context.Background()); err == nil {
+		t.Fatal("expected write error")
+	}
+	if n := ex.Pending(); n != 3 {
+		t.Errorf("expected the failed batch to be kept, %d points pending", n)
+	}
+
+	// the oldest point is dropped above the buffer size
+	ex.Push(points[1])
+	fe.fail = false
+	if err := ex.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(fe.written) != 3 || fe.written[0].Target != "live.com:443" || ex.Pending() != 0 {
+		t.Errorf("unexpected written points %+v", fe.written)
+	}
+
+	if _, err := sampler.ParseExport("graphite://?prefix=x"); !errors.Is(err, sampler.ErrInvalidExport) {
+		t.Errorf("expected invalid export, got %v", err)
+	}
+	if _, err := sampler.ParseExport("kafka://localhost:9092"); !errors.Is(err, sampler.ErrInvalidExport) {
+		t.Errorf("expected invalid export, got %v", err)
+	}
+	if _, err := sampler.ParseExport("graphite://localhost:2003?timeout=x"); !errors.Is(err, sampler.ErrInvalidExport) {
+		t.Errorf("expected invalid export, got %v", err)
+	}
+}
+
+// hungExporter blocks its writes until their context is done
+type hungExporter struct {
+	writes chan bool // whether the context of a write has a deadline
+}
+
+func (he *hungExporter) Name() string { return "hung" }
+
+func (he *hungExporter) Write(ctx context.Context, points []sampler.Point) error {
+	_, ok := ctx.Deadline()
+	he.writes <- ok
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestExportFlushTimeout(t *testing.T) {
+	he := &hungExporter{writes: make(chan bool, 16)}
+	ex := sampler.NewExport(he, sampler.ExportOptions{FlushInterval: 10 * time.Millisecond, FlushTimeout: 20 * time.Millisecond})
+	ex.Push(testPoints()...)
+	ex.Run()
+	defer ex.Stop(10 * time.Millisecond)
+
+	// the periodic flushes go on while the backend hangs
+	for i := 0; i < 2; i++ {
+		select {
+		case ok := <-he.writes:
+			if !ok {
+				t.Fatal("periodic flush without a deadline")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the export loop is blocked by the hung backend")
+		}
+	}
+}
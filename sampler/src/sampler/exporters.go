@@ -0,0 +1,250 @@
+package sampler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func boolToInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func accessTimeMs(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)
+}
+
+// InfluxExporter posts the points in InfluxDB line protocol, ex:
+//
+//	probe,target=jd.com,team=retail available=1i,access_time_ms=12.5,suspect=false 1700000000000000000
+type InfluxExporter struct {
+	url    string
+	token  string
+	cfg    ExportConfig
+	client *http.Client
+}
+
+// NewInfluxExporter creates the exporter posting to the write URL of
+// InfluxDB, ex: http://localhost:8086/write?db=probes for 1.x or
+// http://localhost:8086/api/v2/write?org=ops&bucket=probes for 2.x with its
+// token. The measurement is the prefix, "probe" if empty.
+func NewInfluxExporter(url, token string, cfg ExportConfig) *InfluxExporter {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "probe"
+	}
+	return &InfluxExporter{url: url, token: token, cfg: cfg, client: &http.Client{Timeout: cfg.timeout()}}
+}
+
+func (ie *InfluxExporter) Name() string {
+	return "influx"
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// Encode writes the points in line protocol.
+func (ie *InfluxExporter) Encode(w io.Writer, points []Point) {
+	measurement := influxMeasurementEscaper.Replace(ie.cfg.Prefix)
+	for i := range points {
+		p := &points[i]
+		var sb strings.Builder
+		sb.WriteString(measurement)
+		for _, kv := range ie.cfg.pointTags(p) {
+			if kv[1] == "" {
+				continue // empty tag values are not allowed
+			}
+			fmt.Fprintf(&sb, ",%s=%s", influxTagEscaper.Replace(kv[0]), influxTagEscaper.Replace(kv[1]))
+		}
+		fmt.Fprintf(&sb, " available=%di", boolToInt(p.Available))
+		if p.Available {
+			fmt.Fprintf(&sb, ",access_time_ms=%s", accessTimeMs(p.AccessTime))
+		}
+		fmt.Fprintf(&sb, ",suspect=%t %d\n", p.Suspect, p.Time.UnixNano())
+		io.WriteString(w, sb.String())
+	}
+}
+
+func (ie *InfluxExporter) Write(ctx context.Context, points []Point) error {
+	var buf bytes.Buffer
+	ie.Encode(&buf, points)
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, ie.url, &buf)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if ie.token != "" {
+		r.Header.Set("Authorization", "Token "+ie.token)
+	}
+	resp, err := ie.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("influx returns code %d: %s", resp.StatusCode, data)
+	}
+	return nil
+}
+
+// metricPath turns a target into a node of a metric path, ex: "jd.com:443"
+// becomes "jd_com_443".
+var metricPath = strings.NewReplacer(".", "_", ":", "_", "/", "_", " ", "_", ";", "_")
+
+// GraphiteExporter writes the points in Graphite plaintext protocol over TCP,
+// the other tags follow the path as Graphite 1.1 tags, ex:
+//
+//	scraper.sampler.jd_com.available;team=retail 1 1700000000
+//	scraper.sampler.jd_com.access_time_ms;team=retail 12.5 1700000000
+type GraphiteExporter struct {
+	address string
+	cfg     ExportConfig
+}
+
+// NewGraphiteExporter creates the exporter to the carbon address, ex:
+// localhost:2003. The path prefix is "scraper.sampler" if empty.
+func NewGraphiteExporter(address string, cfg ExportConfig) *GraphiteExporter {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "scraper.sampler"
+	}
+	return &GraphiteExporter{address: address, cfg: cfg}
+}
+
+func (ge *GraphiteExporter) Name() string {
+	return "graphite"
+}
+
+// Encode writes the points in plaintext protocol.
+func (ge *GraphiteExporter) Encode(w io.Writer, points []Point) {
+	for i := range points {
+		p := &points[i]
+		var tags strings.Builder
+		for _, kv := range ge.cfg.pointTags(p) {
+			if kv[0] == "target" || kv[1] == "" {
+				continue
+			}
+			fmt.Fprintf(&tags, ";%s=%s", metricPath.Replace(kv[0]), metricPath.Replace(kv[1]))
+		}
+		path := ge.cfg.Prefix + "." + metricPath.Replace(p.Target)
+		t := p.Time.Unix()
+		fmt.Fprintf(w, "%s.available%s %d %d\n", path, tags.String(), boolToInt(p.Available), t)
+		if p.Available {
+			fmt.Fprintf(w, "%s.access_time_ms%s %s %d\n", path, tags.String(), accessTimeMs(p.AccessTime), t)
+		}
+	}
+}
+
+func (ge *GraphiteExporter) Write(ctx context.Context, points []Point) error {
+	var buf bytes.Buffer
+	ge.Encode(&buf, points)
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ge.cfg.timeout())
+		defer cancel()
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", ge.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetWriteDeadline(deadline)
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
+
+// statsdPacketSize keeps the datagrams under the usual MTU.
+const statsdPacketSize = 1432
+
+// StatsDExporter sends the points over UDP as StatsD gauges and timers, the
+// other tags are sent in the DogStatsD format, ex:
+//
+//	scraper.sampler.jd_com.available:1|g|#team:retail
+//	scraper.sampler.jd_com.access_time:12.5|ms|#team:retail
+type StatsDExporter struct {
+	address string
+	cfg     ExportConfig
+}
+
+// NewStatsDExporter creates the exporter to the StatsD address, ex:
+// localhost:8125. The metric prefix is "scraper.sampler" if empty.
+func NewStatsDExporter(address string, cfg ExportConfig) *StatsDExporter {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "scraper.sampler"
+	}
+	return &StatsDExporter{address: address, cfg: cfg}
+}
+
+func (se *StatsDExporter) Name() string {
+	return "statsd"
+}
+
+// Encode returns the lines of the points.
+func (se *StatsDExporter) Encode(points []Point) []string {
+	var lines []string
+	for i := range points {
+		p := &points[i]
+		var tags []string
+		for _, kv := range se.cfg.pointTags(p) {
+			if kv[0] != "target" {
+				tags = append(tags, kv[0]+":"+kv[1])
+			}
+		}
+		suffix := ""
+		if len(tags) > 0 {
+			suffix = "|#" + strings.Join(tags, ",")
+		}
+		path := se.cfg.Prefix + "." + metricPath.Replace(p.Target)
+		lines = append(lines, fmt.Sprintf("%s.available:%d|g%s", path, boolToInt(p.Available), suffix))
+		if p.Available {
+			lines = append(lines, fmt.Sprintf("%s.access_time:%s|ms%s", path, accessTimeMs(p.AccessTime), suffix))
+		}
+	}
+	return lines
+}
+
+func (se *StatsDExporter) Write(ctx context.Context, points []Point) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", se.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the lines are packed in datagrams separated by new lines
+	var packet []byte
+	send := func() error {
+		if len(packet) == 0 {
+			return nil
+		}
+		_, err := conn.Write(packet)
+		packet = packet[:0]
+		return err
+	}
+	for _, s := range se.Encode(points) {
+		if len(packet) > 0 && len(packet)+1+len(s) > statsdPacketSize {
+			if err = send(); err != nil {
+				return err
+			}
+		}
+		if len(packet) > 0 {
+			packet = append(packet, '\n')
+		}
+		packet = append(packet, s...)
+	}
+	return send()
+}
@@ -0,0 +1,80 @@
+package sampler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrDependencyCycle = errors.New("dependency cycle")
+
+// DependencyGraph maps every target to the targets it depends on.
+type DependencyGraph struct {
+	parents map[string][]string
+}
+
+func (sites *Sites) Graph() *DependencyGraph {
+	g := &DependencyGraph{parents: make(map[string][]string)}
+	for _, p := range sites.Targets {
+		g.parents[p.Address] = append(g.parents[p.Address], p.DependsOn...)
+	}
+	for _, ct := range sites.Composites {
+		g.parents[ct.Name] = append(g.parents[ct.Name], ct.DependsOn...)
+	}
+	for k, v := range g.parents {
+		g.parents[k] = uniqueSorted(v)
+	}
+	return g
+}
+
+// Order returns the targets sorted so that every target comes after the
+// targets it depends on.
+func (g *DependencyGraph) Order() ([]string, error) {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+
+	keys := make([]string, 0, len(g.parents))
+	for k := range g.parents {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	state := map[string]int{}
+	order := make([]string, 0, len(keys))
+	var visit func(k string, path []string) error
+	visit = func(k string, path []string) error {
+		switch state[k] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("%w: %v", ErrDependencyCycle, append(path, k))
+		}
+		state[k] = visiting
+		for _, p := range g.parents[k] {
+			if err := visit(p, append(path, k)); err != nil {
+				return err
+			}
+		}
+		state[k] = visited
+		order = append(order, k)
+		return nil
+	}
+
+	for _, k := range keys {
+		if err := visit(k, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// GraphNode is a target of the dependency graph with its current state.
+type GraphNode struct {
+	Target                 string   `json:"target"`
+	DependsOn              []string `json:"depends_on,omitempty"`
+	Dependents             []string `json:"dependents,omitempty"`
+	Availability           bool     `json:"availability"`
+	UnreachableDueToParent bool     `json:"unreachable_due_to_parent,omitempty"`
+}
@@ -0,0 +1,90 @@
+package sampler
+
+import (
+	"context"
+	"scraper/libs/rpc"
+	"time"
+)
+
+// ToProto converts the data of targets to their gRPC message.
+func ToProto(v []SampleData, health Health) *rpc.Samples {
+	x := &rpc.Samples{
+		Samples: make([]*rpc.Sample, len(v)),
+		Health:  &rpc.Health{Status: health.Status, Reason: health.Reason, Round: health.Round},
+	}
+	for i := range v {
+		p := &v[i]
+		x.Samples[i] = &rpc.Sample{
+			Address:   p.Address,
+			Tags:      p.Tags,
+			Composite: p.Composite,
+			Members:   p.Members,
+			Status: &rpc.Status{
+				Availability:           p.Availability,
+				AccessTime:             int64(p.AccessTime),
+				Maintenance:            p.Maintenance,
+				UnreachableDueToParent: p.UnreachableDueToParent,
+				Suspect:                p.Suspect,
+			},
+		}
+	}
+	return x
+}
+
+// FromProto converts the gRPC message to the data of targets and the health
+// of the sampler.
+func FromProto(x *rpc.Samples) ([]SampleData, Health) {
+	v := make([]SampleData, len(x.GetSamples()))
+	for i, p := range x.GetSamples() {
+		s := p.GetStatus()
+		v[i] = SampleData{
+			Address:   p.GetAddress(),
+			Tags:      p.GetTags(),
+			Composite: p.GetComposite(),
+			Members:   p.GetMembers(),
+			Status: Status{
+				Availability:           s.GetAvailability(),
+				AccessTime:             time.Duration(s.GetAccessTime()),
+				Maintenance:            s.GetMaintenance(),
+				UnreachableDueToParent: s.GetUnreachableDueToParent(),
+				Suspect:                s.GetSuspect(),
+			},
+		}
+	}
+	h := x.GetHealth()
+	return v, Health{Status: h.GetStatus(), Reason: h.GetReason(), Round: h.GetRound()}
+}
+
+// GRPCService serves the data of the manager over gRPC.
+type GRPCService struct {
+	rpc.UnimplementedSamplerServer
+	sm *Manager
+}
+
+func NewGRPCService(sm *Manager) *GRPCService {
+	return &GRPCService{sm: sm}
+}
+
+func (gs *GRPCService) GetAll(ctx context.Context, req *rpc.GetAllRequest) (*rpc.Samples, error) {
+	return ToProto(FilterTags(gs.sm.GetAll(), req.GetTags()), gs.sm.Health()), nil
+}
+
+func (gs *GRPCService) GetMany(ctx context.Context, req *rpc.GetManyRequest) (*rpc.Samples, error) {
+	return ToProto(gs.sm.GetMany(req.GetAddresses()), gs.sm.Health()), nil
+}
+
+func (gs *GRPCService) Probe(ctx context.Context, req *rpc.ProbeRequest) (*rpc.Samples, error) {
+	return ToProto(gs.sm.Probe(ctx, req.GetAddresses()), gs.sm.Health()), nil
+}
+
+// Watch sends the data after every round until the caller cancels.
+func (gs *GRPCService) Watch(req *rpc.WatchRequest, stream rpc.Sampler_WatchServer) error {
+	ctx := stream.Context()
+	for health := range gs.sm.Watch(ctx) {
+		err := stream.Send(ToProto(FilterTags(gs.sm.GetAll(), req.GetTags()), health))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package sampler
+
+import (
+	"errors"
+	"sync"
+)
+
+const (
+	HealthStarting = "starting"
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+)
+
+var ErrFirstRound = errors.New("the first round of sampling is not done")
+
+// Health is the state of the sampler after its last round.
+type Health struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	Round  int64  `json:"round,omitempty"` // the end of the last round in unix-epoch second
+}
+
+type SafeHealth struct {
+	mtx   sync.RWMutex
+	value Health
+}
+
+func (sh *SafeHealth) Get() Health {
+	sh.mtx.RLock()
+	defer sh.mtx.RUnlock()
+	if sh.value.Status == "" {
+		return Health{Status: HealthStarting}
+	}
+	return sh.value
+}
+
+func (sh *SafeHealth) Set(x Health) {
+	sh.mtx.Lock()
+	defer sh.mtx.Unlock()
+	sh.value = x
+}
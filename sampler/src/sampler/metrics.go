@@ -0,0 +1,69 @@
+package sampler
+
+import (
+	"scraper/libs"
+	"sync"
+)
+
+// metrics are the metric families of a manager and of its exports
+type metrics struct {
+	targetUp     *libs.Gauge
+	accessTime   *libs.Histogram
+	rounds       *libs.Counter
+	exportPoints *libs.Counter
+}
+
+func newMetrics(reg *libs.Registry) *metrics {
+	return &metrics{
+		targetUp: reg.NewGauge("scraper_sampler_target_up",
+			"Whether the target was available at the last trusted round, 1 or 0.", "target"),
+		accessTime: reg.NewHistogram("scraper_sampler_access_time_seconds",
+			"The access time of the available targets at the trusted rounds.", nil, "target"),
+		rounds: reg.NewCounter("scraper_sampler_rounds_total",
+			"The number of sampling rounds by health status.", "status"),
+		exportPoints: reg.NewCounter("scraper_sampler_export_points_total",
+			"The number of probe results by exporter and result: sent, error or dropped.", "exporter", "result"),
+	}
+}
+
+// unregistered are the metrics of the exports not added to a manager, they
+// are not exported
+var unregistered = newMetrics(libs.NewRegistry())
+
+var (
+	defaultMetrics     *metrics
+	defaultMetricsOnce sync.Once
+)
+
+// registeredMetrics returns the metrics on libs.DefaultRegistry, registered
+// by the first manager
+func registeredMetrics() *metrics {
+	defaultMetricsOnce.Do(func() {
+		defaultMetrics = newMetrics(libs.DefaultRegistry)
+	})
+	return defaultMetrics
+}
+
+// SetRegistry registers the metrics of the manager and of its exports on the
+// registry instead of libs.DefaultRegistry, ex: in a test, before Run.
+func (sm *Manager) SetRegistry(reg *libs.Registry) {
+	sm.metrics = newMetrics(reg)
+	for _, p := range sm.lut {
+		p.metrics = sm.metrics
+	}
+	for _, ex := range sm.exports {
+		ex.metrics = sm.metrics
+	}
+}
+
+// exportMetrics sets the availability gauges from the current data, including
+// the composite targets.
+func (sm *Manager) exportMetrics() {
+	for _, x := range sm.GetAll() {
+		v := 0.0
+		if x.Availability {
+			v = 1
+		}
+		sm.metrics.targetUp.Set(v, x.Address)
+	}
+}
@@ -0,0 +1,113 @@
+package sampler
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Down returns the unavailable items having all of the queried tags.
+func Down(v []SampleData, tags []string) []SampleData {
+	var rs []SampleData
+	for _, x := range FilterTags(v, tags) {
+		if !x.Availability {
+			rs = append(rs, x)
+		}
+	}
+	return rs
+}
+
+// reason explains the status of an unavailable item
+func reason(x SampleData) string {
+	switch {
+	case x.UnreachableDueToParent:
+		return "unreachable due to parent"
+	case x.Suspect:
+		return "suspect round"
+	case x.Composite != "":
+		return fmt.Sprintf("down (%s of %s)", x.Composite, strings.Join(x.Members, ", "))
+	}
+	return "down"
+}
+
+// WriteTable writes the items as a text table, one line per target.
+func WriteTable(w io.Writer, v []SampleData) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tSTATUS\tACCESS_MS\tTAGS")
+	for _, x := range v {
+		status := "up"
+		if !x.Availability {
+			status = reason(x)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.3f\t%s\n", x.Address, status, float64(x.AccessTime)/float64(time.Millisecond), strings.Join(x.Tags, " "))
+	}
+	return tw.Flush()
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// WriteJUnit writes the items as a JUnit XML report, a target is a test case
+// failing when the target is unavailable and has all of the queried tags. The
+// unavailable targets without the tags are skipped.
+func WriteJUnit(w io.Writer, name string, v []SampleData, tags []string, elapsed time.Duration) error {
+	suite := junitSuite{Name: name, Tests: len(v), Time: seconds(elapsed)}
+	for _, x := range v {
+		c := junitCase{Name: x.Address, ClassName: name, Time: seconds(x.AccessTime)}
+		switch {
+		case x.Availability:
+		case HasTags(x.Tags, tags):
+			c.Failure = &junitFailure{Message: reason(x), Type: "unavailable"}
+			suite.Failures++
+		default:
+			c.Skipped = &junitSkipped{Message: fmt.Sprintf("%s, not tagged %s", reason(x), strings.Join(tags, ", "))}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	e := xml.NewEncoder(w)
+	e.Indent("", "  ")
+	err = e.Encode(suite)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,90 @@
+package sampler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net"
+	sampler "scraper/sampler/src/sampler"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbeOnce(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	up := ln.Addr().String()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := closed.Addr().String()
+	closed.Close()
+
+	sites, err := sampler.ParseSites(fmt.Sprintf("%s env=prod\n%s env=staging\n", up, down))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := sampler.NewSamplerManagerFromSites(time.Minute, time.Second, sites)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := m.Once(context.Background()); h.Status != sampler.HealthOK {
+		t.Fatalf("unexpected health %+v", h)
+	}
+	v := m.GetAll()
+
+	if x := sampler.Down(v, nil); len(x) != 1 || x[0].Address != down {
+		t.Errorf("unexpected down targets %+v", x)
+	}
+	if x := sampler.Down(v, []string{"env=prod"}); len(x) != 0 {
+		t.Errorf("unexpected down prod targets %+v", x)
+	}
+
+	var b bytes.Buffer
+	err = sampler.WriteTable(&b, v)
+	if err != nil || !strings.Contains(b.String(), up+"  up") || !strings.Contains(b.String(), down+"  down") {
+		t.Errorf("unexpected table %q, %v", b.String(), err)
+	}
+
+	// the failures of the JUnit report are the down targets having the tags,
+	// the other down targets are skipped
+	for tag, expected := range map[string][2]int{"": {1, 0}, "env=prod": {0, 1}, "env=staging": {1, 0}} {
+		var tags []string
+		if tag != "" {
+			tags = []string{tag}
+		}
+		b.Reset()
+		err = sampler.WriteJUnit(&b, "deploy", v, tags, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var suite struct {
+			Tests    int `xml:"tests,attr"`
+			Failures int `xml:"failures,attr"`
+			Skipped  int `xml:"skipped,attr"`
+			Cases    []struct {
+				Name    string    `xml:"name,attr"`
+				Failure *struct{} `xml:"failure"`
+				Skipped *struct {
+					Message string `xml:"message,attr"`
+				} `xml:"skipped"`
+			} `xml:"testcase"`
+		}
+		err = xml.Unmarshal(b.Bytes(), &suite)
+		if err != nil || suite.Tests != 2 || suite.Failures != expected[0] || suite.Skipped != expected[1] || len(suite.Cases) != 2 {
+			t.Errorf("unexpected report with tag %q: %+v, %v", tag, suite, err)
+		}
+		for _, c := range suite.Cases {
+			if (c.Skipped != nil) != (c.Name == down && expected[1] > 0) || c.Skipped != nil && c.Skipped.Message != "down, not tagged env=prod" {
+				t.Errorf("unexpected case with tag %q: %+v", tag, c)
+			}
+		}
+	}
+}
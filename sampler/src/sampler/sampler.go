@@ -1,9 +1,12 @@
 package sampler
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"log/slog"
 	"net"
 	"scraper/libs"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -13,35 +16,79 @@ import (
 type Status struct {
 	Availability bool          `json:"availability"`
 	AccessTime   time.Duration `json:"access_time"`
+	Maintenance  bool          `json:"maintenance,omitempty"` // set by the monitor on failures in a maintenance window
+	// set on failures while a target it depends on is down
+	UnreachableDueToParent bool `json:"unreachable_due_to_parent,omitempty"`
+	// set when the last round was suspect, the status is the one of the last trusted round
+	Suspect bool `json:"suspect,omitempty"`
 }
 
 type SampleData struct {
-	Address string `json:"address"`
-	Status  `json:",inline"`
+	Address   string   `json:"address"`
+	Tags      []string `json:"tags,omitempty"`
+	Composite string   `json:"composite,omitempty"` // the rule of a composite target: "all", "any" or "<k>of"
+	Members   []string `json:"members,omitempty"`
+	Status    `json:",inline"`
 }
 
 type Sampler struct {
 	address string
 	data    SampleData
+	pending Status // the result of the last probe, not applied yet
+	metrics *metrics
 	mtx     sync.RWMutex
 }
 
-func (sp *Sampler) Update(timeout time.Duration) {
+func probeAddress(ctx context.Context, address string, timeout time.Duration) (time.Duration, error) {
 	tstart := time.Now()
-	conn, err := net.DialTimeout("tcp", sp.address, timeout)
+	d := net.Dialer{Timeout: timeout}
+	conn, err := d.DialContext(ctx, "tcp", address)
 	dt := time.Since(tstart)
 	if err == nil && conn != nil {
 		conn.Close()
 	}
+	return dt, err
+}
+
+// Probe checks the address and keeps the result pending, it reports whether
+// the address is available. Cancelling the context aborts the probe.
+func (sp *Sampler) Probe(ctx context.Context, timeout time.Duration) bool {
+	ctx, span := libs.StartSpan(ctx, "probe", libs.SpanInternal)
+	defer span.End()
 
+	dt, err := probeAddress(ctx, sp.address, timeout)
+	sp.pending = Status{Availability: err == nil, AccessTime: dt}
+	span.SetAttr("target", sp.data.Address)
+	span.SetAttr("availability", err == nil)
+	span.SetAttr("access_time", dt)
+	span.SetError(err)
+	return err == nil
+}
+
+// commit applies the pending result of the last probe.
+func (sp *Sampler) commit() {
 	sp.mtx.Lock()
 	defer sp.mtx.Unlock()
-	if err != nil {
+	sp.data.Suspect = false
+	if !sp.pending.Availability {
 		sp.data.Availability = false
 		return
 	}
-	sp.data.AccessTime = dt
+	sp.data.AccessTime = sp.pending.AccessTime
 	sp.data.Availability = true
+	sp.metrics.accessTime.Observe(sp.pending.AccessTime.Seconds(), sp.data.Address)
+}
+
+// flagSuspect drops the pending result and flags the current data.
+func (sp *Sampler) flagSuspect() {
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+	sp.data.Suspect = true
+}
+
+func (sp *Sampler) Update(ctx context.Context, timeout time.Duration) {
+	sp.Probe(ctx, timeout)
+	sp.commit()
 }
 
 func (sp *Sampler) CurrentData() SampleData {
@@ -50,6 +97,12 @@ func (sp *Sampler) CurrentData() SampleData {
 	return sp.data
 }
 
+func (sp *Sampler) setUnreachable(value bool) {
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+	sp.data.UnreachableDueToParent = value
+}
+
 func (sp *Sampler) setAddress(value string) {
 	sp.data.Address = value
 	if !strings.Contains(value, ":") {
@@ -71,61 +124,285 @@ type Group struct {
 	data []Sampler
 }
 
-func (gs *Group) Update(timeout time.Duration) {
+func (gs *Group) Update(ctx context.Context, timeout time.Duration) {
+	for i := range gs.data {
+		gs.data[i].Update(ctx, timeout)
+	}
+}
+
+// probe probes all samplers of the group and returns the number of failures.
+func (gs *Group) probe(ctx context.Context, timeout time.Duration) int64 {
+	var failures int64
 	for i := range gs.data {
-		gs.data[i].Update(timeout)
+		if !gs.data[i].Probe(ctx, timeout) {
+			failures++
+		}
+	}
+	return failures
+}
+
+func (gs *Group) apply(suspect bool) {
+	for i := range gs.data {
+		if suspect {
+			gs.data[i].flagSuspect()
+		} else {
+			gs.data[i].commit()
+		}
 	}
 }
 
-func NewGroupSamplers(addresses []string) *Group {
+func NewGroupSamplers(targets []Target) *Group {
 	gs := new(Group)
-	n := len(addresses)
+	n := len(targets)
 	gs.data = make([]Sampler, n)
 	for i := 0; i < n; i++ {
-		gs.data[i].setAddress(addresses[i])
+		gs.data[i].setAddress(targets[i].Address)
+		gs.data[i].data.Tags = targets[i].Tags
+		gs.data[i].metrics = unregistered
 	}
 	return gs
 }
 
 type Manager struct {
-	groups  []*Group
-	lut     map[string]*Sampler
-	period  time.Duration
-	timeout time.Duration
-	wg      sync.WaitGroup
-	running atomic.Bool
-	evt     chan bool
+	groups     []*Group
+	lut        map[string]*Sampler
+	composites map[string]*Composite
+	parents    map[string][]string // dependencies of targets
+	order      []string            // targets sorted after their dependencies
+	canaries   []string
+	max_failed float64 // ratio of failed targets above which a round is suspect, 0 to disable
+	health     SafeHealth
+	exports    []*Export
+	watch_mtx  sync.Mutex
+	watchers   map[chan Health]bool
+	period     time.Duration
+	timeout    time.Duration
+	metrics    *metrics
+	wg         sync.WaitGroup
+	run_mtx    sync.Mutex
+	cancel     context.CancelFunc // stops the running loop and its in-flight probes
 }
 
-func (sm *Manager) update() {
-	log.Println("manager update sites")
+func (sm *Manager) update(ctx context.Context) {
+	slog.DebugContext(ctx, "sampling round", "targets", len(sm.lut))
+	ctx, span := libs.StartSpan(ctx, "sampling round", libs.SpanInternal)
+	defer span.End()
+	span.SetAttr("targets", len(sm.lut))
+
+	canaries := sm.probeCanaries(ctx)
+
 	var wg sync.WaitGroup
+	var failures atomic.Int64
 	for _, p := range sm.groups {
 		wg.Add(1)
 		go func(gs *Group) {
-			gs.Update(sm.timeout)
+			failures.Add(gs.probe(ctx, sm.timeout))
 			wg.Done()
 		}(p)
 	}
 	wg.Wait()
+
+	if ctx.Err() != nil {
+		slog.InfoContext(ctx, "sampling round cancelled, results are not applied")
+		return
+	}
+
+	health := Health{Status: HealthOK, Round: time.Now().Unix()}
+	if len(canaries) > 0 {
+		health.Status = HealthDegraded
+		health.Reason = fmt.Sprintf("canaries failed: %v", canaries)
+	} else if n := len(sm.lut); sm.max_failed > 0 && n > 0 {
+		ratio := float64(failures.Load()) / float64(n)
+		if ratio > sm.max_failed {
+			health.Status = HealthDegraded
+			health.Reason = fmt.Sprintf("%.0f%% of targets failed", ratio*100)
+		}
+	}
+
+	span.SetAttr("health", health.Status)
+	suspect := health.Status != HealthOK
+	if suspect {
+		slog.WarnContext(ctx, "suspect round, results are not applied", "reason", health.Reason)
+	}
+	for _, p := range sm.groups {
+		p.apply(suspect)
+	}
+	sm.health.Set(health)
+	sm.export(suspect)
+	sm.metrics.rounds.Inc(health.Status)
+
+	for _, p := range sm.composites {
+		p.Update()
+	}
+	sm.updateDependencies()
+	sm.exportMetrics()
+	sm.notify(health)
+}
+
+// updateDependencies marks the failed targets having a failed dependency as
+// unreachable due to parent. A parent unreachable due to its own parent is down
+// as well, so the flag propagates along the graph.
+func (sm *Manager) updateDependencies() {
+	for _, k := range sm.order {
+		parents := sm.parents[k]
+		if len(parents) == 0 {
+			continue
+		}
+		x, ok := sm.get(k)
+		if !ok {
+			continue
+		}
+
+		unreachable := false
+		if !x.Availability {
+			for _, p := range parents {
+				y, ok := sm.get(p)
+				if ok && !y.Availability {
+					unreachable = true
+					break
+				}
+			}
+		}
+
+		if p, ok := sm.lut[k]; ok {
+			p.setUnreachable(unreachable)
+		} else if p, ok := sm.composites[k]; ok {
+			p.setUnreachable(unreachable)
+		}
+	}
+}
+
+// Graph returns the dependency graph with the current state of its targets,
+// only targets having dependencies or dependents are listed.
+func (sm *Manager) Graph() []GraphNode {
+	children := map[string][]string{}
+	for k, parents := range sm.parents {
+		for _, p := range parents {
+			children[p] = append(children[p], k)
+		}
+	}
+
+	var v []GraphNode
+	for _, k := range sm.order {
+		if len(sm.parents[k]) == 0 && len(children[k]) == 0 {
+			continue
+		}
+		x, _ := sm.get(k)
+		dependents := children[k]
+		sort.Strings(dependents)
+		v = append(v, GraphNode{
+			Target:                 k,
+			DependsOn:              sm.parents[k],
+			Dependents:             dependents,
+			Availability:           x.Availability,
+			UnreachableDueToParent: x.UnreachableDueToParent,
+		})
+	}
+	return v
+}
+
+// AddExport pushes the probe results of every round to the export, it is run
+// and stopped with the manager.
+func (sm *Manager) AddExport(ex *Export) {
+	ex.metrics = sm.metrics
+	sm.exports = append(sm.exports, ex)
+}
+
+// export pushes the results of the last probes, including the ones of a
+// suspect round.
+func (sm *Manager) export(suspect bool) {
+	if len(sm.exports) == 0 {
+		return
+	}
+	t := time.Now()
+	var points []Point
+	for _, g := range sm.groups {
+		for i := range g.data {
+			p := &g.data[i]
+			points = append(points, Point{
+				Target:     p.data.Address,
+				Tags:       p.data.Tags,
+				Available:  p.pending.Availability,
+				AccessTime: p.pending.AccessTime,
+				Suspect:    suspect,
+				Time:       t,
+			})
+		}
+	}
+	for _, ex := range sm.exports {
+		ex.Push(points...)
+	}
+}
+
+// probeCanaries returns the failed canaries.
+func (sm *Manager) probeCanaries(ctx context.Context) []string {
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	var failed []string
+	for _, address := range sm.canaries {
+		wg.Add(1)
+		go func(address string) {
+			defer wg.Done()
+			_, err := probeAddress(ctx, address, sm.timeout)
+			if err != nil {
+				mtx.Lock()
+				failed = append(failed, address)
+				mtx.Unlock()
+			}
+		}(address)
+	}
+	wg.Wait()
+	sort.Strings(failed)
+	return failed
+}
+
+// SetSelfCheck configures the checks of the sampler's own network: a round is
+// suspect if any of the canaries fails, or if the ratio of failed targets is
+// above max_failed (0 to disable).
+func (sm *Manager) SetSelfCheck(canaries []string, max_failed float64) {
+	sm.canaries = canaries
+	sm.max_failed = max_failed
+}
+
+// Health returns the state of the last round.
+func (sm *Manager) Health() Health {
+	return sm.health.Get()
+}
+
+// Ready returns ErrFirstRound until the end of the first round.
+func (sm *Manager) Ready() error {
+	if sm.Health().Status == HealthStarting {
+		return ErrFirstRound
+	}
+	return nil
+}
+
+func (sm *Manager) get(address string) (SampleData, bool) {
+	if p, ok := sm.lut[address]; ok {
+		return p.CurrentData(), true
+	}
+	if p, ok := sm.composites[address]; ok {
+		return p.CurrentData(), true
+	}
+	return SampleData{}, false
 }
 
 func (sm *Manager) GetAll() []SampleData {
-	v := make([]SampleData, len(sm.lut))
-	cnt := 0
+	v := make([]SampleData, 0, len(sm.lut)+len(sm.composites))
 	for _, p := range sm.lut {
-		v[cnt] = p.CurrentData()
-		cnt++
+		v = append(v, p.CurrentData())
+	}
+	for _, p := range sm.composites {
+		v = append(v, p.CurrentData())
 	}
 	return v
 }
 
 func (sm *Manager) GetOne(address string) *SampleData {
-	p, ok := sm.lut[address]
+	x, ok := sm.get(address)
 	if !ok {
 		return nil
 	}
-	x := p.CurrentData()
 	return &x
 }
 
@@ -135,61 +412,168 @@ func (sm *Manager) GetMany(addresses []string) []SampleData {
 
 	ls := make([]SampleData, 0, n)
 	for i := 0; i < n; i++ {
-		p, ok := sm.lut[v[i]]
+		x, ok := sm.get(v[i])
 		if !ok {
 			continue
 		}
-		ls = append(ls, p.CurrentData())
+		ls = append(ls, x)
 	}
 	return ls
 }
 
+// Probe checks the targets now and returns their data with the results, which
+// are not applied. The composite targets and the unknown addresses are left
+// out.
+func (sm *Manager) Probe(ctx context.Context, addresses []string) []SampleData {
+	v := libs.Unique(addresses)
+	rs := make([]SampleData, 0, len(v))
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	for _, address := range v {
+		p, ok := sm.lut[address]
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(p *Sampler) {
+			defer wg.Done()
+			x := p.CurrentData()
+			dt, err := probeAddress(ctx, p.address, sm.timeout)
+			x.Availability = err == nil
+			x.AccessTime = dt
+			x.Suspect = false
+			x.UnreachableDueToParent = false
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			rs = append(rs, x)
+		}(p)
+	}
+	wg.Wait()
+	return rs
+}
+
+// Watch returns a channel receiving the health after every round until the
+// context is done, a slow receiver misses rounds.
+func (sm *Manager) Watch(ctx context.Context) <-chan Health {
+	ch := make(chan Health, 1)
+	sm.watch_mtx.Lock()
+	if sm.watchers == nil {
+		sm.watchers = make(map[chan Health]bool)
+	}
+	sm.watchers[ch] = true
+	sm.watch_mtx.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		sm.watch_mtx.Lock()
+		defer sm.watch_mtx.Unlock()
+		delete(sm.watchers, ch)
+		close(ch)
+	}()
+	return ch
+}
+
+func (sm *Manager) notify(health Health) {
+	sm.watch_mtx.Lock()
+	defer sm.watch_mtx.Unlock()
+	for ch := range sm.watchers {
+		select {
+		case ch <- health:
+		default:
+		}
+	}
+}
+
+// Stop stops the sampling loop, the in-flight probes are cancelled.
 func (sm *Manager) Stop() {
-	if sm.running.Load() {
-		log.Printf("try to stop sampler manager")
-		sm.running.Store(false)
-		sm.evt <- false
+	sm.run_mtx.Lock()
+	defer sm.run_mtx.Unlock()
+	sm.stop()
+}
+
+func (sm *Manager) stop() {
+	if sm.cancel != nil {
+		slog.Info("stopping sampler manager")
+		sm.cancel()
 		sm.wg.Wait()
-		log.Printf("stopped sampler manager")
+		sm.cancel = nil
+		for _, ex := range sm.exports {
+			ex.Stop(exportStopTimeout)
+		}
+		slog.Info("stopped sampler manager")
 	}
 }
 
 func (sm *Manager) Run() {
-	sm.Stop()
+	sm.run_mtx.Lock()
+	defer sm.run_mtx.Unlock()
+	sm.stop()
+
+	for _, ex := range sm.exports {
+		ex.Run()
+	}
 
-	sm.running.Store(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	sm.cancel = cancel
+	sm.wg.Add(1)
 	go func(sm *Manager) {
-		sm.wg.Add(1)
-		for sm.running.Load() {
+		defer sm.wg.Done()
+		for {
 			t := time.Now()
-			sm.update()
+			sm.update(ctx)
 			dt := time.Since(t)
 			if dt < sm.period {
 				select {
-				case <-sm.evt:
-					break
+				case <-ctx.Done():
 				case <-time.After(sm.period - dt):
 				}
 			}
+			if ctx.Err() != nil {
+				return
+			}
 		}
-		sm.wg.Done()
 	}(sm)
 }
 
+// Once runs a single round of sampling and returns the health of the round,
+// ex: for a one-shot check. The exports are not run.
+func (sm *Manager) Once(ctx context.Context) Health {
+	sm.update(ctx)
+	return sm.Health()
+}
+
+// NewSamplerManager creates the manager of the addresses, its metrics are
+// registered on libs.DefaultRegistry, see SetRegistry.
 func NewSamplerManager(period, sampler_timeout time.Duration, addresses []string) *Manager {
-	n := len(addresses)
+	targets := make([]Target, len(addresses))
+	for i := range addresses {
+		targets[i].Address = addresses[i]
+	}
+	return NewSamplerManagerFromTargets(period, sampler_timeout, targets)
+}
+
+// NewSamplerManagerFromTargets creates the manager from tagged targets, the
+// tags of a duplicated address are merged.
+func NewSamplerManagerFromTargets(period, sampler_timeout time.Duration, targets []Target) *Manager {
+	n := len(targets)
 
 	lut := make(map[string]*Sampler)
+	tags := make(map[string][]string)
+	var vurls []Target
 	for i := 0; i < n; i++ {
-		lut[addresses[i]] = nil
+		p := &targets[i]
+		if _, ok := lut[p.Address]; !ok {
+			lut[p.Address] = nil
+			vurls = append(vurls, Target{Address: p.Address})
+		}
+		tags[p.Address] = append(tags[p.Address], p.Tags...)
 	}
 
-	n = len(lut)
-	vurls := make([]string, n)
-	cnt := 0
-	for url := range lut {
-		vurls[cnt] = url
-		cnt++
+	n = len(vurls)
+	for i := range vurls {
+		vurls[i].Tags = libs.Unique(tags[vurls[i].Address])
+		sort.Strings(vurls[i].Tags)
 	}
 
 	group_size := (int)(period/(sampler_timeout+time.Millisecond*100) + 1)
@@ -197,7 +581,7 @@ func NewSamplerManager(period, sampler_timeout time.Duration, addresses []string
 	groups := make([]*Group, ngroups)
 	n = ngroups - 1
 	for i := 0; i < ngroups; i++ {
-		var v []string
+		var v []Target
 		if i == n {
 			v = vurls[i*group_size:]
 		} else {
@@ -205,16 +589,47 @@ func NewSamplerManager(period, sampler_timeout time.Duration, addresses []string
 		}
 		g := NewGroupSamplers(v)
 		for cnt := range v {
-			lut[v[cnt]] = &(g.data[cnt])
+			lut[v[cnt].Address] = &(g.data[cnt])
 		}
 		groups[i] = g
 	}
 
+	m := registeredMetrics()
+	for _, p := range lut {
+		p.metrics = m
+	}
 	return &Manager{
-		period:  period,
-		timeout: sampler_timeout,
-		lut:     lut,
-		groups:  groups,
-		evt:     make(chan bool),
+		period:     period,
+		timeout:    sampler_timeout,
+		lut:        lut,
+		composites: make(map[string]*Composite),
+		groups:     groups,
+		metrics:    m,
+	}
+}
+
+// NewSamplerManagerFromSites creates the manager from the content of a sites
+// file, including its composite targets.
+func NewSamplerManagerFromSites(period, sampler_timeout time.Duration, sites *Sites) (*Manager, error) {
+	sm := NewSamplerManagerFromTargets(period, sampler_timeout, sites.Targets)
+	for _, ct := range sites.Composites {
+		members := make([]*Sampler, len(ct.Members))
+		for i, m := range ct.Members {
+			p, ok := sm.lut[m]
+			if !ok {
+				return nil, fmt.Errorf("%w %s: unknown member %s", ErrInvalidComposite, ct.Name, m)
+			}
+			members[i] = p
+		}
+		sm.composites[ct.Name] = newComposite(ct, members)
+	}
+
+	g := sites.Graph()
+	order, err := g.Order()
+	if err != nil {
+		return nil, err
 	}
+	sm.parents = g.parents
+	sm.order = order
+	return sm, nil
 }
@@ -1,7 +1,12 @@
 package sampler_test
 
 import (
+	"context"
+	"fmt"
+	"net"
+	"scraper/libs"
 	sampler "scraper/sampler/src/sampler"
+	"strings"
 	"testing"
 	"time"
 )
@@ -14,3 +19,173 @@ func TestSampleManager(t *testing.T) {
 
 	t.Logf("%+v", m.GetAll())
 }
+
+func TestParseSites(t *testing.T) {
+	sites, err := sampler.ParseSites("jd.com region=cn team=retail\n\n# comment\n  live.com  \n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := sites.Targets
+	if len(targets) != 2 {
+		t.Fatalf("expected 2 targets, got %+v", targets)
+	}
+	if targets[0].Address != "jd.com" || len(targets[0].Tags) != 2 {
+		t.Errorf("unexpected target %+v", targets[0])
+	}
+
+	if !sampler.HasTags(targets[0].Tags, []string{"region=cn", "team"}) {
+		t.Errorf("expected tags to match")
+	}
+	if sampler.HasTags(targets[0].Tags, []string{"region=us"}) || sampler.HasTags(targets[1].Tags, []string{"region"}) {
+		t.Errorf("unexpected tags match")
+	}
+}
+
+func TestCompositeTargets(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	up := ln.Addr().String()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := closed.Addr().String()
+	closed.Close()
+
+	sites, err := sampler.ParseSites(fmt.Sprintf(`%[1]s
+shop-all = all(%[1]s, %[2]s) team=retail
+shop-any = any(%[1]s, %[2]s)
+shop-1of = 1of(%[1]s, %[2]s)
+`, up, down))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sites.Targets) != 2 || len(sites.Composites) != 3 {
+		t.Fatalf("unexpected sites %+v", sites)
+	}
+
+	m, err := sampler.NewSamplerManagerFromSites(time.Minute, time.Second, sites)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Run()
+	time.Sleep(time.Millisecond * 500)
+	m.Stop()
+
+	expected := map[string]bool{"shop-all": false, "shop-any": true, "shop-1of": true}
+	for name, availability := range expected {
+		p := m.GetOne(name)
+		if p == nil || p.Availability != availability {
+			t.Errorf("unexpected status of %s: %+v", name, p)
+		}
+	}
+
+	for _, data := range []string{"x = 3of(a, b)", "x = all()", "x = any(a)\nx = any(b)", "x = any(a)\ny = any(x)"} {
+		if _, err := sampler.ParseSites(data); err == nil {
+			t.Errorf("expected error for %q", data)
+		}
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := closed.Addr().String()
+	closed.Close()
+
+	sites, err := sampler.ParseSites(fmt.Sprintf("%[1]s:1 depends_on=%[2]s\n%[1]s:2", "127.0.0.1", proxy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sites.Targets) != 3 {
+		t.Fatalf("expected the dependency to be added, got %+v", sites.Targets)
+	}
+
+	m, err := sampler.NewSamplerManagerFromSites(time.Minute, time.Second, sites)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Run()
+	time.Sleep(time.Millisecond * 500)
+	m.Stop()
+
+	if p := m.GetOne("127.0.0.1:1"); p == nil || p.Availability || !p.UnreachableDueToParent {
+		t.Errorf("expected child unreachable due to parent, got %+v", p)
+	}
+	if p := m.GetOne("127.0.0.1:2"); p == nil || p.UnreachableDueToParent {
+		t.Errorf("unexpected status of independent target %+v", p)
+	}
+	if g := m.Graph(); len(g) != 2 || g[0].Target != proxy {
+		t.Errorf("unexpected graph %+v", g)
+	}
+
+	if _, err := sampler.ParseSites("a depends_on=b\nb depends_on=c\nc depends_on=a"); err == nil {
+		t.Errorf("expected dependency cycle error")
+	}
+}
+
+func TestSelfCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	up := ln.Addr().String()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gateway := closed.Addr().String()
+	closed.Close()
+
+	m := sampler.NewSamplerManager(time.Minute, time.Second, []string{up})
+	m.SetSelfCheck([]string{gateway}, 0)
+	if h := m.Health(); h.Status != sampler.HealthStarting {
+		t.Errorf("unexpected health before the first round %+v", h)
+	}
+	m.Run()
+	time.Sleep(time.Millisecond * 500)
+	m.Stop()
+
+	if h := m.Health(); h.Status != sampler.HealthDegraded {
+		t.Errorf("expected degraded sampler, got %+v", h)
+	}
+	if p := m.GetOne(up); p == nil || p.Availability || !p.Suspect {
+		t.Errorf("expected suspect result not to be applied, got %+v", p)
+	}
+}
+
+func TestManagerMetrics(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	up := ln.Addr().String()
+
+	// the families are registered on the registry of the manager
+	reg := libs.NewRegistry()
+	var b strings.Builder
+	reg.Expose(&b)
+	if b.Len() != 0 {
+		t.Errorf("unexpected metrics before the manager %q", b.String())
+	}
+
+	m := sampler.NewSamplerManager(time.Minute, time.Second, []string{up})
+	m.SetRegistry(reg)
+	m.Once(context.Background())
+	b.Reset()
+	reg.Expose(&b)
+	for _, s := range []string{`scraper_sampler_target_up{target="` + up + `"} 1`, `scraper_sampler_rounds_total{status="ok"} 1`} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("expected %s in %q", s, b.String())
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package sampler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Target is an address to sample with its tags, ex: "region=cn", "team=payments",
+// and the targets it depends on, ex: an egress proxy or a DNS resolver.
+type Target struct {
+	Address   string
+	Tags      []string
+	DependsOn []string
+}
+
+const dependsOnAttribute = "depends_on="
+
+// parseAttributes splits the fields following an address into tags and
+// dependencies, given as "depends_on=proxy.local:3128,dns.local:53".
+func parseAttributes(fields []string) (tags, deps []string) {
+	for _, f := range fields {
+		if !strings.HasPrefix(f, dependsOnAttribute) {
+			tags = append(tags, f)
+			continue
+		}
+		for _, d := range strings.Split(f[len(dependsOnAttribute):], ",") {
+			if len(d) > 0 {
+				deps = append(deps, d)
+			}
+		}
+	}
+	return
+}
+
+// Sites is the content of a sites file.
+type Sites struct {
+	Targets    []Target
+	Composites []CompositeTarget
+}
+
+// ParseSites parses the content of a sites file. Each line holds an address
+// followed by its tags separated by spaces, ex: "jd.com region=cn team=retail",
+// or a composite target, ex: "tmall = all(login.tmall.com, api.tmall.com)".
+// Members of composites and dependencies which are not listed are added as
+// untagged targets.
+// Empty lines and lines starting with '#' are skipped.
+func ParseSites(data string) (*Sites, error) {
+	sites := new(Sites)
+	names := map[string]bool{}
+	for _, line := range strings.Split(data, "\n") {
+		s := strings.TrimSpace(line)
+		if len(s) == 0 || s[0] == '#' {
+			continue
+		}
+
+		ct, ok, err := parseComposite(s)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if names[ct.Name] {
+				return nil, fmt.Errorf("%w %s: duplicated name", ErrInvalidComposite, ct.Name)
+			}
+			names[ct.Name] = true
+			sites.Composites = append(sites.Composites, *ct)
+			continue
+		}
+
+		fields := strings.Fields(s)
+		tags, deps := parseAttributes(fields[1:])
+		sites.Targets = append(sites.Targets, Target{Address: fields[0], Tags: tags, DependsOn: deps})
+	}
+
+	listed := map[string]bool{}
+	for _, p := range sites.Targets {
+		listed[p.Address] = true
+	}
+	for _, ct := range sites.Composites {
+		for _, m := range ct.Members {
+			if names[m] {
+				return nil, fmt.Errorf("%w %s: member %s is a composite", ErrInvalidComposite, ct.Name, m)
+			}
+			if !listed[m] {
+				listed[m] = true
+				sites.Targets = append(sites.Targets, Target{Address: m})
+			}
+		}
+	}
+	for name := range names {
+		if listed[name] {
+			return nil, fmt.Errorf("%w %s: name is also a target", ErrInvalidComposite, name)
+		}
+	}
+
+	for i := range sites.Targets {
+		for _, d := range sites.Targets[i].DependsOn {
+			if !listed[d] && !names[d] {
+				listed[d] = true
+				sites.Targets = append(sites.Targets, Target{Address: d})
+			}
+		}
+	}
+	for _, ct := range sites.Composites {
+		for _, d := range ct.DependsOn {
+			if !listed[d] && !names[d] {
+				listed[d] = true
+				sites.Targets = append(sites.Targets, Target{Address: d})
+			}
+		}
+	}
+
+	_, err := sites.Graph().Order()
+	if err != nil {
+		return nil, err
+	}
+	return sites, nil
+}
+
+func matchTag(tag, query string) bool {
+	if tag == query {
+		return true
+	}
+	// a query without value matches any value of the key
+	return !strings.Contains(query, "=") && strings.HasPrefix(tag, query+"=")
+}
+
+// HasTags reports whether the tags match all of the queries, a query is
+// either a full tag "region=cn" or a key "region".
+func HasTags(tags []string, queries []string) bool {
+	for _, q := range queries {
+		found := false
+		for _, t := range tags {
+			if matchTag(t, q) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterTags returns the items having all of the queried tags.
+func FilterTags(v []SampleData, queries []string) []SampleData {
+	if len(queries) == 0 {
+		return v
+	}
+	rs := make([]SampleData, 0, len(v))
+	for i := range v {
+		if HasTags(v[i].Tags, queries) {
+			rs = append(rs, v[i])
+		}
+	}
+	return rs
+}
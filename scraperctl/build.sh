@@ -0,0 +1,7 @@
+#!/bin/bash
+
+version=0.0.1
+time=$(date)
+
+echo $time': building scraperctl ...'
+go build -o ../bin/scraperctl ./src/main.go
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"scraper/client"
+	"scraper/scraperctl/src/scraperctl"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexflint/go-arg"
+)
+
+type checkArgs struct {
+	Targets []string `arg:"positional" help:"the address of a target, all the targets having the tags if none"`
+	Tags    []string `arg:"--tag,separate" help:"only the targets having the tag"`
+}
+
+type forceArgs struct {
+	Target   string        `arg:"positional,required" help:"the address of the target"`
+	Wait     time.Duration `arg:"--wait" default:"0s" help:"the time to wait for the new status of the target, ex: 2m, 0 not to wait"`
+	Interval time.Duration `arg:"--interval" default:"1s" help:"the period of the checks while waiting, at least 100ms"`
+}
+
+type fastestArgs struct {
+	Tags []string `arg:"--tag,separate" help:"only the targets having the tag"`
+}
+
+type rankArgs struct {
+	Targets []string `arg:"positional" help:"the address of a target, all the targets having the tags if none"`
+	Tags    []string `arg:"--tag,separate" help:"only the targets having the tag"`
+	Limit   int      `arg:"--limit" default:"0" help:"the number of targets listed, 0 for all"`
+	Slowest bool     `arg:"--slowest" help:"rank the slowest targets first"`
+}
+
+type usageArgs struct {
+	Users []string `arg:"--user,separate" help:"the id of a user, all users if none"`
+	From  string   `arg:"--from" default:"today" help:"the start of the range, ex: 2024-03-01, yesterday, 7d ago"`
+	To    string   `arg:"--to" default:"now" help:"the end of the range"`
+}
+
+type uptimeArgs struct {
+	Targets []string `arg:"positional,required" help:"the address of a target"`
+}
+
+type maintenanceListArgs struct{}
+
+type maintenanceAddArgs struct {
+	Targets  []string      `arg:"--target,separate" help:"the address of a target in the window"`
+	Tags     []string      `arg:"--tag,separate" help:"the targets having all of the tags are in the window"`
+	Start    string        `arg:"--start" help:"the start of a one-off window, ex: 2024-03-01 22:00, now"`
+	End      string        `arg:"--end" help:"the end of a one-off window"`
+	Cron     string        `arg:"--cron" help:"the starts of a recurring window, 5 fields in UTC, ex: '0 2 * * 1-5'"`
+	Duration time.Duration `arg:"--duration" default:"0s" help:"the length of a recurring window, ex: 30m"`
+	Comment  string        `arg:"--comment" help:"the reason of the window"`
+}
+
+type maintenanceRemoveArgs struct {
+	ID string `arg:"positional,required" help:"the id of the window"`
+}
+
+type maintenanceArgs struct {
+	List   *maintenanceListArgs   `arg:"subcommand:list" help:"list the maintenance windows"`
+	Add    *maintenanceAddArgs    `arg:"subcommand:add" help:"create a maintenance window"`
+	Remove *maintenanceRemoveArgs `arg:"subcommand:remove" help:"remove a maintenance window"`
+}
+
+type dependenciesArgs struct{}
+
+type incidentsArgs struct {
+	Open bool `arg:"--open" help:"only the incidents not resolved"`
+}
+
+type appArgs struct {
+	Config       string            `arg:"--config,env:SCRAPERCTL_CONFIG" help:"the JSON file of the profiles, default ~/.config/scraperctl/config.json"`
+	Profile      string            `arg:"-P,--profile,env:SCRAPERCTL_PROFILE" help:"the profile used, the current one of the file by default"`
+	URL          string            `arg:"--url" help:"the address of the monitor, ex: http://localhost:8090"`
+	UserKey      string            `arg:"--user_key,env:SCRAPER_USER_KEY" help:"the user's API key"`
+	AdminToken   string            `arg:"--admin_token,env:SCRAPER_ADMIN_TOKEN" help:"the admin's token"`
+	Output       string            `arg:"-o,--output" help:"the output format: table, json or csv"`
+	Timeout      int               `arg:"--timeout" help:"the timeout in second of a request"`
+	Check        *checkArgs        `arg:"subcommand:check" help:"get the status of targets"`
+	Force        *forceArgs        `arg:"subcommand:force" help:"force the update of the status of a target"`
+	Min          *fastestArgs      `arg:"subcommand:min" help:"get the fastest available target"`
+	Max          *fastestArgs      `arg:"subcommand:max" help:"get the slowest available target"`
+	Rank         *rankArgs         `arg:"subcommand:rank" help:"rank targets by access time, the unavailable ones last"`
+	Uptime       *uptimeArgs       `arg:"subcommand:uptime" help:"get the uptime of targets"`
+	Usage        *usageArgs        `arg:"subcommand:usage" help:"count the requests of users in a range of time (admin)"`
+	Maintenance  *maintenanceArgs  `arg:"subcommand:maintenance" help:"manage the maintenance windows of targets (admin)"`
+	Dependencies *dependenciesArgs `arg:"subcommand:dependencies" help:"get the dependency graph of targets (admin)"`
+	Incidents    *incidentsArgs    `arg:"subcommand:incidents" help:"list the recent incidents (admin)"`
+}
+
+// minInterval is the shortest period of the checks of force --wait.
+const minInterval = 100 * time.Millisecond
+
+var (
+	ErrNoTarget = errors.New("give targets or tags")
+	ErrTimeout  = errors.New("timed out waiting for the new status")
+	ErrInterval = fmt.Errorf("the interval is shorter than %v", minInterval)
+)
+
+var c *client.Client
+
+func main() {
+	var a appArgs
+	p := arg.MustParse(&a)
+	if p.Subcommand() == nil {
+		p.Fail("missing command")
+	}
+
+	config := a.Config
+	if config == "" {
+		config = scraperctl.DefaultConfigFile()
+	}
+	profile, err := scraperctl.LoadProfile(config, a.Profile)
+	if err != nil {
+		fail(err)
+	}
+	profile = profile.Merge(scraperctl.Profile{
+		URL:        a.URL,
+		UserKey:    a.UserKey,
+		AdminToken: a.AdminToken,
+		Output:     a.Output,
+		Timeout:    a.Timeout,
+	})
+	c, err = profile.Client()
+	if err != nil {
+		fail(err)
+	}
+
+	ctx := context.Background()
+	var t *scraperctl.Table
+	switch {
+	case a.Check != nil:
+		t, err = check(ctx, a.Check)
+	case a.Force != nil:
+		t, err = force(ctx, a.Force)
+	case a.Min != nil:
+		t, err = fastest(c.Min(ctx, a.Min.Tags...))
+	case a.Max != nil:
+		t, err = fastest(c.Max(ctx, a.Max.Tags...))
+	case a.Rank != nil:
+		t, err = rank(ctx, a.Rank)
+	case a.Uptime != nil:
+		t, err = uptime(ctx, a.Uptime)
+	case a.Usage != nil:
+		t, err = usage(ctx, a.Usage)
+	case a.Maintenance != nil:
+		t, err = maintenance(ctx, p, a.Maintenance)
+	case a.Dependencies != nil:
+		t, err = dependencies(ctx)
+	case a.Incidents != nil:
+		t, err = incidents(ctx, a.Incidents)
+	}
+	if err == nil {
+		err = t.Write(os.Stdout, profile.Output)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
+
+// ms returns the access time in millisecond
+func ms(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+func date(unix int64) string {
+	if unix == 0 {
+		return ""
+	}
+	return time.Unix(unix, 0).Format(time.RFC3339)
+}
+
+var statusHeader = []string{"target", "available", "access_ms", "maintenance", "unreachable_due_to_parent", "suspect"}
+
+func statusRow(target string, s client.Status) []string {
+	return []string{target, strconv.FormatBool(s.Availability), ms(s.AccessTime),
+		strconv.FormatBool(s.Maintenance), strconv.FormatBool(s.UnreachableDueToParent), strconv.FormatBool(s.Suspect)}
+}
+
+func statusTable(m map[string]client.Status) *scraperctl.Table {
+	t := &scraperctl.Table{Header: statusHeader, Value: m}
+	targets := make([]string, 0, len(m))
+	for k := range m {
+		targets = append(targets, k)
+	}
+	sort.Strings(targets)
+	for _, k := range targets {
+		t.Append(statusRow(k, m[k])...)
+	}
+	return t
+}
+
+func check(ctx context.Context, a *checkArgs) (*scraperctl.Table, error) {
+	if len(a.Targets) == 0 && len(a.Tags) == 0 {
+		return nil, ErrNoTarget
+	}
+	m, err := c.Check(ctx, a.Targets, a.Tags)
+	if err != nil {
+		return nil, err
+	}
+	return statusTable(m), nil
+}
+
+// force schedules the update of the target and waits until the monitor
+// reports a new status of it, ex: after the next round of the sampler. The
+// scheduled target is printed when not waiting.
+func force(ctx context.Context, a *forceArgs) (*scraperctl.Table, error) {
+	if a.Wait <= 0 {
+		err := c.Force(ctx, a.Target)
+		if err != nil {
+			return nil, err
+		}
+		t := &scraperctl.Table{Header: []string{"target"}, Value: map[string]string{"target": a.Target}}
+		t.Append(a.Target)
+		return t, nil
+	}
+	if a.Interval < minInterval {
+		return nil, ErrInterval
+	}
+
+	targets := []string{a.Target}
+	before, err := c.Check(ctx, targets, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Force(ctx, a.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(a.Wait)
+	for {
+		time.Sleep(a.Interval)
+		m, err := c.Check(ctx, targets, nil)
+		if err != nil {
+			return nil, err
+		}
+		x, ok := m[a.Target]
+		if p, found := before[a.Target]; ok && (!found || !reflect.DeepEqual(x, p)) {
+			return statusTable(m), nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w of %s after %v", ErrTimeout, a.Target, a.Wait)
+		}
+	}
+}
+
+func fastest(p *client.Target, err error) (*scraperctl.Table, error) {
+	if err != nil {
+		return nil, err
+	}
+	t := &scraperctl.Table{Header: []string{"target", "tags", "access_ms"}, Value: p}
+	if p != nil {
+		t.Append(p.Address, strings.Join(p.Tags, " "), ms(p.AccessTime))
+	}
+	return t, nil
+}
+
+func rank(ctx context.Context, a *rankArgs) (*scraperctl.Table, error) {
+	if len(a.Targets) == 0 && len(a.Tags) == 0 {
+		return nil, ErrNoTarget
+	}
+	m, err := c.Check(ctx, a.Targets, a.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	type ranked struct {
+		Rank   int    `json:"rank"`
+		Target string `json:"target"`
+		client.Status
+	}
+	v := make([]ranked, 0, len(m))
+	for k, s := range m {
+		v = append(v, ranked{Target: k, Status: s})
+	}
+	sort.Slice(v, func(i, j int) bool {
+		if v[i].Availability != v[j].Availability {
+			return v[i].Availability
+		}
+		if v[i].AccessTime != v[j].AccessTime {
+			return (v[i].AccessTime < v[j].AccessTime) != a.Slowest
+		}
+		return v[i].Target < v[j].Target
+	})
+	if a.Limit > 0 && len(v) > a.Limit {
+		v = v[:a.Limit]
+	}
+
+	t := &scraperctl.Table{Header: append([]string{"rank"}, statusHeader...), Value: v}
+	for i := range v {
+		v[i].Rank = i + 1
+		t.Append(append([]string{strconv.Itoa(i + 1)}, statusRow(v[i].Target, v[i].Status)...)...)
+	}
+	return t, nil
+}
+
+func uptime(ctx context.Context, a *uptimeArgs) (*scraperctl.Table, error) {
+	m, err := c.Uptime(ctx, a.Targets)
+	if err != nil {
+		return nil, err
+	}
+	t := &scraperctl.Table{Header: []string{"target", "up", "down", "maintenance", "unreachable_due_to_parent", "ratio"}, Value: m}
+	targets := make([]string, 0, len(m))
+	for k := range m {
+		targets = append(targets, k)
+	}
+	sort.Strings(targets)
+	for _, k := range targets {
+		x := m[k]
+		t.Append(k, strconv.FormatInt(x.Up, 10), strconv.FormatInt(x.Down, 10), strconv.FormatInt(x.Maintenance, 10),
+			strconv.FormatInt(x.UnreachableDueToParent, 10), strconv.FormatFloat(x.Ratio, 'f', 4, 64))
+	}
+	return t, nil
+}
+
+// Usage is the number of requests of a user, all users if empty
+type Usage struct {
+	User     string `json:"user,omitempty"`
+	From     int64  `json:"from"`
+	To       int64  `json:"to"`
+	Requests int64  `json:"requests"`
+}
+
+func usage(ctx context.Context, a *usageArgs) (*scraperctl.Table, error) {
+	now := time.Now()
+	from, err := scraperctl.ParseTime(a.From, now)
+	if err != nil {
+		return nil, err
+	}
+	to, err := scraperctl.ParseTime(a.To, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var v []Usage
+	if len(a.Users) == 0 {
+		n, err := c.QueryAll(ctx, from, to)
+		if err != nil {
+			return nil, err
+		}
+		v = append(v, Usage{From: from.Unix(), To: to.Unix(), Requests: n})
+	}
+	for _, user := range a.Users {
+		n, err := c.QueryOne(ctx, user, from, to)
+		if err != nil {
+			return nil, err
+		}
+		v = append(v, Usage{User: user, From: from.Unix(), To: to.Unix(), Requests: n})
+	}
+
+	t := &scraperctl.Table{Header: []string{"user", "from", "to", "requests"}, Value: v}
+	for _, x := range v {
+		user := x.User
+		if user == "" {
+			user = "*"
+		}
+		t.Append(user, date(x.From), date(x.To), strconv.FormatInt(x.Requests, 10))
+	}
+	return t, nil
+}
+
+func maintenanceTable(v []client.MaintenanceWindow, value interface{}) *scraperctl.Table {
+	t := &scraperctl.Table{Header: []string{"id", "targets", "tags", "schedule", "comment"}, Value: value}
+	for _, x := range v {
+		schedule := date(x.Start) + " - " + date(x.End)
+		if x.Cron != "" {
+			schedule = fmt.Sprintf("%s for %v", x.Cron, time.Duration(x.Duration)*time.Second)
+		}
+		t.Append(x.ID, strings.Join(x.Targets, " "), strings.Join(x.Tags, " "), schedule, x.Comment)
+	}
+	return t
+}
+
+func maintenance(ctx context.Context, p *arg.Parser, a *maintenanceArgs) (*scraperctl.Table, error) {
+	switch {
+	case a.Add != nil:
+		mw := client.MaintenanceWindow{
+			Targets:  a.Add.Targets,
+			Tags:     a.Add.Tags,
+			Cron:     a.Add.Cron,
+			Duration: int64(a.Add.Duration / time.Second),
+			Comment:  a.Add.Comment,
+		}
+		now := time.Now()
+		for _, x := range []struct {
+			s string
+			t *int64
+		}{{a.Add.Start, &mw.Start}, {a.Add.End, &mw.End}} {
+			if x.s == "" {
+				continue
+			}
+			t, err := scraperctl.ParseTime(x.s, now)
+			if err != nil {
+				return nil, err
+			}
+			*x.t = t.Unix()
+		}
+		x, err := c.AddMaintenance(ctx, mw)
+		if err != nil {
+			return nil, err
+		}
+		return maintenanceTable([]client.MaintenanceWindow{x}, x), nil
+	case a.Remove != nil:
+		err := c.RemoveMaintenance(ctx, a.Remove.ID)
+		if err != nil {
+			return nil, err
+		}
+		x := client.MaintenanceWindow{ID: a.Remove.ID}
+		return &scraperctl.Table{Header: []string{"id"}, Rows: [][]string{{x.ID}}, Value: x}, nil
+	case a.List != nil:
+		v, err := c.Maintenance(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return maintenanceTable(v, v), nil
+	}
+	p.FailSubcommand("missing command: list, add or remove", "maintenance")
+	return nil, nil
+}
+
+func dependencies(ctx context.Context) (*scraperctl.Table, error) {
+	v, err := c.Dependencies(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t := &scraperctl.Table{Header: []string{"target", "depends_on", "dependents", "available", "unreachable_due_to_parent"}, Value: v}
+	for _, x := range v {
+		t.Append(x.Target, strings.Join(x.DependsOn, " "), strings.Join(x.Dependents, " "),
+			strconv.FormatBool(x.Availability), strconv.FormatBool(x.UnreachableDueToParent))
+	}
+	return t, nil
+}
+
+func incidents(ctx context.Context, a *incidentsArgs) (*scraperctl.Table, error) {
+	v, err := c.Incidents(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if a.Open {
+		open := v[:0]
+		for _, x := range v {
+			if x.ResolvedAt == 0 {
+				open = append(open, x)
+			}
+		}
+		v = open
+	}
+
+	t := &scraperctl.Table{Header: []string{"target", "opened_at", "resolved_at", "duration"}, Value: v}
+	now := time.Now().Unix()
+	for _, x := range v {
+		end := x.ResolvedAt
+		if end == 0 {
+			end = now
+		}
+		t.Append(x.Target, date(x.OpenedAt), date(x.ResolvedAt), (time.Duration(end-x.OpenedAt) * time.Second).String())
+	}
+	return t, nil
+}
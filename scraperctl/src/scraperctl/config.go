@@ -0,0 +1,124 @@
+// Package scraperctl holds the profiles, the dates and the output formats of
+// the command-line client of the service monitor.
+package scraperctl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"scraper/client"
+	"scraper/libs"
+	"time"
+)
+
+var (
+	ErrUnknownProfile = errors.New("unknown profile")
+	ErrMissingURL     = errors.New("missing the address of the monitor")
+)
+
+// Profile is the settings of a monitor, ex: its address and credentials.
+type Profile struct {
+	URL        string `json:"url"`
+	UserKey    string `json:"user_key,omitempty"`
+	AdminToken string `json:"admin_token,omitempty"`
+	Output     string `json:"output,omitempty"`  // table, json or csv
+	Timeout    int    `json:"timeout,omitempty"` // in second
+	CAFile     string `json:"ca,omitempty"`
+	CertFile   string `json:"cert,omitempty"`
+	KeyFile    string `json:"key,omitempty"`
+}
+
+// Config is the file of the profiles, the current one is used unless another
+// one is given.
+type Config struct {
+	Current  string             `json:"current"`
+	Profiles map[string]Profile `json:"profiles"`
+}
+
+// DefaultConfigFile returns the file of the profiles in the user's
+// configuration folder, ex: ~/.config/scraperctl/config.json.
+func DefaultConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "scraperctl.json"
+	}
+	return filepath.Join(dir, "scraperctl", "config.json")
+}
+
+// LoadProfile returns the profile by name, the current one if the name is
+// empty. A missing file gives an empty profile unless a name is given.
+func LoadProfile(filename, name string) (Profile, error) {
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) && name == "" {
+		return Profile{}, nil
+	}
+	if err != nil {
+		return Profile{}, err
+	}
+
+	var cfg Config
+	err = json.Unmarshal(data, &cfg)
+	if err != nil {
+		return Profile{}, fmt.Errorf("%s: %w", filename, err)
+	}
+	if name == "" {
+		name = cfg.Current
+		if name == "" {
+			return Profile{}, nil
+		}
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return Profile{}, fmt.Errorf("%w: %s", ErrUnknownProfile, name)
+	}
+	return p, nil
+}
+
+// Merge returns the profile with the non-empty settings of x.
+func (p Profile) Merge(x Profile) Profile {
+	set := func(v *string, s string) {
+		if s != "" {
+			*v = s
+		}
+	}
+	set(&p.URL, x.URL)
+	set(&p.UserKey, x.UserKey)
+	set(&p.AdminToken, x.AdminToken)
+	set(&p.Output, x.Output)
+	set(&p.CAFile, x.CAFile)
+	set(&p.CertFile, x.CertFile)
+	set(&p.KeyFile, x.KeyFile)
+	if x.Timeout > 0 {
+		p.Timeout = x.Timeout
+	}
+	return p
+}
+
+// Client returns the client of the monitor of the profile.
+func (p Profile) Client() (*client.Client, error) {
+	if p.URL == "" {
+		return nil, ErrMissingURL
+	}
+	hc := &http.Client{Timeout: 30 * time.Second}
+	if p.Timeout > 0 {
+		hc.Timeout = time.Duration(p.Timeout) * time.Second
+	}
+	opts := libs.TLSOptions{CertFile: p.CertFile, KeyFile: p.KeyFile, CAFile: p.CAFile}
+	if opts.Enabled() {
+		cfg, err := opts.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = cfg
+		hc.Transport = transport
+	}
+	return client.New(p.URL,
+		client.WithHTTPClient(hc),
+		client.WithUserKey(p.UserKey),
+		client.WithAdminToken(p.AdminToken),
+	), nil
+}
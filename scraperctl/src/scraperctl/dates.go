@@ -0,0 +1,68 @@
+package scraperctl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidTime = errors.New("invalid time")
+
+var layouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+var units = map[string]time.Duration{
+	"s": time.Second,
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour,
+}
+
+// ParseTime returns the time written for humans, relative to now and in its
+// location:
+//   - now, today, yesterday.
+//   - a date, ex: 2024-03-01, 2024-03-01 08:30 or RFC 3339.
+//   - a time ago, ex: 90m ago, 7d ago, -2h, in s, m, h, d or w.
+//   - the unix-epoch second, ex: 1700000000.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	switch s {
+	case "now":
+		return now, nil
+	case "today":
+		return midnight, nil
+	case "yesterday":
+		return midnight.AddDate(0, 0, -1), nil
+	}
+
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0).In(now.Location()), nil
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, strings.ToUpper(s), now.Location()); err == nil {
+			return t, nil
+		}
+	}
+
+	x, ago := strings.CutSuffix(s, " ago")
+	if !ago {
+		x, ago = strings.CutPrefix(s, "-")
+	}
+	if ago && len(x) > 1 {
+		unit, ok := units[x[len(x)-1:]]
+		n, err := strconv.ParseInt(strings.TrimSpace(x[:len(x)-1]), 10, 64)
+		if ok && err == nil && n >= 0 {
+			return now.Add(-time.Duration(n) * unit), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidTime, s)
+}
@@ -0,0 +1,53 @@
+package scraperctl
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputCSV   = "csv"
+)
+
+var ErrUnknownOutput = errors.New("unknown output format")
+
+// Table is the result of a command, Value is written as it is in JSON.
+type Table struct {
+	Header []string
+	Rows   [][]string
+	Value  interface{}
+}
+
+func (t *Table) Append(row ...string) {
+	t.Rows = append(t.Rows, row)
+}
+
+// Write writes the table in the format: table, json or csv.
+func (t *Table) Write(w io.Writer, format string) error {
+	switch format {
+	case "", OutputTable:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(t.Header, "\t")))
+		for _, row := range t.Rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	case OutputJSON:
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(t.Value)
+	case OutputCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(t.Header)
+		cw.WriteAll(t.Rows)
+		return cw.Error()
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownOutput, format)
+}
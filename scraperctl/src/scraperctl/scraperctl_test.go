@@ -0,0 +1,102 @@
+package scraperctl_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"scraper/scraperctl/src/scraperctl"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC)
+	for s, expected := range map[string]time.Time{
+		"now":                  now,
+		"today":                time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC),
+		"Yesterday":            time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC),
+		"2024-03-01":           time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		"2024-03-01 08:30":     time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC),
+		"2024-03-01T08:30:00Z": time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC),
+		"1700000000":           time.Unix(1700000000, 0),
+		"90m ago":              now.Add(-90 * time.Minute),
+		"7d ago":               now.AddDate(0, 0, -7),
+		"-2h":                  now.Add(-2 * time.Hour),
+		"1w ago":               now.AddDate(0, 0, -7),
+	} {
+		x, err := scraperctl.ParseTime(s, now)
+		if err != nil || !x.Equal(expected) {
+			t.Errorf("unexpected time of %q: %v, %v", s, x, err)
+		}
+	}
+
+	for _, s := range []string{"", "tomorrow", "3y ago", "d ago", "2024-13-01"} {
+		if _, err := scraperctl.ParseTime(s, now); !errors.Is(err, scraperctl.ErrInvalidTime) {
+			t.Errorf("expected ErrInvalidTime of %q, got %v", s, err)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	table := &scraperctl.Table{
+		Header: []string{"target", "access_ms"},
+		Value:  map[string]int{"jd.com": 12},
+	}
+	table.Append("jd.com", "12.000")
+	table.Append("live.com, cn", "3.500")
+
+	for format, expected := range map[string]string{
+		scraperctl.OutputTable: "TARGET        ACCESS_MS\njd.com        12.000\nlive.com, cn  3.500\n",
+		scraperctl.OutputCSV:   "target,access_ms\njd.com,12.000\n\"live.com, cn\",3.500\n",
+		scraperctl.OutputJSON:  "{\n  \"jd.com\": 12\n}\n",
+	} {
+		var b bytes.Buffer
+		err := table.Write(&b, format)
+		if err != nil || b.String() != expected {
+			t.Errorf("unexpected %s output %q, %v", format, b.String(), err)
+		}
+	}
+	if err := table.Write(&bytes.Buffer{}, "xml"); !errors.Is(err, scraperctl.ErrUnknownOutput) {
+		t.Errorf("expected ErrUnknownOutput, got %v", err)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	if p, err := scraperctl.LoadProfile(filename, ""); err != nil || p != (scraperctl.Profile{}) {
+		t.Errorf("unexpected profile without file %+v, %v", p, err)
+	}
+
+	err := os.WriteFile(filename, []byte(`{
+		"current": "prod",
+		"profiles": {
+			"prod": {"url": "https://monitor.example.com", "admin_token": "ops", "output": "csv"},
+			"local": {"url": "http://localhost:8090", "user_key": "alice-key"}
+		}
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := scraperctl.LoadProfile(filename, "")
+	if err != nil || p.URL != "https://monitor.example.com" || p.AdminToken != "ops" {
+		t.Errorf("unexpected current profile %+v, %v", p, err)
+	}
+	p, err = scraperctl.LoadProfile(filename, "local")
+	if err != nil || p.UserKey != "alice-key" {
+		t.Errorf("unexpected profile %+v, %v", p, err)
+	}
+	if _, err = scraperctl.LoadProfile(filename, "staging"); !errors.Is(err, scraperctl.ErrUnknownProfile) {
+		t.Errorf("expected ErrUnknownProfile, got %v", err)
+	}
+
+	// the flags override the profile
+	p = p.Merge(scraperctl.Profile{Output: "json", Timeout: 5})
+	if p.URL != "http://localhost:8090" || p.Output != "json" || p.Timeout != 5 {
+		t.Errorf("unexpected merged profile %+v", p)
+	}
+	if _, err = (scraperctl.Profile{}).Client(); !errors.Is(err, scraperctl.ErrMissingURL) {
+		t.Errorf("expected ErrMissingURL, got %v", err)
+	}
+}
@@ -1,144 +1,146 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"scraper/libs"
+	"scraper/libs/middleware"
+	"scraper/libs/rpc"
 	"scraper/tracker/src/tracker"
-	"strconv"
+	"strings"
 	"time"
-
-	"github.com/alexflint/go-arg"
 )
 
 type appArgs struct {
-	Port   int    `arg:"-p,--port" default:"8091" help:"the server listening port."`
-	APIKey string `arg:"-k,--key" default:"" help:"the API key to access this service"`
-	DBFile string `arg:"-d,--db" default:"db" help:"the database file"`
+	Port            int      `arg:"-p,--port" default:"8091" help:"the server listening port."`
+	APIKey          string   `arg:"-k,--key" default:"" secret:"true" help:"the API key to access this service"`
+	GRPCPort        int      `arg:"--grpc_port" default:"0" help:"the port serving gRPC for the internal traffic, 0 to disable"`
+	TLSCert         string   `arg:"--tls_cert" default:"" help:"the certificate file to serve HTTPS, reloaded when it changes"`
+	TLSKey          string   `arg:"--tls_key" default:"" help:"the key file of the certificate"`
+	ClientCA        string   `arg:"--client_ca" default:"" help:"the CA file verifying client certificates, enables mutual TLS"`
+	TLSMinVersion   string   `arg:"--tls_min_version" default:"1.2" help:"the minimum TLS version: 1.2 or 1.3"`
+	MaxBodySize     int64    `arg:"--max_body" default:"1048576" help:"the maximum size in byte of a request body, 0 for no limit"`
+	RequestTimeout  int      `arg:"--request_timeout" default:"60" help:"the time in second to handle a request, 0 for no limit"`
+	CORSOrigins     []string `arg:"--cors_origin,separate" help:"an origin allowed to call this service from a browser, * for any"`
+	ShutdownTimeout int      `arg:"--shutdown_timeout" default:"10" help:"the time in second to drain in-flight requests on shutdown"`
+	LogLevel        string   `arg:"--log_level" default:"info" help:"the minimum level of the JSON logs: debug, info, warn or error"`
+	TraceOTLP       string   `arg:"--trace_otlp" default:"" help:"the OTLP/HTTP traces endpoint of the collector, ex: http://localhost:4318/v1/traces"`
+	TraceFile       string   `arg:"--trace_file" default:"" help:"the file the spans are appended to in OTLP JSON, for local testing"`
+	DBFile          string   `arg:"-d,--db" default:"db" help:"the database file"`
+	libs.ConfigArgs
 }
 
-var (
-	tk          *tracker.Tracker
-	checkAPIKey libs.CheckAPIKeyFn
-)
+// Validate checks the arguments once loaded from the configuration
+func (a *appArgs) Validate() error {
+	return errors.Join(
+		libs.CheckRange("port", int64(a.Port), 1, 65535),
+		libs.CheckRange("grpc_port", int64(a.GRPCPort), 0, 65535),
+		libs.CheckMin("max_body", a.MaxBodySize, 0),
+		libs.CheckMin("request_timeout", int64(a.RequestTimeout), 0),
+		libs.CheckMin("shutdown_timeout", int64(a.ShutdownTimeout), 0),
+		libs.CheckOneOf("tls_min_version", a.TLSMinVersion, "1.2", "1.3"),
+		libs.CheckOneOf("log_level", strings.ToLower(a.LogLevel), "debug", "info", "warn", "error"),
+	)
+}
 
 var (
-	ErrInvalidTimeFrom = errors.New("invalid time from")
+	server          *libs.Server
+	grpcServer      *libs.GRPCServer
+	shutdownTimeout time.Duration
+	tk              *tracker.Tracker
+	mux             = http.NewServeMux()
+	httpMetrics     = libs.NewHTTPMetrics(libs.DefaultRegistry, "scraper_tracker")
 )
 
-func one(w http.ResponseWriter, r *http.Request) {
-	if !checkAPIKey(w, r) {
-		return
+func startup() {
+	var a appArgs
+	libs.MustLoadConfig("tracker", &a)
+	err := libs.InitLogger(a.LogLevel)
+	if err != nil {
+		panic(err)
 	}
 
-	q := r.URL.Query()
-
-	user_id := q.Get("user")
-
-	s := q.Get("from")
-	from, err := strconv.ParseInt(s, 10, 64)
+	exporters, err := libs.NewSpanExporters(a.TraceOTLP, a.TraceFile)
 	if err != nil {
-		libs.BadRequest(w, err)
-		return
-	}
-	var to int64
-	s = q.Get("to")
-	if s == "" {
-		to = time.Now().Unix()
-	} else {
-		to, err = strconv.ParseInt(s, 10, 64)
-		if err != nil {
-			libs.BadRequest(w, err)
-			return
-		}
+		panic(err)
 	}
+	libs.DefaultTracer.Init("tracker", exporters...)
 
-	n, err := tk.QueryOne(r.Context(), user_id, from, to)
+	tk = new(tracker.Tracker)
+
+	slog.Info("open database", "folder", a.DBFile)
+	err = tk.Init(a.DBFile)
 	if err != nil {
-		libs.InternalServerError(w, err)
-		return
+		panic(err)
 	}
-	s = strconv.FormatInt(n, 10)
-	w.Write([]byte(s))
-}
 
-func all(w http.ResponseWriter, r *http.Request) {
-	if !checkAPIKey(w, r) {
-		return
+	api_key := middleware.APIKey(a.APIKey)
+	for pattern, fn := range tk.Routes() {
+		handle(pattern, fn, api_key)
 	}
-
-	q := r.URL.Query()
-	s := q.Get("from")
-	from, err := strconv.ParseInt(s, 10, 64)
+	mux.HandleFunc("/metrics", libs.MetricsHandler)
+	mux.HandleFunc(libs.APIPrefix+"/", libs.NotFound)
+	handle("/healthz", libs.Healthz)
+	handle("/readyz", libs.MakeReadyz(5*time.Second, map[string]libs.ReadyCheck{
+		"database": func(ctx context.Context) (interface{}, error) {
+			return nil, tk.Ping(ctx)
+		},
+	}))
+
+	var tls_opts *libs.TLSOptions
+	if a.TLSCert != "" {
+		tls_opts = &libs.TLSOptions{CertFile: a.TLSCert, KeyFile: a.TLSKey, CAFile: a.ClientCA, MinVersion: a.TLSMinVersion}
+	}
+	handler := middleware.Service(mux, middleware.Options{
+		MaxBodySize: a.MaxBodySize,
+		Timeout:     time.Duration(a.RequestTimeout) * time.Second,
+		CORSOrigins: a.CORSOrigins,
+	})
+	server, err = libs.NewServer(a.Port, handler, tls_opts)
 	if err != nil {
-		libs.BadRequest(w, err)
-		return
+		panic(err)
 	}
-	var to int64
-	s = q.Get("to")
-	if s == "" {
-		to = time.Now().Unix()
-	} else {
-		to, err = strconv.ParseInt(s, 10, 64)
+	server.Start()
+
+	if a.GRPCPort > 0 {
+		grpcServer, err = libs.NewGRPCServer(a.GRPCPort, a.APIKey, tls_opts)
 		if err != nil {
-			libs.BadRequest(w, err)
-			return
+			panic(err)
 		}
+		rpc.RegisterTrackerServer(grpcServer, tracker.NewGRPCService(tk))
+		grpcServer.Start()
 	}
-
-	log.Printf("admin query all from %d to %d", from, to)
-
-	n, err := tk.QueryAll(r.Context(), from, to)
-	s = strconv.FormatInt(n, 10)
-	w.Write([]byte(s))
+	shutdownTimeout = time.Duration(a.ShutdownTimeout) * time.Second
 }
 
-func update(w http.ResponseWriter, r *http.Request) {
-	if !checkAPIKey(w, r) {
-		return
-	}
-	var info map[string]int64
-	err := libs.JSONParse(r, &info)
-	if err != nil {
-		libs.InternalServerError(w, err)
-		return
-	}
-	err = tk.Update(r.Context(), info)
-	if err != nil {
-		libs.InternalServerError(w, err)
-		return
+// handle registers the handler under the pattern and its versioned one,
+// wrapped with the middlewares, their requests and latencies are exported
+// under their pattern
+func handle(pattern string, fn http.HandlerFunc, mws ...middleware.Middleware) {
+	for _, p := range []string{pattern, libs.APIPrefix + pattern} {
+		mux.Handle(p, middleware.Chain(fn, append([]middleware.Middleware{middleware.Metrics(httpMetrics, p)}, mws...)...))
 	}
 }
 
-func startup() {
-	var a appArgs
-	arg.MustParse(&a)
-
-	checkAPIKey = libs.MakeCheckAPIKey(a.APIKey)
-
-	tk = new(tracker.Tracker)
+func exec() {
+	libs.WaitCtrlC()
 
-	log.Print("open database folder: ", a.DBFile)
-	err := tk.Init(a.DBFile)
+	// the in-flight updates are written before closing the database
+	if grpcServer != nil {
+		grpcServer.Shutdown(shutdownTimeout)
+	}
+	err := server.Shutdown(shutdownTimeout)
 	if err != nil {
-		panic(err)
+		slog.Error("shutdown server", "error", err)
 	}
-
-	http.HandleFunc("/admin_query_one", one)
-	http.HandleFunc("/admin_query_all", all)
-	http.HandleFunc("/update", update)
-
-	go libs.Serve(a.Port)
-}
-
-func exec() {
-	libs.WaitCtrlC()
-	err := tk.Close()
+	err = tk.Close()
 	if err != nil {
-		log.Print(err)
+		slog.Error("close database", "error", err)
 	}
+	libs.DefaultTracer.Shutdown(5 * time.Second)
 	fmt.Println("bye bye!")
 }
 
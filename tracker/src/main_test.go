@@ -0,0 +1,27 @@
+package main
+
+import (
+	"scraper/libs"
+	"strings"
+	"testing"
+)
+
+func TestEffectiveConfigRedacted(t *testing.T) {
+	var a appArgs
+	environ := map[string]string{"SCRAPER_TRACKER_KEY": "topsecret"}
+	_, err := libs.LoadConfig("tracker", &a, []string{
+		"--trace_otlp", "http://collector:pw@localhost:4318/v1/traces?token=pw",
+	}, func(k string) (string, bool) {
+		v, ok := environ[k]
+		return v, ok
+	})
+	if err != nil || a.APIKey != "topsecret" {
+		t.Fatalf("unexpected arguments %+v, %v", a, err)
+	}
+
+	var b strings.Builder
+	err = libs.WriteConfig(&b, "tracker", &a)
+	if err != nil || strings.Contains(b.String(), "topsecret") || strings.Contains(b.String(), "pw") {
+		t.Errorf("secrets in the effective configuration %q, %v", b.String(), err)
+	}
+}
@@ -0,0 +1,254 @@
+package tracker
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"scraper/libs"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalidTimeFrom = errors.New("invalid time from")
+)
+
+func (tk *Tracker) one(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to, err := ParseRange(q)
+	if err != nil {
+		libs.BadRequest(w, err)
+		return
+	}
+	from, to = TimeRange(from, to)
+
+	n, err := tk.QueryOne(r.Context(), q.Get("user"), from, to)
+	if err != nil {
+		libs.InternalServerError(w, err)
+		return
+	}
+	replyCount(w, n)
+}
+
+func (tk *Tracker) all(w http.ResponseWriter, r *http.Request) {
+	from, to, err := ParseRange(r.URL.Query())
+	if err != nil {
+		libs.BadRequest(w, err)
+		return
+	}
+	from, to = TimeRange(from, to)
+
+	slog.InfoContext(r.Context(), "admin query all", "from", from, "to", to)
+
+	n, err := tk.QueryAll(r.Context(), from, to)
+	if err != nil {
+		libs.InternalServerError(w, err)
+		return
+	}
+	replyCount(w, n)
+}
+
+// Count is the reply of the versioned admin queries
+type Count struct {
+	Count int64 `json:"count"`
+}
+
+// replyCount replies the number as JSON, or as text to the unversioned API
+func replyCount(w http.ResponseWriter, n int64) {
+	if libs.Versioned(w) {
+		libs.JSONReply(w, Count{Count: n})
+		return
+	}
+	w.Write([]byte(strconv.FormatInt(n, 10)))
+}
+
+// Written is the reply of the versioned ingestion of counters and audit entries
+type Written struct {
+	Written int `json:"written"`
+}
+
+// ParseRange returns the time range of the parameters from and to of a query,
+// the end is zero when not given, see TimeRange.
+func ParseRange(q url.Values) (from, to int64, err error) {
+	from, err = strconv.ParseInt(q.Get("from"), 10, 64)
+	if err != nil {
+		return
+	}
+	if s := q.Get("to"); s != "" {
+		to, err = strconv.ParseInt(s, 10, 64)
+	}
+	return
+}
+
+// TimeRange returns the range of a query, a zero end is the current time.
+func TimeRange(from, to int64) (int64, int64) {
+	if to == 0 {
+		to = time.Now().Unix()
+	}
+	return from, to
+}
+
+func (tk *Tracker) audit(w http.ResponseWriter, r *http.Request) {
+	if !checkPost(w, r) {
+		return
+	}
+
+	var entries []AuditEntry
+	err := libs.JSONParse(r, &entries)
+	if err != nil {
+		libs.BadRequest(w, err)
+		return
+	}
+	err = tk.AppendAudit(r.Context(), entries)
+	if err != nil {
+		libs.InternalServerError(w, err)
+		return
+	}
+	if libs.Versioned(w) {
+		libs.JSONReply(w, Written{Written: len(entries)})
+	}
+}
+
+func (tk *Tracker) auditQuery(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, to, err := ParseRange(q)
+	if err != nil {
+		libs.BadRequest(w, err)
+		return
+	}
+	from, to = TimeRange(from, to)
+
+	v, err := tk.QueryAudit(r.Context(), from, to, q.Get("actor"))
+	if err != nil {
+		libs.InternalServerError(w, err)
+		return
+	}
+	libs.JSONReply(w, v)
+}
+
+func (tk *Tracker) update(w http.ResponseWriter, r *http.Request) {
+	var info map[string]int64
+	err := libs.JSONParse(r, &info)
+	if err != nil {
+		libs.InternalServerError(w, err)
+		return
+	}
+	err = tk.Update(r.Context(), info)
+	if err != nil {
+		libs.InternalServerError(w, err)
+		return
+	}
+	if libs.Versioned(w) {
+		libs.JSONReply(w, Written{Written: len(info)})
+	}
+}
+
+// UserKey is the reply of the user's key management
+type UserKey struct {
+	UserID string `json:"user_id"`
+	Key    string `json:"key,omitempty"`
+}
+
+func userError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidUserID):
+		libs.BadRequest(w, err)
+	case errors.Is(err, ErrUserExists):
+		libs.ServerError(w, err, http.StatusConflict)
+	case errors.Is(err, ErrUserNotFound):
+		libs.ServerError(w, err, http.StatusNotFound)
+	case errors.Is(err, ErrInvalidKey):
+		libs.ServerError(w, err, http.StatusUnauthorized)
+	default:
+		libs.InternalServerError(w, err)
+	}
+}
+
+func checkPost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		libs.ServerError(w, libs.ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func (tk *Tracker) userCreate(w http.ResponseWriter, r *http.Request) {
+	if !checkPost(w, r) {
+		return
+	}
+
+	user_id := r.URL.Query().Get("user")
+	key, err := tk.CreateUser(r.Context(), user_id)
+	if err != nil {
+		userError(w, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "create user", "user_id", user_id)
+	libs.JSONReply(w, UserKey{UserID: user_id, Key: key})
+}
+
+func (tk *Tracker) userRotate(w http.ResponseWriter, r *http.Request) {
+	if !checkPost(w, r) {
+		return
+	}
+
+	user_id := r.URL.Query().Get("user")
+	key, err := tk.RotateKey(r.Context(), user_id)
+	if err != nil {
+		userError(w, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "rotate key", "user_id", user_id)
+	libs.JSONReply(w, UserKey{UserID: user_id, Key: key})
+}
+
+func (tk *Tracker) userRevoke(w http.ResponseWriter, r *http.Request) {
+	if !checkPost(w, r) {
+		return
+	}
+
+	user_id := r.URL.Query().Get("user")
+	err := tk.RevokeKey(r.Context(), user_id)
+	if err != nil {
+		userError(w, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "revoke key", "user_id", user_id)
+	libs.JSONReply(w, UserKey{UserID: user_id})
+}
+
+func (tk *Tracker) auth(w http.ResponseWriter, r *http.Request) {
+	if !checkPost(w, r) {
+		return
+	}
+
+	var req UserKey
+	err := libs.JSONParse(r, &req)
+	if err != nil {
+		libs.BadRequest(w, err)
+		return
+	}
+	user_id, err := tk.Authenticate(r.Context(), req.Key)
+	if err != nil {
+		userError(w, err)
+		return
+	}
+	libs.JSONReply(w, UserKey{UserID: user_id})
+}
+
+// Routes returns the handlers of the JSON API by pattern, they are served by
+// the service and called in process by the all-in-one monitor.
+func (tk *Tracker) Routes() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/admin_query_one":   tk.one,
+		"/admin_query_all":   tk.all,
+		"/admin_user_create": tk.userCreate,
+		"/admin_user_rotate": tk.userRotate,
+		"/admin_user_revoke": tk.userRevoke,
+		"/update":            tk.update,
+		"/auth":              tk.auth,
+		"/audit":             tk.audit,
+		"/admin_audit":       tk.auditQuery,
+	}
+}
@@ -0,0 +1,75 @@
+package tracker
+
+import (
+	"context"
+	"scraper/libs"
+	"time"
+)
+
+// AuditEntry is an admin request handled by the monitor.
+type AuditEntry struct {
+	Actor     string `json:"actor"` // the name of the admin token
+	Endpoint  string `json:"endpoint"`
+	Params    string `json:"params,omitempty"`
+	Status    int    `json:"status"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+func (tk *Tracker) initAudit() error {
+	_, err := tk.db.Exec(`
+	CREATE TABLE IF NOT EXISTS audit(actor TEXT, endpoint TEXT, params TEXT, status INTEGER, created_at INTEGER);
+	CREATE INDEX IF NOT EXISTS audit_created_at ON audit(created_at);
+	`)
+	return err
+}
+
+// AppendAudit records the entries, the audit table is append-only: there is
+// no way to update or delete an entry.
+func (tk *Tracker) AppendAudit(ctx context.Context, entries []AuditEntry) error {
+	tx, err := tk.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, p := range entries {
+		_, err := tx.ExecContext(ctx, `INSERT INTO audit(actor, endpoint, params, status, created_at) VALUES(?, ?, ?, ?, ?);`,
+			p.Actor, p.Endpoint, p.Params, p.Status, p.CreatedAt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// QueryAudit returns the entries in a range of time, the oldest first,
+// optionally only the ones of an actor.
+func (tk *Tracker) QueryAudit(ctx context.Context, from, to int64, actor string) ([]AuditEntry, error) {
+	defer tk.metrics.queryLatency.ObserveDuration(time.Now(), "audit")
+	ctx, span := libs.StartSpan(ctx, "db query audit", libs.SpanInternal)
+	defer span.End()
+	query := `SELECT actor, endpoint, params, status, created_at FROM audit WHERE created_at >= ? AND created_at < ?`
+	args := []interface{}{from, to}
+	if actor != "" {
+		query += ` AND actor = ?`
+		args = append(args, actor)
+	}
+	query += ` ORDER BY created_at;`
+
+	rows, err := tk.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	v := []AuditEntry{}
+	for rows.Next() {
+		var p AuditEntry
+		err = rows.Scan(&p.Actor, &p.Endpoint, &p.Params, &p.Status, &p.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		v = append(v, p)
+	}
+	return v, rows.Err()
+}
@@ -3,20 +3,30 @@ package tracker
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"scraper/libs"
 	"time"
 
 	_ "github.com/genjidb/genji/driver"
 )
 
+var ErrNotOpen = errors.New("database is not open")
+
 type Tracker struct {
-	db *sql.DB
+	db      *sql.DB
+	metrics *metrics
 }
 
 type UsersRequests map[string]int // user_id -> number_requests
 type row struct {
 }
 
+// Init opens the database, the metrics are registered on
+// libs.DefaultRegistry unless SetRegistry was called.
 func (tk *Tracker) Init(db_instance string) error {
+	if tk.metrics == nil {
+		tk.metrics = registeredMetrics()
+	}
 	db, err := sql.Open("genji", db_instance)
 	if err != nil {
 		return err
@@ -24,20 +34,47 @@ func (tk *Tracker) Init(db_instance string) error {
 	_, err = db.Exec(`
 	CREATE TABLE IF NOT EXISTS requests(user_id TEXT, created_at INTEGER, nreq INTEGER);
 	CREATE INDEX ON requests(user_id, created_at);
+	CREATE TABLE IF NOT EXISTS readiness(id INTEGER PRIMARY KEY, checked_at INTEGER);
 	`)
 	if err != nil {
 		return err
 	}
 
 	tk.db = db
-	return nil
+	if err = tk.initUsers(); err != nil {
+		return err
+	}
+	return tk.initAudit()
+}
+
+// Ping checks the database is open and writable, the time of the check is
+// written to the single row of the table readiness.
+func (tk *Tracker) Ping(ctx context.Context) error {
+	if tk.db == nil {
+		return ErrNotOpen
+	}
+	_, err := tk.db.ExecContext(ctx, `INSERT INTO readiness(id, checked_at) VALUES(1, ?) ON CONFLICT DO REPLACE;`, time.Now().Unix())
+	return err
 }
 
 func (tk *Tracker) Close() error {
 	return tk.db.Close()
 }
 
-func (tk *Tracker) Update(ctx context.Context, info map[string]int64) error {
+func (tk *Tracker) Update(ctx context.Context, info map[string]int64) (err error) {
+	ctx, span := libs.StartSpan(ctx, "db update", libs.SpanInternal)
+	span.SetAttr("rows", len(info))
+	defer func() {
+		span.SetError(err)
+		span.End()
+		if err != nil {
+			tk.metrics.ingestBatches.Inc("error")
+			return
+		}
+		tk.metrics.ingestBatches.Inc("ok")
+		tk.metrics.rowsWritten.Add(float64(len(info)))
+	}()
+
 	t := time.Now().Unix()
 
 	tx, err := tk.db.BeginTx(ctx, nil)
@@ -78,9 +115,15 @@ func (tk *Tracker) parseRow(r *sql.Row) (int64, error) {
 }
 
 func (tk *Tracker) QueryOne(ctx context.Context, user_id string, from, to int64) (int64, error) {
+	defer tk.metrics.queryLatency.ObserveDuration(time.Now(), "one")
+	ctx, span := libs.StartSpan(ctx, "db query one", libs.SpanInternal)
+	defer span.End()
 	return tk.parseRow(tk.db.QueryRowContext(ctx, `SELECT SUM(nreq) AS NumberRequests FROM requests WHERE user_id = ? AND created_at >= ? AND created_at < ?;`, user_id, from, to))
 }
 
 func (tk *Tracker) QueryAll(ctx context.Context, from, to int64) (int64, error) {
+	defer tk.metrics.queryLatency.ObserveDuration(time.Now(), "all")
+	ctx, span := libs.StartSpan(ctx, "db query all", libs.SpanInternal)
+	defer span.End()
 	return tk.parseRow(tk.db.QueryRowContext(ctx, `SELECT SUM(nreq) AS NumberRequests FROM requests WHERE created_at >= ? and created_at < ?;`, from, to))
 }
@@ -0,0 +1,45 @@
+package tracker
+
+import (
+	"context"
+	"scraper/libs"
+	"scraper/libs/rpc"
+
+	"google.golang.org/grpc/codes"
+)
+
+// GRPCService serves the counters of the tracker over gRPC.
+type GRPCService struct {
+	rpc.UnimplementedTrackerServer
+	tk *Tracker
+}
+
+func NewGRPCService(tk *Tracker) *GRPCService {
+	return &GRPCService{tk: tk}
+}
+
+func (gs *GRPCService) Update(ctx context.Context, req *rpc.UpdateRequest) (*rpc.UpdateReply, error) {
+	err := gs.tk.Update(ctx, req.GetCounters())
+	if err != nil {
+		return nil, libs.GRPCError(err, codes.Internal)
+	}
+	return &rpc.UpdateReply{Written: int64(len(req.GetCounters()))}, nil
+}
+
+func (gs *GRPCService) QueryOne(ctx context.Context, req *rpc.QueryOneRequest) (*rpc.Count, error) {
+	from, to := TimeRange(req.GetFrom(), req.GetTo())
+	n, err := gs.tk.QueryOne(ctx, req.GetUser(), from, to)
+	if err != nil {
+		return nil, libs.GRPCError(err, codes.Internal)
+	}
+	return &rpc.Count{Count: n}, nil
+}
+
+func (gs *GRPCService) QueryAll(ctx context.Context, req *rpc.QueryAllRequest) (*rpc.Count, error) {
+	from, to := TimeRange(req.GetFrom(), req.GetTo())
+	n, err := gs.tk.QueryAll(ctx, from, to)
+	if err != nil {
+		return nil, libs.GRPCError(err, codes.Internal)
+	}
+	return &rpc.Count{Count: n}, nil
+}
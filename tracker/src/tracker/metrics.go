@@ -0,0 +1,44 @@
+package tracker
+
+import (
+	"scraper/libs"
+	"sync"
+)
+
+// metrics are the metric families of a tracker
+type metrics struct {
+	ingestBatches *libs.Counter
+	rowsWritten   *libs.Counter
+	queryLatency  *libs.Histogram
+}
+
+func newMetrics(reg *libs.Registry) *metrics {
+	return &metrics{
+		ingestBatches: reg.NewCounter("scraper_tracker_ingest_batches_total",
+			"The number of batches of counters received by result, ok or error.", "result"),
+		rowsWritten: reg.NewCounter("scraper_tracker_rows_written_total",
+			"The number of rows of requests committed to the database."),
+		queryLatency: reg.NewHistogram("scraper_tracker_query_duration_seconds",
+			"The latency of the database queries: one, all or audit.", nil, "query"),
+	}
+}
+
+var (
+	defaultMetrics     *metrics
+	defaultMetricsOnce sync.Once
+)
+
+// registeredMetrics returns the metrics on libs.DefaultRegistry, registered
+// by the first tracker
+func registeredMetrics() *metrics {
+	defaultMetricsOnce.Do(func() {
+		defaultMetrics = newMetrics(libs.DefaultRegistry)
+	})
+	return defaultMetrics
+}
+
+// SetRegistry registers the metrics of the tracker on the registry instead of
+// libs.DefaultRegistry, ex: in a test, before Init.
+func (tk *Tracker) SetRegistry(reg *libs.Registry) {
+	tk.metrics = newMetrics(reg)
+}
@@ -1 +1,122 @@
 package tracker_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"scraper/tracker/src/tracker"
+	"testing"
+	"time"
+)
+
+func TestUsers(t *testing.T) {
+	tk := new(tracker.Tracker)
+	err := tk.Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.Close()
+
+	ctx := context.Background()
+	key, err := tk.CreateUser(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tk.CreateUser(ctx, "alice"); !errors.Is(err, tracker.ErrUserExists) {
+		t.Errorf("expected ErrUserExists, got %v", err)
+	}
+
+	user_id, err := tk.Authenticate(ctx, key)
+	if err != nil || user_id != "alice" {
+		t.Errorf("unexpected authentication %q, %v", user_id, err)
+	}
+
+	rotated, err := tk.RotateKey(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tk.Authenticate(ctx, key); !errors.Is(err, tracker.ErrInvalidKey) {
+		t.Errorf("expected the previous key to be invalid, got %v", err)
+	}
+	if user_id, err = tk.Authenticate(ctx, rotated); err != nil || user_id != "alice" {
+		t.Errorf("unexpected authentication %q, %v", user_id, err)
+	}
+
+	if err = tk.RevokeKey(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tk.Authenticate(ctx, rotated); !errors.Is(err, tracker.ErrInvalidKey) {
+		t.Errorf("expected the revoked key to be invalid, got %v", err)
+	}
+	if err = tk.RevokeKey(ctx, "bob"); !errors.Is(err, tracker.ErrUserNotFound) {
+		t.Errorf("expected ErrUserNotFound, got %v", err)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	tk := new(tracker.Tracker)
+	err := tk.Init(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tk.Close()
+
+	ctx := context.Background()
+	err = tk.AppendAudit(ctx, []tracker.AuditEntry{
+		{Actor: "ops", Endpoint: "GET /admin_query_one", Params: "user=alice&from=0", Status: 200, CreatedAt: 100},
+		{Actor: "root", Endpoint: "POST /admin_user_create", Params: "user=bob", Status: 200, CreatedAt: 200},
+		{Actor: "ops", Endpoint: "GET /admin_query_all", Params: "from=0", Status: 200, CreatedAt: 300},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := tk.QueryAudit(ctx, 0, 1000, "ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 2 || v[0].CreatedAt != 100 || v[1].Endpoint != "GET /admin_query_all" {
+		t.Errorf("unexpected entries %+v", v)
+	}
+
+	v, err = tk.QueryAudit(ctx, 150, 300, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 1 || v[0].Actor != "root" {
+		t.Errorf("unexpected entries %+v", v)
+	}
+}
+
+func TestPing(t *testing.T) {
+	var tk tracker.Tracker
+	if err := tk.Ping(context.Background()); !errors.Is(err, tracker.ErrNotOpen) {
+		t.Errorf("expected ErrNotOpen, got %v", err)
+	}
+	dir := t.TempDir()
+	err := tk.Init(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	start := time.Now().Unix()
+	for i := 0; i < 2; i++ {
+		if err = tk.Ping(ctx); err != nil {
+			t.Errorf("unexpected ping error %v", err)
+		}
+	}
+	tk.Close()
+
+	// the probe writes the time of the check in a single row
+	db, err := sql.Open("genji", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var n, checked_at int64
+	err = db.QueryRow(`SELECT COUNT(*), MAX(checked_at) FROM readiness;`).Scan(&n, &checked_at)
+	if err != nil || n != 1 || checked_at < start {
+		t.Errorf("unexpected probe %d rows at %d, %v", n, checked_at, err)
+	}
+}
@@ -0,0 +1,124 @@
+package tracker
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidUserID = errors.New("invalid user id")
+	ErrUserExists    = errors.New("user already exists")
+	ErrUserNotFound  = errors.New("user not found")
+	ErrInvalidKey    = errors.New("invalid API key")
+)
+
+// HashKey returns the hash of an API key, keys are never stored in clear.
+func HashKey(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+func newKey() (string, error) {
+	b := make([]byte, 24)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (tk *Tracker) initUsers() error {
+	_, err := tk.db.Exec(`
+	CREATE TABLE IF NOT EXISTS users(user_id TEXT PRIMARY KEY, key_hash TEXT, created_at INTEGER, revoked_at INTEGER);
+	CREATE INDEX IF NOT EXISTS users_key_hash ON users(key_hash);
+	`)
+	return err
+}
+
+// CreateUser registers a user and returns its API key.
+func (tk *Tracker) CreateUser(ctx context.Context, user_id string) (string, error) {
+	if user_id == "" {
+		return "", ErrInvalidUserID
+	}
+	key, err := newKey()
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := tk.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var n int64
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE user_id = ?;`, user_id).Scan(&n)
+	if err != nil {
+		return "", err
+	}
+	if n > 0 {
+		return "", ErrUserExists
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO users(user_id, key_hash, created_at, revoked_at) VALUES(?, ?, ?, 0);`, user_id, HashKey(key), time.Now().Unix())
+	if err != nil {
+		return "", err
+	}
+	return key, tx.Commit()
+}
+
+func (tk *Tracker) setKeyHash(ctx context.Context, user_id, key_hash string, revoked_at int64) error {
+	tx, err := tk.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var n int64
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE user_id = ?;`, user_id).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET key_hash = ?, revoked_at = ? WHERE user_id = ?;`, key_hash, revoked_at, user_id)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RotateKey issues a new API key to the user, the previous key is no longer valid.
+func (tk *Tracker) RotateKey(ctx context.Context, user_id string) (string, error) {
+	key, err := newKey()
+	if err != nil {
+		return "", err
+	}
+	return key, tk.setKeyHash(ctx, user_id, HashKey(key), 0)
+}
+
+// RevokeKey invalidates the API key of the user until a new one is rotated.
+func (tk *Tracker) RevokeKey(ctx context.Context, user_id string) error {
+	return tk.setKeyHash(ctx, user_id, "", time.Now().Unix())
+}
+
+// Authenticate returns the id of the user owning the API key.
+func (tk *Tracker) Authenticate(ctx context.Context, key string) (string, error) {
+	if key == "" {
+		return "", ErrInvalidKey
+	}
+
+	var user_id string
+	err := tk.db.QueryRowContext(ctx, `SELECT user_id FROM users WHERE key_hash = ? AND revoked_at = 0;`, HashKey(key)).Scan(&user_id)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidKey
+	}
+	return user_id, err
+}